@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"github.com/IBM/sarama"
+	"github.com/MuxiKeStack/be-comment/events"
+	"github.com/MuxiKeStack/be-comment/job"
+	"github.com/MuxiKeStack/be-comment/pkg/grpcx"
+	"github.com/MuxiKeStack/be-comment/pkg/idgen"
+	"github.com/MuxiKeStack/be-comment/pkg/lifecycle"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/MuxiKeStack/be-comment/pkg/saramax"
+	"github.com/redis/go-redis/v9"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"gorm.io/gorm"
+)
+
+type App struct {
+	server    grpcx.Server
+	internal  *grpcx.InternalServer
+	consumers []saramax.Consumer
+	runners   []*job.IntervalRunner
+	// 下面这些只在退出的时候用来按顺序关闭
+	producer events.Producer
+	kafka    sarama.Client
+	db       *gorm.DB
+	redis    redis.UniversalClient
+	etcd     *clientv3.Client
+	l        logger.Logger
+	// 服务停了之后就不会再生成 id 了，可以释放节点号
+	idLease *idgen.NodeLease
+}
+
+// lifecycle 关闭的顺序：
+// 先停 RPC，等正在处理的请求（会同步发送 comment_write）处理完；
+// 再停定时任务和消费者，消费者落库之后还要发送 feed 事件，所以要在生产者之前停；
+// 然后把生产者缓冲区里面的 feed 事件发完；最后关闭各个客户端
+func (app *App) lifecycle() *lifecycle.Manager {
+	m := lifecycle.NewManager(app.l)
+	m.AppendCloser("grpc server", app.server.Close)
+	m.AppendCloser("internal grpc server", app.internal.Close)
+	m.AppendCloser("idgen node lease", app.idLease.Close)
+	m.Append("job runners", func(ctx context.Context) error {
+		for _, r := range app.runners {
+			r.Stop()
+		}
+		return nil
+	})
+	for _, c := range app.consumers {
+		m.AppendCloser("consumer", c.Close)
+	}
+	m.AppendCloser("producer", app.producer.Close)
+	m.AppendCloser("kafka client", app.kafka.Close)
+	m.AppendCloser("mysql", func() error {
+		db, err := app.db.DB()
+		if err != nil {
+			return err
+		}
+		return db.Close()
+	})
+	m.AppendCloser("redis", app.redis.Close)
+	m.AppendCloser("etcd", app.etcd.Close)
+	return m
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/MuxiKeStack/be-comment/migration"
+	"github.com/MuxiKeStack/be-comment/service"
+	"github.com/spf13/pflag"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func runCommand(name string, args []string) {
+	fs := pflag.NewFlagSet(name, pflag.ExitOnError)
+	switch name {
+	case "reconcile":
+		// 手动触发一次评论数对账
+		timeout := fs.Duration("timeout", time.Minute*30, "超时时间")
+		initViper(fs, args)
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		err := InitCommentCountReconcileJob().Run(ctx)
+		if err != nil {
+			panic(err)
+		}
+	case "dlq-replay":
+		// 把死信队列里面的消息重新投递回原来的 topic
+		topic := fs.String("topic", "comment_write_dlq", "死信队列 topic")
+		limit := fs.Int64("limit", 1000, "最多投递多少条")
+		idle := fs.Duration("idle", time.Second*10, "多久没有新消息就认为已经消费完了")
+		initViper(fs, args)
+		replayed, err := InitDLQReplayer().Replay(context.Background(), *topic, *limit, *idle)
+		fmt.Printf("重新投递了 %d 条消息\n", replayed)
+		if err != nil {
+			panic(err)
+		}
+	case "import-legacy":
+		// 导入老系统导出的评论，例如 be-comment import-legacy --file comments.ndjson --dry-run
+		file := fs.String("file", "", "导出文件路径")
+		format := fs.String("format", "", "ndjson 或者 csv，默认按照文件后缀判断")
+		batch := fs.Int("batch", 500, "每一批导入多少条")
+		checkpoint := fs.String("checkpoint", "", "断点文件路径，默认是导出文件路径加上 .checkpoint.json")
+		report := fs.String("report", "", "汇总报告的输出路径，默认只打印")
+		dryRun := fs.Bool("dry-run", false, "只校验不写入")
+		initViper(fs, args)
+		if *file == "" {
+			panic("缺少 --file")
+		}
+		if *format == "" {
+			*format = strings.TrimPrefix(filepath.Ext(*file), ".")
+		}
+		if *checkpoint == "" {
+			*checkpoint = *file + ".checkpoint.json"
+		}
+		res, err := InitLegacyImporter().Import(context.Background(), migration.ImportOptions{
+			File:           *file,
+			Format:         *format,
+			BatchSize:      *batch,
+			CheckpointFile: *checkpoint,
+			DryRun:         *dryRun,
+		})
+		data, er := json.MarshalIndent(res, "", "  ")
+		if er != nil {
+			panic(er)
+		}
+		fmt.Println(string(data))
+		if *report != "" {
+			er = os.WriteFile(*report, data, 0644)
+			if er != nil {
+				panic(er)
+			}
+		}
+		if err != nil {
+			panic(err)
+		}
+	case "export":
+		// 导出评论，例如 be-comment export --biz Evaluation --biz-id 1 --format csv --output comments.csv
+		biz := fs.String("biz", "", "业务类型，数字或者名字")
+		bizId := fs.Int64("biz-id", 0, "业务 id")
+		uid := fs.Int64("uid", 0, "评论者")
+		start := fs.String("start", "", "创建时间的开始，包含，格式 2006-01-02 或者 2006-01-02 15:04:05")
+		end := fs.String("end", "", "创建时间的结束，不包含")
+		format := fs.String("format", service.ExportFormatNDJSON, "ndjson 或者 csv")
+		output := fs.String("output", "", "输出文件，默认输出到标准输出")
+		initViper(fs, args)
+		filter := domain.CommentFilter{
+			BizId:     *bizId,
+			Uid:       *uid,
+			StartTime: parseCommandTime(*start),
+			EndTime:   parseCommandTime(*end),
+		}
+		if *biz != "" {
+			val, err := service.ParseBiz(*biz)
+			if err != nil {
+				panic(err)
+			}
+			filter.Biz = val
+		}
+		out := os.Stdout
+		if *output != "" {
+			f, err := os.Create(*output)
+			if err != nil {
+				panic(err)
+			}
+			defer f.Close()
+			out = f
+		}
+		w := bufio.NewWriter(out)
+		total, err := InitCommentExportService().Export(context.Background(), filter, *format, w)
+		if err != nil {
+			panic(err)
+		}
+		err = w.Flush()
+		if err != nil {
+			panic(err)
+		}
+		// 数据可能输出到标准输出，统计信息打到标准错误
+		fmt.Fprintf(os.Stderr, "导出了 %d 条评论\n", total)
+	default:
+		panic(fmt.Sprintf("未知的子命令: %s", name))
+	}
+}
+
+// parseCommandTime 空字符串返回零值，表示不限制
+func parseCommandTime(val string) time.Time {
+	if val == "" {
+		return time.Time{}
+	}
+	for _, layout := range []string{time.DateTime, time.DateOnly} {
+		t, err := time.ParseInLocation(layout, val, time.Local)
+		if err == nil {
+			return t
+		}
+	}
+	panic(fmt.Sprintf("无法解析时间: %s", val))
+}
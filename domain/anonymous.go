@@ -0,0 +1,55 @@
+package domain
+
+import "fmt"
+
+// Pseudonym 匿名评论展示的化名，同一个人在同一个楼（根评论和它的回复）里面编号固定
+func Pseudonym(seq int32) string {
+	return fmt.Sprintf("匿名同学 #%d", seq)
+}
+
+// Masked 对外返回之前抹掉匿名评论者的真实身份，回复匿名评论的也要抹掉被回复的人。
+// 真实的 uid 只在服务内部使用，比如鉴权、限流和"回复我的"
+func (c Comment) Masked() Comment {
+	if c.AnonymousSeq > 0 {
+		c.Anonymous = true
+		c.Commentator = User{Name: Pseudonym(c.AnonymousSeq)}
+	}
+	if c.ReplyToAnonymousSeq > 0 {
+		c.ReplyToUid = 0
+	}
+	if len(c.Children) > 0 {
+		c.Children = MaskComments(c.Children)
+	}
+	return c
+}
+
+func MaskComments(cs []Comment) []Comment {
+	if cs == nil {
+		return nil
+	}
+	res := make([]Comment, 0, len(cs))
+	for _, c := range cs {
+		res = append(res, c.Masked())
+	}
+	return res
+}
+
+func (t Thread) Masked() Thread {
+	t.Root = t.Root.Masked()
+	t.Fragments = MaskComments(t.Fragments)
+	return t
+}
+
+func (c CommentContext) Masked() CommentContext {
+	c.Comment = c.Comment.Masked()
+	c.Root = c.Root.Masked()
+	c.Ancestors = MaskComments(c.Ancestors)
+	c.Before = MaskComments(c.Before)
+	c.After = MaskComments(c.After)
+	return c
+}
+
+func (p CommentPage) Masked() CommentPage {
+	p.Comments = MaskComments(p.Comments)
+	return p
+}
@@ -15,6 +15,10 @@ type Comment struct {
 	BizId int64         `json:"bizid"`
 	// 评论对象
 	Content string `json:"content"`
+	// Content 的格式，Content 始终是用户输入的原文
+	Format ContentFormat `json:"format"`
+	// 服务端渲染并过滤过的 HTML，客户端直接展示这个
+	ContentHTML string `json:"contentHtml"`
 	// 根评论
 	RootComment *Comment `json:"rootComment"`
 	// 父评论
@@ -23,9 +27,52 @@ type Comment struct {
 	Children      []Comment `json:"children"`
 	CTime         time.Time `json:"ctime"`
 	UTime         time.Time `json:"utime"`
+	// 落库之后在 <biz,bizId> 里面的写入序号，按提交的先后递增，客户端拿它来问之后有没有新评论
+	WriteSeq int64 `json:"writeSeq"`
+	// 图片之类的附件，按上传的顺序
+	Attachments []Attachment `json:"attachments"`
+	// 发表的时候传 Anonymous，落库之后 AnonymousSeq 是化名的编号，0 表示不是匿名评论
+	Anonymous    bool  `json:"anonymous"`
+	AnonymousSeq int32 `json:"anonymousSeq"`
+	// 被回复的是匿名评论的时候，对方的化名编号
+	ReplyToAnonymousSeq int32 `json:"replyToAnonymousSeq"`
+	// 发表的时候 @ 到的人，只用来检查有没有被这些人拉黑，不落库
+	MentionUids []int64 `json:"mentionUids"`
+}
+
+// ContentFormat 评论内容的格式
+type ContentFormat int32
+
+const (
+	ContentFormatPlain ContentFormat = iota
+	// ContentFormatMarkdown 受限的 Markdown 子集
+	ContentFormatMarkdown
+)
+
+// Attachment 附件的元数据，文件本身在对象存储里面，客户端先上传再带着 ObjectKey 发评论
+type Attachment struct {
+	ObjectKey string `json:"objectKey"`
+	MimeType  string `json:"mimeType"`
+	// 字节数
+	Size int64 `json:"size"`
+	// 图片的宽高，其他类型的附件为 0
+	Width  int32 `json:"width"`
+	Height int32 `json:"height"`
+	// 文件内容的 sha256，十六进制
+	Checksum string `json:"checksum"`
 }
 
 type User struct {
 	ID   int64  `json:"id"`
 	Name string `json:"name"`
 }
+
+// CommentFilter 按条件筛选评论，零值表示不限制这个条件
+type CommentFilter struct {
+	Biz   commentv1.Biz
+	BizId int64
+	Uid   int64
+	// 创建时间在 [StartTime, EndTime) 之间
+	StartTime time.Time
+	EndTime   time.Time
+}
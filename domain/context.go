@@ -0,0 +1,16 @@
+package domain
+
+// CommentContext 从通知之类的地方跳转到一条评论的时候，展示它所在的上下文
+type CommentContext struct {
+	Comment Comment
+	// Root 整棵树的根评论，评论自己就是根评论的时候和 Comment 一样
+	Root Comment
+	// Ancestors 根评论和评论之间的祖先，从上往下排
+	Ancestors []Comment
+	// Before 和 After 是同一个父评论下面（根评论的话是同一个 biz 下面）紧挨着的评论，都按 id 升序排列。
+	// 继续往前翻用 Before 的第一个的 id，往后翻用 After 的最后一个的 id
+	Before    []Comment
+	After     []Comment
+	HasBefore bool
+	HasAfter  bool
+}
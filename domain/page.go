@@ -0,0 +1,60 @@
+package domain
+
+// SortMode 评论列表的排序方式
+type SortMode int32
+
+const (
+	// SortNewest 先新后旧，评论列表默认的排序
+	SortNewest SortMode = iota
+	// SortOldest 先旧后新，回复列表默认的排序
+	SortOldest
+	// SortRecentlyUpdated 最近修改的在前
+	SortRecentlyUpdated
+)
+
+func (s SortMode) Valid() bool {
+	return s >= SortNewest && s <= SortRecentlyUpdated
+}
+
+// Key 评论在这种排序方式下的排序字段的值
+func (s SortMode) Key(c Comment) int64 {
+	if s == SortRecentlyUpdated {
+		return c.UTime.UnixMilli()
+	}
+	return c.CTime.UnixMilli()
+}
+
+// PageDirection 从游标往哪个方向翻
+type PageDirection int32
+
+const (
+	// PageNext 往后翻，也就是排序靠后的方向
+	PageNext PageDirection = iota
+	// PagePrev 往前翻，拿到的是游标前面紧挨着的那些评论
+	PagePrev
+)
+
+// Cursor 翻页的位置，排序字段相同的时候用 id 区分先后
+type Cursor struct {
+	Sort SortMode
+	Key  int64
+	Id   int64
+}
+
+// CommentPage 一页评论，不管往哪个方向翻，Comments 都是按照排序方式排好的。
+// NextCursor 和 PrevCursor 是编码之后的游标，客户端原样带回来就行
+type CommentPage struct {
+	Comments   []Comment
+	NextCursor string
+	HasMore    bool
+	PrevCursor string
+	HasPrev    bool
+}
+
+// NewComments 客户端看到的最新一条评论之后新发表的评论
+type NewComments struct {
+	// 最新的若干条，先新后旧
+	Comments []Comment
+	// 一共有多少条新评论，可能比 Comments 多
+	Count int64
+}
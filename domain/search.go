@@ -0,0 +1,14 @@
+package domain
+
+// CommentSearchHit 一条搜索结果，Highlight 是转义过的评论内容，命中的关键词用 <em> 包起来
+type CommentSearchHit struct {
+	Comment   Comment
+	Highlight string
+}
+
+type CommentSearchResult struct {
+	Hits []CommentSearchHit
+	// 符合条件的总数
+	Total   int64
+	HasMore bool
+}
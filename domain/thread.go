@@ -0,0 +1,14 @@
+package domain
+
+// Thread 以某一条评论为根的回复树，一次只返回一部分节点
+type Thread struct {
+	// Root 请求的那条评论，Children 里面是这一页的回复
+	Root Comment
+	// Fragments 父评论在之前的页里面的子树，按照 ParentComment.Id 合并到之前的树上
+	Fragments []Comment
+	// Truncated 因为深度限制没有展开的节点，以它们为根再取一次就能继续展开
+	Truncated []int64
+	// NextCursor 继续取下一页的时候带上
+	NextCursor int64
+	HasMore    bool
+}
@@ -0,0 +1,216 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"github.com/IBM/sarama"
+	commentv1 "github.com/MuxiKeStack/be-api/gen/proto/comment/v1"
+	feedv1 "github.com/MuxiKeStack/be-api/gen/proto/feed/v1"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/MuxiKeStack/be-comment/pkg/saramax"
+	"github.com/MuxiKeStack/be-comment/repository"
+	"strconv"
+	"time"
+)
+
+// CommentWriteConsumer 批量落库 CreateComment 发出来的评论，落库成功之后再发送 feed 事件
+type CommentWriteConsumer struct {
+	client    sarama.Client
+	repo      repository.CommentRepository
+	inboxRepo repository.ReplyInboxRepository
+	producer  Producer
+	dlq       *saramax.DeadLetterQueue
+	l         logger.Logger
+	cg        sarama.ConsumerGroup
+	cancel    func()
+	done      chan struct{}
+}
+
+func NewCommentWriteConsumer(client sarama.Client, repo repository.CommentRepository,
+	inboxRepo repository.ReplyInboxRepository, producer Producer, dlqProducer sarama.SyncProducer,
+	l logger.Logger) *CommentWriteConsumer {
+	return &CommentWriteConsumer{
+		client:    client,
+		repo:      repo,
+		inboxRepo: inboxRepo,
+		producer:  producer,
+		dlq:       saramax.NewDeadLetterQueue(dlqProducer, topicCommentWrite+"_dlq"),
+		l:         l,
+	}
+}
+
+func (c *CommentWriteConsumer) Start() error {
+	cg, err := sarama.NewConsumerGroupFromClient("comment_write", c.client)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cg, c.cancel, c.done = cg, cancel, make(chan struct{})
+	go func() {
+		defer close(c.done)
+		// 每次 rebalance 之后 Consume 都会返回，要重新进去
+		for {
+			er := cg.Consume(ctx, []string{topicCommentWrite},
+				saramax.NewBatchHandlerWithResults[CommentWriteEvent](c.l, c.Consume,
+					// 落库是一条 INSERT 加上按 <biz,bizId> 汇总的计数更新，批次越大越划算
+					saramax.WithBatchSize(200),
+					saramax.WithBatchMaxWait(time.Millisecond*500),
+					saramax.WithBatchMaxBytes(1<<20),
+					saramax.WithRetry(saramax.RetryPolicy{
+						MaxRetries:     5,
+						InitialBackoff: time.Millisecond * 200,
+						MaxBackoff:     time.Second * 10,
+					}),
+					saramax.WithDeadLetterQueue(c.dlq)))
+			if er == sarama.ErrClosedConsumerGroup || ctx.Err() != nil {
+				return
+			}
+			if er != nil {
+				c.l.Error("退出了消费循环异常", logger.Error(er))
+				time.Sleep(time.Second)
+			}
+		}
+	}()
+	return nil
+}
+
+// Close 取消 ctx 之后 claim 的 channel 会被关闭，凑到一半的批次会处理完再提交
+func (c *CommentWriteConsumer) Close() error {
+	if c.cg == nil {
+		return nil
+	}
+	c.cancel()
+	<-c.done
+	return c.cg.Close()
+}
+
+func (c *CommentWriteConsumer) Consume(msgs []*sarama.ConsumerMessage, evts []CommentWriteEvent) []error {
+	comments := make([]domain.Comment, 0, len(evts))
+	feedEvents := make(map[int64]FeedEvent, len(evts))
+	for i, evt := range evts {
+		comments = append(comments, c.toDomain(evt))
+		feedEvents[evt.Id] = FeedEvent{
+			// 同一条评论的事件 id 是固定的，下游可以据此去重
+			ID:       "comment_created:" + strconv.FormatInt(evt.Id, 10),
+			Type:     feedv1.EventType_Comment,
+			Metadata: feedMetadata(evt),
+			// 沿用发表评论那个请求的 trace id
+			TraceID: saramax.HeaderValue(msgs[i], HeaderTraceID),
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	inserted, err := c.repo.BatchCreateComments(ctx, comments)
+	var errs []error
+	if errors.Is(err, repository.ErrParentCommentNotFound) || errors.Is(err, repository.ErrCommentIdConflict) {
+		// 有评论的父评论已经不存在了，或者 id 撞了，整批插入会失败，逐条插入找出是哪几条
+		inserted, errs = c.createOneByOne(ctx, comments)
+	} else if err != nil {
+		errs = make([]error, len(evts))
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	c.addUnreadReplies(ctx, inserted)
+	c.produceFeedEvents(ctx, inserted, feedEvents)
+	return errs
+}
+
+func feedMetadata(evt CommentWriteEvent) map[string]string {
+	md := map[string]string{
+		// 评论者
+		"commentator": strconv.FormatInt(evt.Uid, 10),
+		// 被评论者
+		"recipient": strconv.FormatInt(evt.ReplyToUid, 10),
+		// 资源发布者，可能与被评论者相同
+		"bizPublisher": strconv.FormatInt(evt.BizPublisher, 10),
+		"biz":          commentv1.Biz(evt.Biz).String(),
+		"bizId":        strconv.FormatInt(evt.BizId, 10),
+		"commentId":    strconv.FormatInt(evt.Id, 10),
+	}
+	// 匿名评论不带评论者的 uid，被评论者仍然要收到通知，所以 recipient 保留
+	if evt.AnonymousSeq > 0 {
+		md["commentator"] = "0"
+		md["anonymous"] = "true"
+		md["commentatorName"] = domain.Pseudonym(evt.AnonymousSeq)
+	}
+	return md
+}
+
+// addUnreadReplies 给被回复的人增加未读数，自己回复自己的不算
+func (c *CommentWriteConsumer) addUnreadReplies(ctx context.Context, inserted []domain.Comment) {
+	deltas := make(map[int64]int64)
+	for _, cm := range inserted {
+		if cm.ReplyToUid != 0 && cm.ReplyToUid != cm.Commentator.ID {
+			deltas[cm.ReplyToUid]++
+		}
+	}
+	c.inboxRepo.AddUnread(ctx, deltas)
+}
+
+func (c *CommentWriteConsumer) createOneByOne(ctx context.Context, comments []domain.Comment) ([]domain.Comment, []error) {
+	var inserted []domain.Comment
+	errs := make([]error, len(comments))
+	for i, cm := range comments {
+		res, err := c.repo.BatchCreateComments(ctx, []domain.Comment{cm})
+		if errors.Is(err, repository.ErrCommentIdConflict) {
+			// 不应该出现，说明有两个实例用了同一个节点号
+			c.l.Error("评论 id 冲突",
+				logger.Error(err),
+				logger.Int64("commentId", cm.Id),
+				logger.Int64("uid", cm.Commentator.ID))
+		}
+		if errors.Is(err, repository.ErrParentCommentNotFound) || errors.Is(err, repository.ErrCommentIdConflict) {
+			// 重试也不会成功，直接进死信队列
+			err = saramax.NonRetryable(err)
+		}
+		errs[i] = err
+		inserted = append(inserted, res...)
+	}
+	return inserted, errs
+}
+
+// produceFeedEvents 只给这一次真正插入的评论发送 feed 事件
+func (c *CommentWriteConsumer) produceFeedEvents(ctx context.Context, inserted []domain.Comment, feedEvents map[int64]FeedEvent) {
+	if len(inserted) == 0 {
+		return
+	}
+	evts := make([]FeedEvent, 0, len(inserted))
+	for _, cm := range inserted {
+		evts = append(evts, feedEvents[cm.Id])
+	}
+	// 评论已经落库了，feed 事件发送失败不影响这一批消息的提交
+	err := c.producer.BatchProduceFeedEvent(ctx, evts)
+	if err != nil {
+		c.l.Error("发送评论事件失败",
+			logger.Error(err),
+			logger.Int("count", len(evts)))
+	}
+}
+
+func (c *CommentWriteConsumer) toDomain(evt CommentWriteEvent) domain.Comment {
+	ctime := time.UnixMilli(evt.Ctime)
+	res := domain.Comment{
+		Id: evt.Id,
+		Commentator: domain.User{
+			ID: evt.Uid,
+		},
+		Biz:           commentv1.Biz(evt.Biz),
+		BizId:         evt.BizId,
+		Content:       evt.Content,
+		Format:        domain.ContentFormat(evt.Format),
+		ContentHTML:   evt.ContentHTML,
+		RootComment:   &domain.Comment{Id: evt.RootId},
+		ParentComment: &domain.Comment{Id: evt.Pid},
+		ReplyToUid:    evt.ReplyToUid,
+		Attachments:   evt.Attachments,
+		CTime:         ctime,
+		UTime:         ctime,
+	}
+	res.Anonymous = evt.AnonymousSeq > 0
+	res.AnonymousSeq = evt.AnonymousSeq
+	res.ReplyToAnonymousSeq = evt.ReplyToAnonymousSeq
+	return res
+}
@@ -2,46 +2,208 @@ package events
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"github.com/IBM/sarama"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/MuxiKeStack/be-comment/pkg/saramax"
+	"github.com/MuxiKeStack/be-comment/pkg/tracex"
+	"github.com/google/uuid"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// 发送的消息都会带上这些 header
+const (
+	HeaderEventID       = "x-event-id"
+	HeaderSchemaVersion = "x-schema-version"
+	HeaderProducer      = "x-producer"
+	HeaderTraceID       = tracex.HeaderTraceID
+)
+
+// 消息体结构发生不兼容的变化的时候递增
+const schemaVersion = "1"
+
+var ErrProducerClosed = errors.New("生产者已经关闭")
+
 type Producer interface {
+	// BatchProduceFeedEvent 和 ProduceFeedEvent 都是异步的，放进发送缓冲区就返回，
+	// 发送失败的只记日志，关闭的时候会打印一次汇总
 	BatchProduceFeedEvent(ctx context.Context, event []FeedEvent) error
 	ProduceFeedEvent(ctx context.Context, event FeedEvent) error
+	// ProduceCommentWriteEvent 是同步的，返回的时候消息已经写入 kafka
+	ProduceCommentWriteEvent(ctx context.Context, event CommentWriteEvent) error
+	// Close 把缓冲区里面还没发出去的消息发完再返回
+	Close() error
 }
 
 type SaramaProducer struct {
 	producer sarama.SyncProducer
+	// feed 事件走异步发送，批量、压缩都在 sarama 的配置里面
+	async sarama.AsyncProducer
+	// 编码方式会通过 content-type header 告诉消费者
+	codec saramax.Codec
+	// 生产者实例，方便排查问题
+	instance string
+	l        logger.Logger
+
+	// 只用来在关闭的时候打印汇总
+	enqueued  atomic.Int64
+	succeeded atomic.Int64
+	failed    atomic.Int64
+
+	// 保护 closed，关闭之后不能再往 Input 里面写，不然会 panic
+	mutex  sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
 }
 
-func NewSaramaProducer(producer sarama.SyncProducer) Producer {
-	return &SaramaProducer{producer: producer}
+func NewSaramaProducer(producer sarama.SyncProducer, async sarama.AsyncProducer,
+	codec saramax.Codec, l logger.Logger) Producer {
+	instance, _ := os.Hostname()
+	p := &SaramaProducer{
+		producer: producer,
+		async:    async,
+		codec:    codec,
+		instance: instance,
+		l:        l,
+	}
+	p.wg.Add(2)
+	go p.handleSuccesses()
+	go p.handleErrors()
+	return p
 }
 
 func (p *SaramaProducer) BatchProduceFeedEvent(ctx context.Context, event []FeedEvent) error {
-	msgs := make([]*sarama.ProducerMessage, 0, len(event))
 	for _, e := range event {
-		data, err := json.Marshal(e)
+		err := p.ProduceFeedEvent(ctx, e)
 		if err != nil {
 			return err
 		}
-		msgs = append(msgs, &sarama.ProducerMessage{
-			Topic: topicFeedEvent,
-			Value: sarama.ByteEncoder(data),
-		})
 	}
-	return p.producer.SendMessages(msgs)
+	return nil
 }
 
 func (p *SaramaProducer) ProduceFeedEvent(ctx context.Context, event FeedEvent) error {
-	data, err := json.Marshal(event)
+	msg, err := p.newFeedMessage(ctx, event)
 	if err != nil {
 		return err
 	}
-	_, _, err = p.producer.SendMessage(&sarama.ProducerMessage{
-		Topic: topicFeedEvent,
-		Value: sarama.ByteEncoder(data),
-	})
+	msg.Metadata = time.Now()
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.closed {
+		return ErrProducerClosed
+	}
+	// 缓冲区是有界的，满了就等，直到 ctx 超时
+	select {
+	case p.async.Input() <- msg:
+		p.enqueued.Add(1)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *SaramaProducer) Close() error {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.mutex.Unlock()
+	// AsyncClose 之后 sarama 会把缓冲区里面的消息发完，然后关闭 Successes 和 Errors
+	p.async.AsyncClose()
+	p.wg.Wait()
+	p.l.Info("异步生产者已关闭",
+		logger.Int64("enqueued", p.enqueued.Load()),
+		logger.Int64("succeeded", p.succeeded.Load()),
+		logger.Int64("failed", p.failed.Load()))
+	return p.producer.Close()
+}
+
+func (p *SaramaProducer) handleSuccesses() {
+	defer p.wg.Done()
+	for msg := range p.async.Successes() {
+		p.succeeded.Add(1)
+		if enqueuedAt, ok := msg.Metadata.(time.Time); ok {
+			p.l.Debug("发送事件成功",
+				logger.String("topic", msg.Topic),
+				logger.Int32("partition", msg.Partition),
+				logger.Int64("offset", msg.Offset),
+				logger.Int64("latencyMs", time.Since(enqueuedAt).Milliseconds()))
+		}
+	}
+}
+
+func (p *SaramaProducer) handleErrors() {
+	defer p.wg.Done()
+	for pe := range p.async.Errors() {
+		p.failed.Add(1)
+		var eventID string
+		for _, h := range pe.Msg.Headers {
+			if string(h.Key) == HeaderEventID {
+				eventID = string(h.Value)
+			}
+		}
+		p.l.Error("发送事件失败",
+			logger.Error(pe.Err),
+			logger.String("topic", pe.Msg.Topic),
+			logger.String("eventId", eventID))
+	}
+}
+
+func (p *SaramaProducer) ProduceCommentWriteEvent(ctx context.Context, event CommentWriteEvent) error {
+	msg, err := p.newMessage(topicCommentWrite, event,
+		"comment_write:"+strconv.FormatInt(event.Id, 10), tracex.TraceID(ctx))
+	if err != nil {
+		return err
+	}
+	// 同一个 <biz,bizId> 下的评论落到同一个分区，保证按发送的顺序落库
+	msg.Key = sarama.StringEncoder(strconv.Itoa(int(event.Biz)) + ":" + strconv.FormatInt(event.BizId, 10))
+	_, _, err = p.producer.SendMessage(msg)
 	return err
 }
+
+func (p *SaramaProducer) newFeedMessage(ctx context.Context, event FeedEvent) (*sarama.ProducerMessage, error) {
+	eventID := event.ID
+	if eventID == "" {
+		eventID = uuid.NewString()
+	}
+	traceID := event.TraceID
+	if traceID == "" {
+		traceID = tracex.TraceID(ctx)
+	}
+	msg, err := p.newMessage(topicFeedEvent, event, eventID, traceID)
+	if err != nil {
+		return nil, err
+	}
+	// 同一个接收者的事件落到同一个分区，保证 feed 那边按顺序处理，没有接收者的按 <biz,bizId>
+	if recipient := event.Metadata["recipient"]; recipient != "" {
+		msg.Key = sarama.StringEncoder("recipient:" + recipient)
+	} else {
+		msg.Key = sarama.StringEncoder(event.Metadata["biz"] + ":" + event.Metadata["bizId"])
+	}
+	return msg, nil
+}
+
+func (p *SaramaProducer) newMessage(topic string, v any, eventID string, traceID string) (*sarama.ProducerMessage, error) {
+	data, err := p.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &sarama.ProducerMessage{
+		Topic: topic,
+		Value: sarama.ByteEncoder(data),
+		Headers: []sarama.RecordHeader{
+			saramax.ContentTypeHeader(p.codec),
+			{Key: []byte(HeaderEventID), Value: []byte(eventID)},
+			{Key: []byte(HeaderSchemaVersion), Value: []byte(schemaVersion)},
+			{Key: []byte(HeaderProducer), Value: []byte(p.instance)},
+			{Key: []byte(HeaderTraceID), Value: []byte(traceID)},
+		},
+	}, nil
+}
@@ -1,10 +1,48 @@
 package events
 
-import feedv1 "github.com/MuxiKeStack/be-api/gen/proto/feed/v1"
+import (
+	feedv1 "github.com/MuxiKeStack/be-api/gen/proto/feed/v1"
+	"github.com/MuxiKeStack/be-comment/domain"
+)
 
-const topicFeedEvent = "feed_event"
+const (
+	topicFeedEvent    = "feed_event"
+	topicCommentWrite = "comment_write"
+)
+
+// Topics 这个服务会用到的 topic，启动的时候要保证它们已经存在
+func Topics() []string {
+	return []string{topicFeedEvent, topicCommentWrite, topicCommentWrite + "_dlq"}
+}
 
 type FeedEvent struct {
+	// ID 放在 header 里面，供下游去重，为空的时候发送时随机生成
+	ID       string `json:"-"`
 	Type     feedv1.EventType
 	Metadata map[string]string
+	// TraceID 放在 header 里面，为空的时候从 ctx 里面取
+	TraceID string `json:"-"`
+}
+
+// CommentWriteEvent 待落库的评论，id 和创建时间在发送之前就确定了
+type CommentWriteEvent struct {
+	Id         int64
+	Uid        int64
+	Biz        int32
+	BizId      int64
+	RootId     int64
+	Pid        int64
+	ReplyToUid int64
+	Content    string
+	// 内容格式和渲染好的 HTML，渲染在发送之前做完
+	Format      int32
+	ContentHTML string
+	// 匿名评论的化名编号，0 表示不是匿名评论
+	AnonymousSeq        int32
+	ReplyToAnonymousSeq int32
+	// 已经校验过的附件
+	Attachments []domain.Attachment
+	// 资源发布者，落库之后发送 feed 事件要用
+	BizPublisher int64
+	Ctime        int64
 }
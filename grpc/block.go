@@ -0,0 +1,47 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	commentv1 "github.com/MuxiKeStack/be-api/gen/proto/comment/v1"
+	"github.com/MuxiKeStack/be-comment/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BlockServiceServer 拉黑，uid 由网关鉴权之后填进来
+type BlockServiceServer struct {
+	svc service.BlockService
+	commentv1.UnimplementedBlockServiceServer
+}
+
+func NewBlockServiceServer(svc service.BlockService) *BlockServiceServer {
+	return &BlockServiceServer{svc: svc}
+}
+
+func (s *BlockServiceServer) Register(server grpc.ServiceRegistrar) {
+	commentv1.RegisterBlockServiceServer(server, s)
+}
+
+// Block 重复拉黑同一个人不算错误
+func (s *BlockServiceServer) Block(ctx context.Context, request *commentv1.BlockRequest) (*commentv1.BlockResponse, error) {
+	err := s.svc.Block(ctx, request.GetUid(), request.GetBlockedUid())
+	switch {
+	case errors.Is(err, service.ErrBlockSelf):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, service.ErrTooManyBlocked):
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return &commentv1.BlockResponse{}, err
+}
+
+func (s *BlockServiceServer) Unblock(ctx context.Context, request *commentv1.UnblockRequest) (*commentv1.UnblockResponse, error) {
+	err := s.svc.Unblock(ctx, request.GetUid(), request.GetBlockedUid())
+	return &commentv1.UnblockResponse{}, err
+}
+
+func (s *BlockServiceServer) ListBlocked(ctx context.Context, request *commentv1.ListBlockedRequest) (*commentv1.ListBlockedResponse, error) {
+	uids, err := s.svc.ListBlocked(ctx, request.GetUid())
+	return &commentv1.ListBlockedResponse{BlockedUids: uids}, err
+}
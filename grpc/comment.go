@@ -2,11 +2,13 @@ package grpc
 
 import (
 	"context"
+	"errors"
 	commentv1 "github.com/MuxiKeStack/be-api/gen/proto/comment/v1"
 	"github.com/MuxiKeStack/be-comment/domain"
 	"github.com/MuxiKeStack/be-comment/service"
 	"google.golang.org/grpc"
-	"math"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type CommentServiceServer struct {
@@ -22,23 +24,40 @@ func (s *CommentServiceServer) Register(server grpc.ServiceRegistrar) {
 	commentv1.RegisterCommentServiceServer(server, s)
 }
 
+// GetCommentList 按照 cursor 翻页，不带 cursor 的时候是第一页。往前翻带上 prev_cursor 和 PAGE_DIRECTION_PREV。
+// 老的客户端还在用 cur_comment_id 翻页，没有 cursor 只有 cur_comment_id 的请求走原来的逻辑。
+// viewer_uid 是网关鉴权之后填进来的查看者，查看者拉黑的人的评论不返回，没有登录的时候为 0
 func (s *CommentServiceServer) GetCommentList(ctx context.Context, request *commentv1.CommentListRequest) (*commentv1.CommentListResponse, error) {
-	curCommentId := request.GetCurCommentId()
-	// 第一次查询
-	if curCommentId <= 0 {
-		curCommentId = math.MaxInt64
-	}
-	domainComments, err := s.svc.
-		GetCommentList(ctx,
-			request.GetBiz(),
-			request.GetBizId(),
-			curCommentId,
-			request.GetLimit())
+	if request.GetCursor() == "" && request.GetCurCommentId() > 0 {
+		domainComments, err := s.svc.
+			GetCommentList(ctx,
+				request.GetBiz(),
+				request.GetBizId(),
+				request.GetCurCommentId(),
+				request.GetLimit(),
+				request.GetViewerUid())
+		if err != nil {
+			return nil, err
+		}
+		return &commentv1.CommentListResponse{
+			Comments: s.toDTO(domainComments),
+		}, nil
+	}
+	sort, err := toSortMode(request.GetSort(), domain.SortNewest)
 	if err != nil {
-		return nil, err
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	page, err := s.svc.ListComments(ctx, request.GetBiz(), request.GetBizId(),
+		sort, request.GetCursor(), toPageDirection(request.GetDirection()), request.GetLimit(), request.GetViewerUid())
+	if err != nil {
+		return nil, toPageError(err)
 	}
 	return &commentv1.CommentListResponse{
-		Comments: s.toDTO(domainComments),
+		Comments:   s.toDTO(page.Comments),
+		NextCursor: page.NextCursor,
+		HasMore:    page.HasMore,
+		PrevCursor: page.PrevCursor,
+		HasPrev:    page.HasPrev,
 	}, nil
 }
 
@@ -48,18 +67,68 @@ func (s *CommentServiceServer) DeleteComment(ctx context.Context, request *comme
 }
 
 // TODO 缺少外键约束，无法避免的会有错误的bizId，目前没有解决，其他地方也有这样的问题，question create
+// CreateComment 评论是异步落库的，返回的 comment_id 在落库之前可能还查不到，见 service.CommentService
 func (s *CommentServiceServer) CreateComment(ctx context.Context, request *commentv1.CreateCommentRequest) (*commentv1.CreateCommentResponse, error) {
-	err := s.svc.CreateComment(ctx, convertToDomain(request.GetComment()))
-	return &commentv1.CreateCommentResponse{}, err
+	id, err := s.svc.CreateComment(ctx, convertToDomain(request.GetComment()))
+	switch {
+	case errors.Is(err, service.ErrBlockedByAuthor), errors.Is(err, service.ErrBlockedByMentioned):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, service.ErrTooManyMentions):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &commentv1.CreateCommentResponse{CommentId: id}, err
 }
 
+// GetMoreReplies 和 GetCommentList 一样，老的客户端用 cur_comment_id 翻页，默认先旧后新
 func (s *CommentServiceServer) GetMoreReplies(ctx context.Context, request *commentv1.GetMoreRepliesRequest) (*commentv1.GetMoreRepliesResponse, error) {
-	cs, err := s.svc.GetMoreReplies(ctx, request.GetRid(), request.GetCurCommentId(), request.GetLimit())
+	if request.GetCursor() == "" && request.GetCurCommentId() > 0 {
+		cs, err := s.svc.GetMoreReplies(ctx, request.GetRid(), request.GetCurCommentId(), request.GetLimit(), request.GetViewerUid())
+		if err != nil {
+			return nil, err
+		}
+		return &commentv1.GetMoreRepliesResponse{
+			Replies: s.toDTO(cs),
+		}, nil
+	}
+	sort, err := toSortMode(request.GetSort(), domain.SortOldest)
 	if err != nil {
-		return nil, err
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	page, err := s.svc.ListReplies(ctx, request.GetRid(),
+		sort, request.GetCursor(), toPageDirection(request.GetDirection()), request.GetLimit(), request.GetViewerUid())
+	if err != nil {
+		return nil, toPageError(err)
 	}
 	return &commentv1.GetMoreRepliesResponse{
-		Replies: s.toDTO(cs),
+		Replies:    s.toDTO(page.Comments),
+		NextCursor: page.NextCursor,
+		HasMore:    page.HasMore,
+		PrevCursor: page.PrevCursor,
+		HasPrev:    page.HasPrev,
+	}, nil
+}
+
+// ListCommentsSince since_seq 是客户端看到的根评论里面最大的 write_seq，返回之后落库的评论和一共有多少条
+func (s *CommentServiceServer) ListCommentsSince(ctx context.Context, request *commentv1.ListCommentsSinceRequest) (*commentv1.ListCommentsSinceResponse, error) {
+	res, err := s.svc.ListCommentsSince(ctx, request.GetBiz(), request.GetBizId(), request.GetSinceSeq(),
+		request.GetLimit(), request.GetViewerUid())
+	if err != nil {
+		return nil, err
+	}
+	return &commentv1.ListCommentsSinceResponse{
+		Comments: s.toDTO(res.Comments),
+		Count:    res.Count,
+	}, nil
+}
+
+// ListUserComments "我的评论"，先新后旧，biz 为 0 表示全部
+func (s *CommentServiceServer) ListUserComments(ctx context.Context, request *commentv1.ListUserCommentsRequest) (*commentv1.ListUserCommentsResponse, error) {
+	cs, err := s.svc.ListUserComments(ctx, request.GetUid(), request.GetBiz(), request.GetCurCommentId(), request.GetLimit())
+	if err != nil {
+		return nil, err
+	}
+	return &commentv1.ListUserCommentsResponse{
+		Comments: s.toDTO(cs),
 	}, nil
 }
 
@@ -80,6 +149,95 @@ func (s *CommentServiceServer) GetComment(ctx context.Context, request *commentv
 	}, err
 }
 
+// GetThread 以 comment_id 为根的回复树，一次返回一部分节点，继续取的时候带上 next_cursor
+func (s *CommentServiceServer) GetThread(ctx context.Context, request *commentv1.GetThreadRequest) (*commentv1.GetThreadResponse, error) {
+	thread, err := s.svc.GetThread(ctx, request.GetCommentId(),
+		int(request.GetMaxDepth()), int(request.GetMaxNodes()), request.GetCursor())
+	if err == service.ErrCommentNotFound {
+		return &commentv1.GetThreadResponse{}, commentv1.ErrorCommentNotFound("评论不存在: %d", request.GetCommentId())
+	}
+	if err != nil {
+		return nil, err
+	}
+	fragments := make([]*commentv1.Comment, 0, len(thread.Fragments))
+	for _, f := range thread.Fragments {
+		fragments = append(fragments, convertToV(f))
+	}
+	return &commentv1.GetThreadResponse{
+		Root:       convertToV(thread.Root),
+		Fragments:  fragments,
+		Truncated:  thread.Truncated,
+		NextCursor: thread.NextCursor,
+		HasMore:    thread.HasMore,
+	}, nil
+}
+
+// GetCommentContext 定位到某一条评论：从根评论到它的祖先链，加上它前后各 k 条兄弟评论
+func (s *CommentServiceServer) GetCommentContext(ctx context.Context, request *commentv1.GetCommentContextRequest) (*commentv1.GetCommentContextResponse, error) {
+	cc, err := s.svc.GetCommentContext(ctx, request.GetCommentId(), request.GetK())
+	if err == service.ErrCommentNotFound {
+		return &commentv1.GetCommentContextResponse{}, commentv1.ErrorCommentNotFound("评论不存在: %d", request.GetCommentId())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &commentv1.GetCommentContextResponse{
+		Comment:   convertToV(cc.Comment),
+		Root:      convertToV(cc.Root),
+		Ancestors: s.toDTO(cc.Ancestors),
+		Before:    s.toDTO(cc.Before),
+		After:     s.toDTO(cc.After),
+		HasBefore: cc.HasBefore,
+		HasAfter:  cc.HasAfter,
+	}, nil
+}
+
+// ListSiblings 从 anchor_id 往前或者往后继续展开兄弟评论
+func (s *CommentServiceServer) ListSiblings(ctx context.Context, request *commentv1.ListSiblingsRequest) (*commentv1.ListSiblingsResponse, error) {
+	cs, hasMore, err := s.svc.ListSiblings(ctx, request.GetAnchorId(), request.GetBefore(), request.GetLimit())
+	if err == service.ErrCommentNotFound {
+		return &commentv1.ListSiblingsResponse{}, commentv1.ErrorCommentNotFound("评论不存在: %d", request.GetAnchorId())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &commentv1.ListSiblingsResponse{
+		Comments: s.toDTO(cs),
+		HasMore:  hasMore,
+	}, nil
+}
+
+// toSortMode 没有指定排序方式的时候用 def
+func toSortMode(sort commentv1.CommentSort, def domain.SortMode) (domain.SortMode, error) {
+	switch sort {
+	case commentv1.CommentSort_COMMENT_SORT_UNSPECIFIED:
+		return def, nil
+	case commentv1.CommentSort_COMMENT_SORT_NEWEST:
+		return domain.SortNewest, nil
+	case commentv1.CommentSort_COMMENT_SORT_OLDEST:
+		return domain.SortOldest, nil
+	case commentv1.CommentSort_COMMENT_SORT_RECENTLY_UPDATED:
+		return domain.SortRecentlyUpdated, nil
+	default:
+		return 0, service.ErrInvalidSort
+	}
+}
+
+func toPageDirection(dir commentv1.PageDirection) domain.PageDirection {
+	if dir == commentv1.PageDirection_PAGE_DIRECTION_PREV {
+		return domain.PagePrev
+	}
+	return domain.PageNext
+}
+
+// toPageError 游标是客户端带回来的，解析不了是参数错误
+func toPageError(err error) error {
+	if errors.Is(err, service.ErrInvalidCursor) || errors.Is(err, service.ErrInvalidSort) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return err
+}
+
 func convertToV(comment domain.Comment) *commentv1.Comment {
 	commentVo := &commentv1.Comment{
 		Id:            comment.Id,
@@ -87,9 +245,17 @@ func convertToV(comment domain.Comment) *commentv1.Comment {
 		Biz:           comment.Biz,
 		BizId:         comment.BizId,
 		Content:       comment.Content,
+		Format:        commentv1.ContentFormat(comment.Format),
+		ContentHtml:   comment.ContentHTML,
 		ReplyToUid:    comment.ReplyToUid,
 		Ctime:         comment.CTime.UnixMilli(),
 		Utime:         comment.UTime.UnixMilli(),
+		WriteSeq:      comment.WriteSeq,
+		Attachments:   attachmentsToV(comment.Attachments),
+		// 匿名评论的 commentator_id 已经抹掉了，客户端按编号展示化名
+		Anonymous:           comment.Anonymous,
+		AnonymousSeq:        comment.AnonymousSeq,
+		ReplyToAnonymousSeq: comment.ReplyToAnonymousSeq,
 	}
 	if comment.RootComment != nil {
 		commentVo.RootComment = &commentv1.Comment{Id: comment.RootComment.Id}
@@ -97,19 +263,27 @@ func convertToV(comment domain.Comment) *commentv1.Comment {
 	if comment.ParentComment != nil {
 		commentVo.ParentComment = &commentv1.Comment{Id: comment.ParentComment.Id}
 	}
+	for _, child := range comment.Children {
+		commentVo.Children = append(commentVo.Children, convertToV(child))
+	}
 	return commentVo
 }
 
+// convertToDomain content_html 是服务端渲染的，化名编号是服务端分配的，客户端传过来的都不用
 func convertToDomain(comment *commentv1.Comment) domain.Comment {
 	domainComment := domain.Comment{
 		Id: comment.GetId(),
 		Commentator: domain.User{
 			ID: comment.GetCommentatorId(),
 		},
-		Biz:        comment.GetBiz(),
-		BizId:      comment.GetBizId(),
-		Content:    comment.Content,
-		ReplyToUid: comment.GetReplyToUid(),
+		Biz:         comment.GetBiz(),
+		BizId:       comment.GetBizId(),
+		Content:     comment.GetContent(),
+		Format:      domain.ContentFormat(comment.GetFormat()),
+		ReplyToUid:  comment.GetReplyToUid(),
+		Attachments: attachmentsToDomain(comment.GetAttachments()),
+		Anonymous:   comment.GetAnonymous(),
+		MentionUids: comment.GetMentionUids(),
 	}
 	if comment.GetParentComment() != nil {
 		domainComment.ParentComment = &domain.Comment{
@@ -124,30 +298,40 @@ func convertToDomain(comment *commentv1.Comment) domain.Comment {
 	return domainComment
 }
 
+func attachmentsToV(attachments []domain.Attachment) []*commentv1.Attachment {
+	var res []*commentv1.Attachment
+	for _, a := range attachments {
+		res = append(res, &commentv1.Attachment{
+			ObjectKey: a.ObjectKey,
+			MimeType:  a.MimeType,
+			Size:      a.Size,
+			Width:     a.Width,
+			Height:    a.Height,
+			Checksum:  a.Checksum,
+		})
+	}
+	return res
+}
+
+func attachmentsToDomain(attachments []*commentv1.Attachment) []domain.Attachment {
+	var res []domain.Attachment
+	for _, a := range attachments {
+		res = append(res, domain.Attachment{
+			ObjectKey: a.GetObjectKey(),
+			MimeType:  a.GetMimeType(),
+			Size:      a.GetSize(),
+			Width:     a.GetWidth(),
+			Height:    a.GetHeight(),
+			Checksum:  a.GetChecksum(),
+		})
+	}
+	return res
+}
+
 func (s *CommentServiceServer) toDTO(domainComments []domain.Comment) []*commentv1.Comment {
 	rpcComments := make([]*commentv1.Comment, 0, len(domainComments))
 	for _, domainComment := range domainComments {
-		rpcComment := &commentv1.Comment{
-			Id:            domainComment.Id,
-			CommentatorId: domainComment.Commentator.ID,
-			Biz:           domainComment.Biz,
-			BizId:         domainComment.BizId,
-			Content:       domainComment.Content,
-			ReplyToUid:    domainComment.ReplyToUid,
-			Ctime:         domainComment.CTime.UnixMilli(),
-			Utime:         domainComment.UTime.UnixMilli(),
-		}
-		if domainComment.RootComment != nil {
-			rpcComment.RootComment = &commentv1.Comment{
-				Id: domainComment.RootComment.Id,
-			}
-		}
-		if domainComment.ParentComment != nil {
-			rpcComment.ParentComment = &commentv1.Comment{
-				Id: domainComment.ParentComment.Id,
-			}
-		}
-		rpcComments = append(rpcComments, rpcComment)
+		rpcComments = append(rpcComments, convertToV(domainComment))
 	}
 	rpcCommentMap := make(map[int64]*commentv1.Comment, len(rpcComments))
 	for _, rpcComment := range rpcComments {
@@ -0,0 +1,74 @@
+package grpc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	commentv1 "github.com/MuxiKeStack/be-api/gen/proto/comment/v1"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/MuxiKeStack/be-comment/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// 每一个响应消息最多携带多少字节
+const exportChunkSize = 32 << 10
+
+// CommentExportServer 给管理后台用的评论导出，响应按顺序拼起来就是完整的导出文件。
+// 导出没有按用户鉴权，只注册在内网监听的服务器上，不对外暴露
+type CommentExportServer struct {
+	commentv1.UnimplementedCommentExportServiceServer
+	svc service.CommentExportService
+}
+
+func NewCommentExportServer(svc service.CommentExportService) *CommentExportServer {
+	return &CommentExportServer{svc: svc}
+}
+
+func (s *CommentExportServer) Register(server grpc.ServiceRegistrar) {
+	commentv1.RegisterCommentExportServiceServer(server, s)
+}
+
+func (s *CommentExportServer) ExportComments(req *commentv1.ExportCommentsRequest,
+	stream commentv1.CommentExportService_ExportCommentsServer) error {
+	w := bufio.NewWriterSize(&exportStreamWriter{stream: stream}, exportChunkSize)
+	_, err := s.svc.Export(stream.Context(), toExportFilter(req), req.GetFormat(), w)
+	if errors.Is(err, service.ErrInvalidExportFilter) || errors.Is(err, service.ErrUnknownExportFormat) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// toExportFilter 时间是毫秒，0 表示不限制
+func toExportFilter(req *commentv1.ExportCommentsRequest) domain.CommentFilter {
+	filter := domain.CommentFilter{
+		Biz:   req.GetBiz(),
+		BizId: req.GetBizId(),
+		Uid:   req.GetUid(),
+	}
+	if req.GetStartTime() > 0 {
+		filter.StartTime = time.UnixMilli(req.GetStartTime())
+	}
+	if req.GetEndTime() > 0 {
+		filter.EndTime = time.UnixMilli(req.GetEndTime())
+	}
+	return filter
+}
+
+type exportStreamWriter struct {
+	stream commentv1.CommentExportService_ExportCommentsServer
+}
+
+func (w *exportStreamWriter) Write(p []byte) (int, error) {
+	// Send 之后消息可能还会被 stats handler 之类的读取，bufio 会复用 p，要拷贝一份
+	err := w.stream.Send(&commentv1.ExportCommentsResponse{Data: bytes.Clone(p)})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
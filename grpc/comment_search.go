@@ -0,0 +1,68 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	commentv1 "github.com/MuxiKeStack/be-api/gen/proto/comment/v1"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/MuxiKeStack/be-comment/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// CommentSearchServiceServer 给管理员和审核用的评论搜索，按 uid 过滤的时候匿名评论的作者也能查出来，
+// 所以和导出一样只注册在内网监听的服务器上，不对外暴露
+type CommentSearchServiceServer struct {
+	svc service.CommentSearchService
+	commentv1.UnimplementedCommentSearchServiceServer
+}
+
+func NewCommentSearchServiceServer(svc service.CommentSearchService) *CommentSearchServiceServer {
+	return &CommentSearchServiceServer{svc: svc}
+}
+
+func (s *CommentSearchServiceServer) Register(server grpc.ServiceRegistrar) {
+	commentv1.RegisterCommentSearchServiceServer(server, s)
+}
+
+// SearchComments 先新后旧，继续翻页的时候 cur_comment_id 传上一页最后一条的 id
+func (s *CommentSearchServiceServer) SearchComments(ctx context.Context, request *commentv1.SearchCommentsRequest) (*commentv1.SearchCommentsResponse, error) {
+	res, err := s.svc.Search(ctx, request.GetKeyword(), toSearchFilter(request),
+		request.GetCurCommentId(), request.GetLimit())
+	if errors.Is(err, service.ErrInvalidKeyword) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+	hits := make([]*commentv1.CommentSearchHit, 0, len(res.Hits))
+	for _, hit := range res.Hits {
+		hits = append(hits, &commentv1.CommentSearchHit{
+			Comment:   convertToV(hit.Comment),
+			Highlight: hit.Highlight,
+		})
+	}
+	return &commentv1.SearchCommentsResponse{
+		Hits:    hits,
+		Total:   res.Total,
+		HasMore: res.HasMore,
+	}, nil
+}
+
+// toSearchFilter 时间是毫秒，0 表示不限制
+func toSearchFilter(req *commentv1.SearchCommentsRequest) domain.CommentFilter {
+	filter := domain.CommentFilter{
+		Biz:   req.GetBiz(),
+		BizId: req.GetBizId(),
+		Uid:   req.GetUid(),
+	}
+	if req.GetStartTime() > 0 {
+		filter.StartTime = time.UnixMilli(req.GetStartTime())
+	}
+	if req.GetEndTime() > 0 {
+		filter.EndTime = time.UnixMilli(req.GetEndTime())
+	}
+	return filter
+}
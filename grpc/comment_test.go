@@ -0,0 +1,237 @@
+package grpc
+
+import (
+	commentv1 "github.com/MuxiKeStack/be-api/gen/proto/comment/v1"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestConvertToV(t *testing.T) {
+	ctime := time.UnixMilli(1709251200000)
+	testCases := []struct {
+		name    string
+		comment domain.Comment
+		want    *commentv1.Comment
+	}{
+		{
+			name: "回复",
+			comment: domain.Comment{
+				Id:            3,
+				Commentator:   domain.User{ID: 10},
+				Biz:           commentv1.Biz_Evaluation,
+				BizId:         100,
+				Content:       "同意",
+				RootComment:   &domain.Comment{Id: 1},
+				ParentComment: &domain.Comment{Id: 2},
+				ReplyToUid:    11,
+				CTime:         ctime,
+				UTime:         ctime,
+				WriteSeq:      7,
+			},
+			want: &commentv1.Comment{
+				Id:            3,
+				CommentatorId: 10,
+				Biz:           commentv1.Biz_Evaluation,
+				BizId:         100,
+				Content:       "同意",
+				RootComment:   &commentv1.Comment{Id: 1},
+				ParentComment: &commentv1.Comment{Id: 2},
+				ReplyToUid:    11,
+				Ctime:         1709251200000,
+				Utime:         1709251200000,
+				WriteSeq:      7,
+			},
+		},
+		{
+			name: "附件",
+			comment: domain.Comment{
+				Id:    1,
+				CTime: ctime,
+				UTime: ctime,
+				Attachments: []domain.Attachment{
+					{ObjectKey: "comment/a.png", MimeType: "image/png", Size: 3, Width: 1, Height: 2, Checksum: "abc"},
+					{ObjectKey: "comment/b.pdf", MimeType: "application/pdf", Size: 4},
+				},
+			},
+			want: &commentv1.Comment{
+				Id:    1,
+				Ctime: 1709251200000,
+				Utime: 1709251200000,
+				Attachments: []*commentv1.Attachment{
+					{ObjectKey: "comment/a.png", MimeType: "image/png", Size: 3, Width: 1, Height: 2, Checksum: "abc"},
+					{ObjectKey: "comment/b.pdf", MimeType: "application/pdf", Size: 4},
+				},
+			},
+		},
+		{
+			name: "Markdown 带上渲染好的 HTML",
+			comment: domain.Comment{
+				Id:          1,
+				Content:     "**好课**",
+				Format:      domain.ContentFormatMarkdown,
+				ContentHTML: "<p><strong>好课</strong></p>",
+				CTime:       ctime,
+				UTime:       ctime,
+			},
+			want: &commentv1.Comment{
+				Id:          1,
+				Content:     "**好课**",
+				Format:      commentv1.ContentFormat_CONTENT_FORMAT_MARKDOWN,
+				ContentHtml: "<p><strong>好课</strong></p>",
+				Ctime:       1709251200000,
+				Utime:       1709251200000,
+			},
+		},
+		{
+			name: "回复匿名评论的匿名评论",
+			comment: domain.Comment{
+				Id:                  2,
+				Commentator:         domain.User{Name: domain.Pseudonym(2)},
+				ParentComment:       &domain.Comment{Id: 1},
+				CTime:               ctime,
+				UTime:               ctime,
+				Anonymous:           true,
+				AnonymousSeq:        2,
+				ReplyToAnonymousSeq: 1,
+			}.Masked(),
+			want: &commentv1.Comment{
+				Id:                  2,
+				ParentComment:       &commentv1.Comment{Id: 1},
+				Ctime:               1709251200000,
+				Utime:               1709251200000,
+				Anonymous:           true,
+				AnonymousSeq:        2,
+				ReplyToAnonymousSeq: 1,
+			},
+		},
+		{
+			name: "回复树",
+			comment: domain.Comment{
+				Id:    1,
+				CTime: ctime,
+				UTime: ctime,
+				Children: []domain.Comment{
+					{Id: 2, CTime: ctime, UTime: ctime, ParentComment: &domain.Comment{Id: 1}},
+				},
+			},
+			want: &commentv1.Comment{
+				Id:    1,
+				Ctime: 1709251200000,
+				Utime: 1709251200000,
+				Children: []*commentv1.Comment{
+					{Id: 2, Ctime: 1709251200000, Utime: 1709251200000, ParentComment: &commentv1.Comment{Id: 1}},
+				},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, convertToV(tc.comment))
+		})
+	}
+}
+
+func TestConvertToDomain(t *testing.T) {
+	testCases := []struct {
+		name    string
+		comment *commentv1.Comment
+		want    domain.Comment
+	}{
+		{
+			name: "回复",
+			comment: &commentv1.Comment{
+				CommentatorId: 10,
+				Biz:           commentv1.Biz_Evaluation,
+				BizId:         100,
+				Content:       "同意",
+				RootComment:   &commentv1.Comment{Id: 1},
+				ParentComment: &commentv1.Comment{Id: 2},
+			},
+			want: domain.Comment{
+				Commentator:   domain.User{ID: 10},
+				Biz:           commentv1.Biz_Evaluation,
+				BizId:         100,
+				Content:       "同意",
+				RootComment:   &domain.Comment{Id: 1},
+				ParentComment: &domain.Comment{Id: 2},
+			},
+		},
+		{
+			name: "附件",
+			comment: &commentv1.Comment{
+				CommentatorId: 10,
+				Attachments: []*commentv1.Attachment{
+					{ObjectKey: "comment/a.png", MimeType: "image/png", Size: 3, Width: 1, Height: 2, Checksum: "abc"},
+				},
+			},
+			want: domain.Comment{
+				Commentator: domain.User{ID: 10},
+				Attachments: []domain.Attachment{
+					{ObjectKey: "comment/a.png", MimeType: "image/png", Size: 3, Width: 1, Height: 2, Checksum: "abc"},
+				},
+			},
+		},
+		{
+			name: "客户端传的 HTML 不用",
+			comment: &commentv1.Comment{
+				CommentatorId: 10,
+				Content:       "**好课**",
+				Format:        commentv1.ContentFormat_CONTENT_FORMAT_MARKDOWN,
+				ContentHtml:   "<script></script>",
+			},
+			want: domain.Comment{
+				Commentator: domain.User{ID: 10},
+				Content:     "**好课**",
+				Format:      domain.ContentFormatMarkdown,
+			},
+		},
+		{
+			name: "匿名发表，编号由服务端分配",
+			comment: &commentv1.Comment{
+				CommentatorId:       10,
+				Content:             "匿名说一句",
+				Anonymous:           true,
+				AnonymousSeq:        5,
+				ReplyToAnonymousSeq: 6,
+			},
+			want: domain.Comment{
+				Commentator: domain.User{ID: 10},
+				Content:     "匿名说一句",
+				Anonymous:   true,
+			},
+		},
+		{
+			name: "@ 的人",
+			comment: &commentv1.Comment{
+				CommentatorId: 10,
+				MentionUids:   []int64{11, 12},
+			},
+			want: domain.Comment{
+				Commentator: domain.User{ID: 10},
+				MentionUids: []int64{11, 12},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, convertToDomain(tc.comment))
+		})
+	}
+}
+
+func TestCommentServiceServer_toDTO(t *testing.T) {
+	s := &CommentServiceServer{}
+	res := s.toDTO([]domain.Comment{
+		{Id: 1},
+		{Id: 2, RootComment: &domain.Comment{Id: 1}, ParentComment: &domain.Comment{Id: 1}},
+		// 父评论不在这一批里面，只带 id
+		{Id: 4, RootComment: &domain.Comment{Id: 1}, ParentComment: &domain.Comment{Id: 3}},
+	})
+	assert.Len(t, res, 3)
+	assert.Same(t, res[0], res[1].RootComment)
+	assert.Same(t, res[0], res[1].ParentComment)
+	assert.Same(t, res[0], res[2].RootComment)
+	assert.Equal(t, &commentv1.Comment{Id: 3}, res[2].ParentComment)
+}
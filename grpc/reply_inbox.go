@@ -0,0 +1,45 @@
+package grpc
+
+import (
+	"context"
+	commentv1 "github.com/MuxiKeStack/be-api/gen/proto/comment/v1"
+	"github.com/MuxiKeStack/be-comment/service"
+	"google.golang.org/grpc"
+)
+
+// ReplyInboxServiceServer "回复我的"，uid 由网关鉴权之后填进来
+type ReplyInboxServiceServer struct {
+	svc service.ReplyInboxService
+	commentv1.UnimplementedReplyInboxServiceServer
+}
+
+func NewReplyInboxServiceServer(svc service.ReplyInboxService) *ReplyInboxServiceServer {
+	return &ReplyInboxServiceServer{svc: svc}
+}
+
+func (s *ReplyInboxServiceServer) Register(server grpc.ServiceRegistrar) {
+	commentv1.RegisterReplyInboxServiceServer(server, s)
+}
+
+func (s *ReplyInboxServiceServer) ListReplies(ctx context.Context, request *commentv1.ListRepliesRequest) (*commentv1.ListRepliesResponse, error) {
+	cs, err := s.svc.ListReplies(ctx, request.GetUid(), request.GetCurCommentId(), request.GetLimit())
+	if err != nil {
+		return nil, err
+	}
+	replies := make([]*commentv1.Comment, 0, len(cs))
+	for _, c := range cs {
+		replies = append(replies, convertToV(c))
+	}
+	return &commentv1.ListRepliesResponse{Replies: replies}, nil
+}
+
+func (s *ReplyInboxServiceServer) UnreadCount(ctx context.Context, request *commentv1.UnreadCountRequest) (*commentv1.UnreadCountResponse, error) {
+	count, err := s.svc.UnreadCount(ctx, request.GetUid())
+	return &commentv1.UnreadCountResponse{Count: count}, err
+}
+
+// MarkRead comment_id 及之前的回复都标记为已读，为 0 表示全部已读
+func (s *ReplyInboxServiceServer) MarkRead(ctx context.Context, request *commentv1.MarkReadRequest) (*commentv1.MarkReadResponse, error) {
+	err := s.svc.MarkRead(ctx, request.GetUid(), request.GetCommentId())
+	return &commentv1.MarkReadResponse{}, err
+}
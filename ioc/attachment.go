@@ -0,0 +1,34 @@
+package ioc
+
+import (
+	"github.com/MuxiKeStack/be-comment/service"
+	"github.com/spf13/viper"
+	"strings"
+)
+
+func InitAttachmentPolicy() service.AttachmentPolicy {
+	type MimeType struct {
+		Type string `yaml:"type"`
+		// 单位字节
+		MaxSize int64 `yaml:"maxSize"`
+	}
+	type Config struct {
+		MaxCount  int        `yaml:"maxCount"`
+		KeyPrefix string     `yaml:"keyPrefix"`
+		MimeTypes []MimeType `yaml:"mimeTypes"`
+	}
+	var cfg Config
+	err := viper.UnmarshalKey("attachment", &cfg)
+	if err != nil {
+		panic(err)
+	}
+	policy := service.AttachmentPolicy{
+		MaxCount:  cfg.MaxCount,
+		KeyPrefix: cfg.KeyPrefix,
+		MimeTypes: make(map[string]int64, len(cfg.MimeTypes)),
+	}
+	for _, mt := range cfg.MimeTypes {
+		policy.MimeTypes[strings.ToLower(mt.Type)] = mt.MaxSize
+	}
+	return policy
+}
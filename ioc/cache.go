@@ -0,0 +1,36 @@
+package ioc
+
+import (
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/MuxiKeStack/be-comment/repository/cache"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"time"
+)
+
+func InitCommentCache(client redis.UniversalClient, l logger.Logger) cache.CommentCache {
+	type Config struct {
+		// 本地缓存最多缓存多少个 key
+		Capacity int `yaml:"capacity"`
+		// 本地缓存过期时间，单位秒
+		TTL int64 `yaml:"ttl"`
+	}
+	cfg := Config{
+		Capacity: 10000,
+		TTL:      5,
+	}
+	err := viper.UnmarshalKey("cache.local", &cfg)
+	if err != nil {
+		panic(err)
+	}
+	local := cache.NewLocalCache(cfg.Capacity, time.Second*time.Duration(cfg.TTL))
+	return cache.NewMultiLevelCommentCache(local, cache.NewRedisCommentCache(client), client, l)
+}
+
+func InitReplyInboxCache(client redis.UniversalClient) cache.ReplyInboxCache {
+	return cache.NewRedisReplyInboxCache(client)
+}
+
+func InitBlockCache(client redis.UniversalClient) cache.BlockCache {
+	return cache.NewRedisBlockCache(client)
+}
@@ -0,0 +1,10 @@
+package ioc
+
+import (
+	"github.com/MuxiKeStack/be-comment/events"
+	"github.com/MuxiKeStack/be-comment/pkg/saramax"
+)
+
+func InitConsumers(commentWriteConsumer *events.CommentWriteConsumer) []saramax.Consumer {
+	return []saramax.Consumer{commentWriteConsumer}
+}
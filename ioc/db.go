@@ -18,6 +18,8 @@ func InitDB(l logger.Logger) *gorm.DB {
 		panic(err)
 	}
 	db, err := gorm.Open(mysql.Open(cfg.DSN), &gorm.Config{
+		// 把外键约束之类的错误转换成 gorm 的错误，方便上层判断
+		TranslateError: true,
 		Logger: glogger.New(gormLoggerFunc(l.Debug), glogger.Config{
 			SlowThreshold: 0,
 			LogLevel:      glogger.Info, // 以Debug模式打印所有Info级别能产生的gorm日志
@@ -4,6 +4,7 @@ import (
 	"github.com/MuxiKeStack/be-comment/grpc"
 	"github.com/MuxiKeStack/be-comment/pkg/grpcx"
 	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/MuxiKeStack/be-comment/pkg/tracex"
 	"github.com/go-kratos/kratos/v2/middleware/recovery"
 	kgrpc "github.com/go-kratos/kratos/v2/transport/grpc"
 	"github.com/spf13/viper"
@@ -11,7 +12,8 @@ import (
 	"time"
 )
 
-func InitGRPCxKratosServer(commentServer *grpc.CommentServiceServer, ecli *clientv3.Client, l logger.Logger) grpcx.Server {
+func InitGRPCxKratosServer(commentServer *grpc.CommentServiceServer, inboxServer *grpc.ReplyInboxServiceServer,
+	blockServer *grpc.BlockServiceServer, ecli *clientv3.Client, l logger.Logger) grpcx.Server {
 	type Config struct {
 		Name    string `yaml:"name"`
 		Weight  int    `yaml:"weight"`
@@ -25,10 +27,12 @@ func InitGRPCxKratosServer(commentServer *grpc.CommentServiceServer, ecli *clien
 	}
 	server := kgrpc.NewServer(
 		kgrpc.Address(cfg.Addr),
-		kgrpc.Middleware(recovery.Recovery()),
+		kgrpc.Middleware(recovery.Recovery(), tracex.Server()),
 		kgrpc.Timeout(100*time.Second), // TODO
 	)
 	commentServer.Register(server)
+	inboxServer.Register(server)
+	blockServer.Register(server)
 	return &grpcx.KratosServer{
 		Server:     server,
 		Name:       cfg.Name,
@@ -38,3 +42,21 @@ func InitGRPCxKratosServer(commentServer *grpc.CommentServiceServer, ecli *clien
 		L:          l,
 	}
 }
+
+// InitInternalGRPCServer 管理接口没有按用户鉴权，只能监听在内网地址上。
+// 必须单独配置地址，避免不小心和对外的服务器用同一个端口。
+// 搜索可以按 uid 过滤，会暴露匿名评论的作者，只给管理后台用
+func InitInternalGRPCServer(exportServer *grpc.CommentExportServer,
+	searchServer *grpc.CommentSearchServiceServer) *grpcx.InternalServer {
+	addr := viper.GetString("grpc.internal.addr")
+	if addr == "" {
+		panic("缺少 grpc.internal.addr 配置")
+	}
+	server := kgrpc.NewServer(
+		kgrpc.Address(addr),
+		kgrpc.Middleware(recovery.Recovery(), tracex.Server()),
+	)
+	exportServer.Register(server)
+	searchServer.Register(server)
+	return &grpcx.InternalServer{Server: server}
+}
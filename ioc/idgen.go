@@ -0,0 +1,25 @@
+package ioc
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-comment/pkg/idgen"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// InitNodeLease 每个实例启动的时候从 redis 租一个节点号，避免多个实例用同一个节点号，
+// 退出的时候释放，挂掉的话 30 秒之后过期
+func InitNodeLease(client redis.UniversalClient, l logger.Logger) *idgen.NodeLease {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	lease, err := idgen.AcquireNodeLease(ctx, client, "kstack:comment:idgen_node", time.Second*30, l)
+	if err != nil {
+		panic(err)
+	}
+	return lease
+}
+
+func InitIDGenerator(lease *idgen.NodeLease) idgen.Generator {
+	return idgen.NewLeasedSnowflake(lease)
+}
@@ -0,0 +1,51 @@
+package ioc
+
+import (
+	"github.com/MuxiKeStack/be-comment/job"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/MuxiKeStack/be-comment/repository/cache"
+	"github.com/MuxiKeStack/be-comment/repository/dao"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"time"
+)
+
+type reconcileJobConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 执行间隔，单位分钟
+	Interval int64 `yaml:"interval"`
+	// 单次执行的超时时间，单位分钟
+	Timeout int64 `yaml:"timeout"`
+	// 每次 GROUP BY 扫描多少条评论
+	Step int64 `yaml:"step"`
+}
+
+func loadReconcileJobConfig() reconcileJobConfig {
+	cfg := reconcileJobConfig{
+		Interval: 24 * 60,
+		Timeout:  30,
+		Step:     10000,
+	}
+	err := viper.UnmarshalKey("job.reconcile", &cfg)
+	if err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+func InitCommentCountReconcileJob(d dao.CommentDAO, c cache.CommentCache, l logger.Logger) *job.CommentCountReconcileJob {
+	cfg := loadReconcileJobConfig()
+	return job.NewCommentCountReconcileJob(d, c, l, cfg.Step)
+}
+
+func InitJobRunners(reconcileJob *job.CommentCountReconcileJob, client redis.UniversalClient, l logger.Logger) []*job.IntervalRunner {
+	var res []*job.IntervalRunner
+	cfg := loadReconcileJobConfig()
+	if cfg.Enabled {
+		res = append(res, job.NewIntervalRunner(reconcileJob,
+			time.Minute*time.Duration(cfg.Interval),
+			time.Minute*time.Duration(cfg.Timeout),
+			client, l))
+	}
+	return res
+}
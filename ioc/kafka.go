@@ -1,27 +1,286 @@
 package ioc
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"github.com/IBM/sarama"
 	"github.com/MuxiKeStack/be-comment/events"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/MuxiKeStack/be-comment/pkg/saramax"
 	"github.com/spf13/viper"
+	"os"
+	"time"
 )
 
-func InitProducer(client sarama.Client) events.Producer {
-	producer, err := sarama.NewSyncProducerFromClient(client)
+type kafkaConfig struct {
+	Addrs    []string `yaml:"addrs"`
+	ClientID string   `yaml:"clientId"`
+	// 形如 3.6.0，为空的时候用 sarama 的默认版本
+	Version  string              `yaml:"version"`
+	SASL     kafkaSASLConfig     `yaml:"sasl"`
+	TLS      kafkaTLSConfig      `yaml:"tls"`
+	Producer kafkaProducerConfig `yaml:"producer"`
+	// 启动的时候检查的 topic，服务用到的 topic 都必须配置分区数和副本数
+	Topics []kafkaTopicConfig `yaml:"topics"`
+}
+
+type kafkaSASLConfig struct {
+	Enable bool `yaml:"enable"`
+	// PLAIN、SCRAM-SHA-256、SCRAM-SHA-512
+	Mechanism string `yaml:"mechanism"`
+	User      string `yaml:"user"`
+	Password  string `yaml:"password"`
+}
+
+type kafkaTLSConfig struct {
+	Enable bool   `yaml:"enable"`
+	CAFile string `yaml:"caFile"`
+	// 双向认证的时候才需要
+	CertFile           string `yaml:"certFile"`
+	KeyFile            string `yaml:"keyFile"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+type kafkaProducerConfig struct {
+	// all、local、none
+	Acks       string `yaml:"acks"`
+	Idempotent bool   `yaml:"idempotent"`
+	// none、gzip、snappy、lz4、zstd
+	Compression string `yaml:"compression"`
+	Retry       struct {
+		Max int `yaml:"max"`
+		// 单位毫秒
+		Backoff int64 `yaml:"backoff"`
+	} `yaml:"retry"`
+}
+
+type kafkaTopicConfig struct {
+	Name              string `yaml:"name"`
+	Partitions        int32  `yaml:"partitions"`
+	ReplicationFactor int16  `yaml:"replicationFactor"`
+}
+
+func loadKafkaConfig() kafkaConfig {
+	var cfg kafkaConfig
+	err := viper.UnmarshalKey("kafka", &cfg)
 	if err != nil {
 		panic(err)
 	}
-	return events.NewSaramaProducer(producer)
+	return cfg
 }
 
-func InitKafka() sarama.Client {
-	type Config struct {
-		Addrs []string `yaml:"addrs"`
-	}
+// newSaramaConfig 同步生产者、异步生产者和消费者共用的连接、认证和生产者配置
+func newSaramaConfig(cfg kafkaConfig) *sarama.Config {
 	saramaCfg := sarama.NewConfig()
+	if cfg.ClientID != "" {
+		saramaCfg.ClientID = cfg.ClientID
+	}
+	if cfg.Version != "" {
+		version, err := sarama.ParseKafkaVersion(cfg.Version)
+		if err != nil {
+			panic(err)
+		}
+		saramaCfg.Version = version
+	}
+	if cfg.SASL.Enable {
+		saramaCfg.Net.SASL.Enable = true
+		saramaCfg.Net.SASL.User = cfg.SASL.User
+		saramaCfg.Net.SASL.Password = cfg.SASL.Password
+		switch cfg.SASL.Mechanism {
+		case "", sarama.SASLTypePlaintext:
+			saramaCfg.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		case sarama.SASLTypeSCRAMSHA256:
+			saramaCfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+			saramaCfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &saramax.XDGSCRAMClient{HashGeneratorFcn: saramax.SHA256}
+			}
+		case sarama.SASLTypeSCRAMSHA512:
+			saramaCfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+			saramaCfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &saramax.XDGSCRAMClient{HashGeneratorFcn: saramax.SHA512}
+			}
+		default:
+			panic(fmt.Errorf("不支持的 SASL 认证方式 %s", cfg.SASL.Mechanism))
+		}
+	}
+	if cfg.TLS.Enable {
+		tlsCfg, err := newKafkaTLSConfig(cfg.TLS)
+		if err != nil {
+			panic(err)
+		}
+		saramaCfg.Net.TLS.Enable = true
+		saramaCfg.Net.TLS.Config = tlsCfg
+	}
+
+	switch cfg.Producer.Acks {
+	case "", "all":
+		saramaCfg.Producer.RequiredAcks = sarama.WaitForAll
+	case "local":
+		saramaCfg.Producer.RequiredAcks = sarama.WaitForLocal
+	case "none":
+		saramaCfg.Producer.RequiredAcks = sarama.NoResponse
+	default:
+		panic(fmt.Errorf("不支持的 acks 配置 %s", cfg.Producer.Acks))
+	}
+	if cfg.Producer.Compression != "" {
+		err := saramaCfg.Producer.Compression.UnmarshalText([]byte(cfg.Producer.Compression))
+		if err != nil {
+			panic(err)
+		}
+	}
+	if cfg.Producer.Retry.Max > 0 {
+		saramaCfg.Producer.Retry.Max = cfg.Producer.Retry.Max
+	}
+	if cfg.Producer.Retry.Backoff > 0 {
+		saramaCfg.Producer.Retry.Backoff = time.Duration(cfg.Producer.Retry.Backoff) * time.Millisecond
+	}
+	if cfg.Producer.Idempotent {
+		// 幂等生产者对其它配置有要求，不满足的话 sarama 会直接报错
+		saramaCfg.Producer.Idempotent = true
+		saramaCfg.Producer.RequiredAcks = sarama.WaitForAll
+		saramaCfg.Net.MaxOpenRequests = 1
+		if saramaCfg.Producer.Retry.Max == 0 {
+			saramaCfg.Producer.Retry.Max = 3
+		}
+		if !saramaCfg.Version.IsAtLeast(sarama.V0_11_0_0) {
+			saramaCfg.Version = sarama.V0_11_0_0
+		}
+	}
 	saramaCfg.Producer.Return.Successes = true
-	var cfg Config
-	err := viper.UnmarshalKey("kafka", &cfg)
+	return saramaCfg
+}
+
+func newKafkaTLSConfig(cfg kafkaTLSConfig) (*tls.Config, error) {
+	tlsCfg := &tls.Config{
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		ca, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("解析 CA 证书失败 %s", cfg.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
+
+// ensureTopics 创建缺少的 topic，分区数比配置少的扩容到配置的分区数。
+// 分区只能增加不能减少，分区数比配置多的不做处理
+func ensureTopics(cfg kafkaConfig, saramaCfg *sarama.Config) error {
+	admin, err := sarama.NewClusterAdmin(cfg.Addrs, saramaCfg)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	topics, err := kafkaTopics(cfg)
+	if err != nil {
+		return err
+	}
+	existing, err := admin.ListTopics()
+	if err != nil {
+		return err
+	}
+	for _, topic := range topics {
+		detail, ok := existing[topic.Name]
+		if !ok {
+			err = admin.CreateTopic(topic.Name, &sarama.TopicDetail{
+				NumPartitions:     topic.Partitions,
+				ReplicationFactor: topic.ReplicationFactor,
+			}, false)
+			// 多个实例同时启动的时候可能被别的实例抢先创建了
+			if err != nil && !errors.Is(err, sarama.ErrTopicAlreadyExists) {
+				return fmt.Errorf("创建 topic %s 失败: %w", topic.Name, err)
+			}
+			continue
+		}
+		if detail.NumPartitions < topic.Partitions {
+			err = admin.CreatePartitions(topic.Name, topic.Partitions, nil, false)
+			if err != nil && !errors.Is(err, sarama.ErrInvalidPartitions) {
+				return fmt.Errorf("扩容 topic %s 失败: %w", topic.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// kafkaTopics 服务用到的 topic 都要检查。
+// 不给默认值，单分区、单副本的 topic 上了线很难发现，宁可启动失败
+func kafkaTopics(cfg kafkaConfig) ([]kafkaTopicConfig, error) {
+	configured := make(map[string]kafkaTopicConfig, len(cfg.Topics))
+	for _, topic := range cfg.Topics {
+		configured[topic.Name] = topic
+	}
+	names := events.Topics()
+	res := make([]kafkaTopicConfig, 0, len(names))
+	for _, name := range names {
+		topic, ok := configured[name]
+		if !ok {
+			return nil, fmt.Errorf("kafka.topics 缺少 topic %s 的配置", name)
+		}
+		if topic.Partitions <= 0 || topic.ReplicationFactor <= 0 {
+			return nil, fmt.Errorf("topic %s 必须配置 partitions 和 replicationFactor", name)
+		}
+		res = append(res, topic)
+	}
+	return res, nil
+}
+
+func InitSyncProducer(client sarama.Client) sarama.SyncProducer {
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		panic(err)
+	}
+	return producer
+}
+
+// InitAsyncProducer 异步生产者单独用一份配置，攒批会增加延迟，不能影响同步发送
+func InitAsyncProducer() sarama.AsyncProducer {
+	cfg := loadKafkaConfig()
+	saramaCfg := newSaramaConfig(cfg)
+	// 缓冲区大小，满了之后发送方会阻塞
+	saramaCfg.ChannelBufferSize = 1024
+	// 攒批发送，满足任意一个条件就发
+	saramaCfg.Producer.Flush.Frequency = time.Millisecond * 100
+	saramaCfg.Producer.Flush.Messages = 100
+	saramaCfg.Producer.Flush.Bytes = 1 << 20
+	if cfg.Producer.Compression == "" {
+		saramaCfg.Producer.Compression = sarama.CompressionSnappy
+	}
+	producer, err := sarama.NewAsyncProducer(cfg.Addrs, saramaCfg)
+	if err != nil {
+		panic(err)
+	}
+	return producer
+}
+
+func InitProducer(producer sarama.SyncProducer, async sarama.AsyncProducer, l logger.Logger) events.Producer {
+	// 事件都是这个服务自己定义的结构体，用 JSON 编码
+	return events.NewSaramaProducer(producer, async, saramax.JSONCodec{}, l)
+}
+
+func InitDLQReplayer(client sarama.Client, producer sarama.SyncProducer, l logger.Logger) *saramax.Replayer {
+	return saramax.NewReplayer(client, producer, l)
+}
+
+func InitKafka() sarama.Client {
+	cfg := loadKafkaConfig()
+	saramaCfg := newSaramaConfig(cfg)
+	// 新的消费者组从最早的消息开始消费，避免消费者组创建之前的消息（比如死信）被跳过
+	saramaCfg.Consumer.Offsets.Initial = sarama.OffsetOldest
+	err := ensureTopics(cfg, saramaCfg)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,56 @@
+package ioc
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestKafkaTopics(t *testing.T) {
+	full := []kafkaTopicConfig{
+		{Name: "feed_event", Partitions: 3, ReplicationFactor: 3},
+		{Name: "comment_write", Partitions: 3, ReplicationFactor: 3},
+		{Name: "comment_write_dlq", Partitions: 1, ReplicationFactor: 3},
+	}
+	testCases := []struct {
+		name    string
+		topics  []kafkaTopicConfig
+		wantErr bool
+	}{
+		{
+			name:   "全部配置了",
+			topics: full,
+		},
+		{
+			name:    "缺少 topic",
+			topics:  full[:2],
+			wantErr: true,
+		},
+		{
+			name: "没有配置分区数",
+			topics: []kafkaTopicConfig{
+				{Name: "feed_event", ReplicationFactor: 3},
+				full[1], full[2],
+			},
+			wantErr: true,
+		},
+		{
+			name: "没有配置副本数",
+			topics: []kafkaTopicConfig{
+				full[0], full[1],
+				{Name: "comment_write_dlq", Partitions: 1},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			topics, err := kafkaTopics(kafkaConfig{Topics: tc.topics})
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, tc.topics, topics)
+		})
+	}
+}
@@ -5,7 +5,7 @@ import (
 	"github.com/spf13/viper"
 )
 
-func InitRedis() redis.Cmdable {
+func InitRedis() redis.UniversalClient {
 	type Config struct {
 		Addr     string `yaml:"addr"`
 		Password string `yaml:"password"`
@@ -0,0 +1,122 @@
+package job
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/MuxiKeStack/be-comment/repository/cache"
+	"github.com/MuxiKeStack/be-comment/repository/dao"
+)
+
+// CommentCountReconcileJob 用 comments 表重新统计每个 <biz,bizId> 的评论数，
+// 和 BizCommentCount 表对账，修正有偏差的计数并删除对应的缓存。
+// 插入、删除、级联删除以及迁移的 InsertWithTime 都有可能让两边对不上
+type CommentCountReconcileJob struct {
+	dao   dao.CommentDAO
+	cache cache.CommentCache
+	l     logger.Logger
+	// 每次 GROUP BY 扫描多少条评论
+	step int64
+}
+
+type bizKey struct {
+	biz   int32
+	bizId int64
+}
+
+func NewCommentCountReconcileJob(dao dao.CommentDAO, cache cache.CommentCache, l logger.Logger,
+	step int64) *CommentCountReconcileJob {
+	if step <= 0 {
+		step = 10000
+	}
+	return &CommentCountReconcileJob{
+		dao:   dao,
+		cache: cache,
+		l:     l,
+		step:  step,
+	}
+}
+
+func (j *CommentCountReconcileJob) Name() string {
+	return "comment_count_reconcile"
+}
+
+func (j *CommentCountReconcileJob) Run(ctx context.Context) error {
+	counts, err := j.countComments(ctx)
+	if err != nil {
+		return err
+	}
+	// 找出和统计结果不一致的 <biz,bizId>
+	// 统计是分段扫描的，期间有并发的写入，所以这里只是候选，修正的时候会在事务里面精确地重新统计一次
+	var candidates []bizKey
+	const batchSize = 500
+	var curId int64
+	for {
+		bcs, er := j.dao.FindBizCommentCounts(ctx, curId, batchSize)
+		if er != nil {
+			return er
+		}
+		for _, bc := range bcs {
+			key := bizKey{biz: bc.Biz, bizId: bc.BizID}
+			if counts[key] != bc.Count {
+				candidates = append(candidates, key)
+			}
+			delete(counts, key)
+		}
+		if len(bcs) < batchSize {
+			break
+		}
+		curId = bcs[len(bcs)-1].ID
+	}
+	// 有评论但是没有计数行的
+	for key := range counts {
+		candidates = append(candidates, key)
+	}
+
+	var drifted int
+	for _, key := range candidates {
+		before, after, er := j.dao.ReconcileBizCommentCount(ctx, key.biz, key.bizId)
+		if er != nil {
+			return er
+		}
+		if before == after {
+			continue
+		}
+		drifted++
+		j.l.Warn("评论数与评论表不一致，已修正",
+			logger.Int32("biz", key.biz),
+			logger.Int64("bizId", key.bizId),
+			logger.Int64("before", before),
+			logger.Int64("after", after))
+		er = j.cache.DelBizCommentCount(ctx, key.biz, key.bizId)
+		if er != nil {
+			j.l.Error("删除评论数缓存失败",
+				logger.Error(er),
+				logger.Int32("biz", key.biz),
+				logger.Int64("bizId", key.bizId))
+		}
+	}
+	j.l.Info("评论数对账完成",
+		logger.Int("candidates", len(candidates)),
+		logger.Int("drifted", drifted))
+	return nil
+}
+
+// countComments 按主键分批 GROUP BY，汇总出每个 <biz,bizId> 的评论数
+func (j *CommentCountReconcileJob) countComments(ctx context.Context) (map[bizKey]int64, error) {
+	counts := make(map[bizKey]int64)
+	var lastId int64
+	for {
+		bcs, next, err := j.dao.CountGroupByBizAfter(ctx, lastId, int(j.step))
+		if err != nil {
+			return nil, err
+		}
+		// 没有更多的评论了
+		if next == 0 {
+			return counts, nil
+		}
+		for _, bc := range bcs {
+			counts[bizKey{biz: bc.Biz, bizId: bc.BizId}] += bc.Count
+		}
+		lastId = next
+	}
+}
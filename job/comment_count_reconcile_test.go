@@ -0,0 +1,167 @@
+package job
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/MuxiKeStack/be-comment/repository/cache"
+	"github.com/MuxiKeStack/be-comment/repository/dao"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"slices"
+	"testing"
+)
+
+// memoryCountDAO 评论表和计数表都放在内存里面，评论按 id 升序排好
+type memoryCountDAO struct {
+	dao.CommentDAO
+	comments []dao.Comment
+	counts   []dao.BizCommentCount
+	// CountGroupByBizAfter 被调用的次数
+	scans int
+}
+
+func (d *memoryCountDAO) CountGroupByBizAfter(ctx context.Context, afterId int64, limit int) ([]dao.BizCount, int64, error) {
+	d.scans++
+	idx, _ := slices.BinarySearchFunc(d.comments, afterId+1, func(c dao.Comment, id int64) int {
+		return int(c.Id - id)
+	})
+	batch := d.comments[idx:min(idx+limit, len(d.comments))]
+	grouped := make(map[dao.BizCount]int64)
+	var lastId int64
+	for _, c := range batch {
+		grouped[dao.BizCount{Biz: c.Biz, BizId: c.BizId}]++
+		lastId = c.Id
+	}
+	res := make([]dao.BizCount, 0, len(grouped))
+	for key, cnt := range grouped {
+		key.Count = cnt
+		res = append(res, key)
+	}
+	return res, lastId, nil
+}
+
+func (d *memoryCountDAO) FindBizCommentCounts(ctx context.Context, curId int64, limit int) ([]dao.BizCommentCount, error) {
+	var res []dao.BizCommentCount
+	for _, bc := range d.counts {
+		if bc.ID > curId && len(res) < limit {
+			res = append(res, bc)
+		}
+	}
+	return res, nil
+}
+
+func (d *memoryCountDAO) ReconcileBizCommentCount(ctx context.Context, biz int32, bizId int64) (int64, int64, error) {
+	var after int64
+	for _, c := range d.comments {
+		if c.Biz == biz && c.BizId == bizId {
+			after++
+		}
+	}
+	for i, bc := range d.counts {
+		if bc.Biz == biz && bc.BizID == bizId {
+			before := bc.Count
+			d.counts[i].Count = after
+			return before, after, nil
+		}
+	}
+	d.counts = append(d.counts, dao.BizCommentCount{ID: int64(len(d.counts) + 1), Biz: biz, BizID: bizId, Count: after})
+	return 0, after, nil
+}
+
+type recordingCountCache struct {
+	cache.CommentCache
+	deleted []dao.BizCount
+}
+
+func (c *recordingCountCache) DelBizCommentCount(ctx context.Context, biz int32, bizId int64) error {
+	c.deleted = append(c.deleted, dao.BizCount{Biz: biz, BizId: bizId})
+	return nil
+}
+
+func TestCommentCountReconcileJob_Run(t *testing.T) {
+	// 雪花 id，相邻的评论之间隔得很远
+	const base = int64(300000000000000000)
+	comments := func(bizIds ...int64) []dao.Comment {
+		res := make([]dao.Comment, 0, len(bizIds))
+		for i, bizId := range bizIds {
+			res = append(res, dao.Comment{Id: base + int64(i)<<22, Biz: 1, BizId: bizId})
+		}
+		return res
+	}
+	testCases := []struct {
+		name     string
+		comments []dao.Comment
+		counts   []dao.BizCommentCount
+		step     int64
+
+		wantCounts  map[int64]int64
+		wantDeleted []dao.BizCount
+		// 扫描评论表的次数，包括最后一次取到空的
+		wantScans int
+	}{
+		{
+			name:     "计数一致",
+			comments: comments(1, 1, 2),
+			counts: []dao.BizCommentCount{
+				{ID: 1, Biz: 1, BizID: 1, Count: 2},
+				{ID: 2, Biz: 1, BizID: 2, Count: 1},
+			},
+			step:       2,
+			wantCounts: map[int64]int64{1: 2, 2: 1},
+			wantScans:  3,
+		},
+		{
+			name:     "计数偏大偏小都修正",
+			comments: comments(1, 1, 1, 2),
+			counts: []dao.BizCommentCount{
+				{ID: 1, Biz: 1, BizID: 1, Count: 5},
+				{ID: 2, Biz: 1, BizID: 2, Count: 0},
+			},
+			step:        10,
+			wantCounts:  map[int64]int64{1: 3, 2: 1},
+			wantDeleted: []dao.BizCount{{Biz: 1, BizId: 1}, {Biz: 1, BizId: 2}},
+			wantScans:   2,
+		},
+		{
+			name:        "有评论没有计数行",
+			comments:    comments(3),
+			step:        10,
+			wantCounts:  map[int64]int64{3: 1},
+			wantDeleted: []dao.BizCount{{Biz: 1, BizId: 3}},
+			wantScans:   2,
+		},
+		{
+			name: "评论都被删掉了",
+			counts: []dao.BizCommentCount{
+				{ID: 1, Biz: 1, BizID: 4, Count: 2},
+			},
+			step:        10,
+			wantCounts:  map[int64]int64{4: 0},
+			wantDeleted: []dao.BizCount{{Biz: 1, BizId: 4}},
+			wantScans:   1,
+		},
+		{
+			name:       "同一个 bizId 跨批次",
+			comments:   comments(1, 2, 1, 2, 1),
+			counts:     []dao.BizCommentCount{{ID: 1, Biz: 1, BizID: 1, Count: 3}, {ID: 2, Biz: 1, BizID: 2, Count: 2}},
+			step:       1,
+			wantCounts: map[int64]int64{1: 3, 2: 2},
+			wantScans:  6,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &memoryCountDAO{comments: tc.comments, counts: tc.counts}
+			c := &recordingCountCache{}
+			j := NewCommentCountReconcileJob(d, c, logger.NewNopLogger(), tc.step)
+			require.NoError(t, j.Run(context.Background()))
+			counts := make(map[int64]int64, len(d.counts))
+			for _, bc := range d.counts {
+				counts[bc.BizID] = bc.Count
+			}
+			assert.Equal(t, tc.wantCounts, counts)
+			assert.ElementsMatch(t, tc.wantDeleted, c.deleted)
+			assert.Equal(t, tc.wantScans, d.scans)
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package job
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// IntervalRunner 每隔 interval 执行一次任务。
+// 多个实例都会启动 runner，通过 redis 抢占一个 interval 长度的锁，保证整个集群每个周期只执行一次
+type IntervalRunner struct {
+	job      Job
+	interval time.Duration
+	// 单次执行的超时时间
+	timeout time.Duration
+	cmd     redis.Cmdable
+	l       logger.Logger
+	cancel  func()
+	done    chan struct{}
+}
+
+func NewIntervalRunner(job Job, interval time.Duration, timeout time.Duration,
+	cmd redis.Cmdable, l logger.Logger) *IntervalRunner {
+	return &IntervalRunner{
+		job:      job,
+		interval: interval,
+		timeout:  timeout,
+		cmd:      cmd,
+		l:        l,
+	}
+}
+
+func (r *IntervalRunner) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.runOnce(ctx)
+			}
+		}
+	}()
+}
+
+// Stop 停止调度，并等待正在执行的任务退出
+func (r *IntervalRunner) Stop() {
+	if r.cancel == nil {
+		return
+	}
+	r.cancel()
+	<-r.done
+}
+
+func (r *IntervalRunner) runOnce(ctx context.Context) {
+	// 锁不主动释放，让它在这个周期内一直有效，避免其他实例在同一个周期里面再执行一次
+	ok, err := r.cmd.SetNX(ctx, "kstack:comment:job:"+r.job.Name(), 1, r.interval).Result()
+	if err != nil {
+		r.l.Error("抢占任务锁失败",
+			logger.String("job", r.job.Name()),
+			logger.Error(err))
+		return
+	}
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	start := time.Now()
+	err = r.job.Run(ctx)
+	if err != nil {
+		r.l.Error("执行任务失败",
+			logger.String("job", r.job.Name()),
+			logger.Error(err))
+		return
+	}
+	r.l.Info("执行任务成功",
+		logger.String("job", r.job.Name()),
+		logger.Int64("costMs", time.Since(start).Milliseconds()))
+}
@@ -1,26 +1,79 @@
 package main
 
 import (
+	"context"
+	"github.com/MuxiKeStack/be-comment/pkg/lifecycle"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"os"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
-	initViper()
-	server := InitGRPCServer()
-	err := server.Serve()
+	// 第一个参数不是 flag 的话就是子命令，例如 be-comment reconcile --config config/config.yaml
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+	initViper(pflag.CommandLine, os.Args[1:])
+	app := InitApp()
+	for _, c := range app.consumers {
+		err := c.Start()
+		if err != nil {
+			panic(err)
+		}
+	}
+	for _, r := range app.runners {
+		r.Start()
+	}
+	// 信号要在 Serve 之前监听，避免启动过程中收到的信号被漏掉
+	sig := lifecycle.Notify(syscall.SIGTERM, syscall.SIGINT)
+	serveErr := make(chan error, 2)
+	go func() {
+		serveErr <- app.server.Serve()
+	}()
+	go func() {
+		serveErr <- app.internal.Serve()
+	}()
+	select {
+	case <-sig:
+		app.l.Info("收到退出信号，开始关闭")
+	case err := <-serveErr:
+		// kratos 自己也会处理信号，这种情况下 Serve 正常返回
+		if err != nil {
+			app.l.Error("服务器异常退出，开始关闭", logger.Error(err))
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout())
+	defer cancel()
+	err := app.lifecycle().Shutdown(ctx)
 	if err != nil {
-		panic(err)
+		app.l.Error("关闭过程中出现错误", logger.Error(err))
 	}
 }
 
-func initViper() {
-	cfile := pflag.String("config", "config/config.yaml", "配置文件路径")
-	pflag.Parse()
+// shutdownTimeout 整个关闭过程的截止时间，要小于部署平台的强制终止时间（k8s 默认 30 秒）
+func shutdownTimeout() time.Duration {
+	seconds := viper.GetInt64("shutdown.timeout")
+	if seconds <= 0 {
+		seconds = 25
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func initViper(fs *pflag.FlagSet, args []string) {
+	cfile := fs.String("config", "config/config.yaml", "配置文件路径")
+	err := fs.Parse(args)
+	if err != nil {
+		panic(err)
+	}
 
 	viper.SetConfigType("yaml")
 	viper.SetConfigFile(*cfile)
-	err := viper.ReadInConfig()
+	err = viper.ReadInConfig()
 	if err != nil {
 		panic(err)
 	}
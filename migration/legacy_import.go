@@ -0,0 +1,466 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MuxiKeStack/be-comment/pkg/idgen"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/MuxiKeStack/be-comment/repository/cache"
+	"github.com/MuxiKeStack/be-comment/repository/dao"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+// 报告里面最多记录多少条失败明细，其余的只计数
+const maxReportedFailures = 1000
+
+// 同一毫秒的序列号只有 4096 个，全部试过一遍还冲突就放弃
+const maxBackfillAttempts = 4096
+
+type ImportOptions struct {
+	File   string
+	Format string
+	// 每一批处理完之后写一次断点
+	BatchSize int
+	// 断点文件，存在的话从上次中断的地方继续
+	CheckpointFile string
+	// 只校验和统计，不写数据库，也不写断点
+	DryRun bool
+}
+
+// ImportReport 导入的汇总结果，同时也是断点文件的内容
+type ImportReport struct {
+	File   string `json:"file"`
+	DryRun bool   `json:"dryRun"`
+	// 已经处理过的记录数，续传的时候跳过这么多条
+	Processed int64 `json:"processed"`
+	Imported  int64 `json:"imported"`
+	// 之前已经导入过的
+	Skipped int64 `json:"skipped"`
+	// 格式不对的
+	Invalid int64 `json:"invalid"`
+	// 父评论或者根评论找不到的，它们的回复也会找不到父评论
+	Orphaned  int64           `json:"orphaned"`
+	Failures  []ImportFailure `json:"failures"`
+	StartedAt time.Time       `json:"startedAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+	Finished  bool            `json:"finished"`
+}
+
+type ImportFailure struct {
+	Seq    int64  `json:"seq"`
+	OldId  int64  `json:"oldId,omitempty"`
+	Reason string `json:"reason"`
+}
+
+type bizKey struct {
+	biz   int32
+	bizId int64
+}
+
+// importedNode 已经导入的评论，子评论要用它来确定根评论和被回复的人
+type importedNode struct {
+	id     int64
+	rootId int64
+	uid    int64
+	biz    int32
+	bizId  int64
+}
+
+// LegacyImporter 把老系统导出的评论用 InsertWithTime 导入，保留原来的时间。
+// 老系统的 id 会按照创建时间重新分配，父评论和根评论按照映射关系转换成新的 id，
+// 所以导出文件里面父评论要排在回复前面（按老系统的 id 升序导出即可）
+type LegacyImporter struct {
+	dao     dao.CommentDAO
+	mapping dao.LegacyIdMappingDAO
+	cache   cache.CommentCache
+	l       logger.Logger
+	// 老 id 到已经导入的评论
+	nodes map[int64]importedNode
+}
+
+func NewLegacyImporter(dao dao.CommentDAO, mapping dao.LegacyIdMappingDAO,
+	cache cache.CommentCache, l logger.Logger) *LegacyImporter {
+	return &LegacyImporter{
+		dao:     dao,
+		mapping: mapping,
+		cache:   cache,
+		l:       l,
+		nodes:   make(map[int64]importedNode),
+	}
+}
+
+func (i *LegacyImporter) Import(ctx context.Context, opts ImportOptions) (ImportReport, error) {
+	report, err := i.loadCheckpoint(opts)
+	if err != nil {
+		return report, err
+	}
+	if report.Finished {
+		return report, nil
+	}
+	f, err := os.Open(opts.File)
+	if err != nil {
+		return report, err
+	}
+	defer f.Close()
+	reader, err := newLegacyReader(opts.Format, f)
+	if err != nil {
+		return report, err
+	}
+	// 跳过上次已经处理过的
+	for seq := int64(0); seq < report.Processed; seq++ {
+		_, err = reader.Next()
+		if err != nil {
+			return report, fmt.Errorf("跳过已经处理的记录失败: %w", err)
+		}
+	}
+	if report.Processed > 0 {
+		i.l.Info("从断点继续导入", logger.Int64("processed", report.Processed))
+	}
+	batchSize := max(opts.BatchSize, 1)
+	for {
+		batch := make([]legacyRecord, 0, batchSize)
+		for len(batch) < batchSize {
+			rec, er := reader.Next()
+			if er == io.EOF {
+				break
+			}
+			if er != nil {
+				return report, er
+			}
+			batch = append(batch, rec)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		// 一批处理到一半失败了不写断点，重新执行的时候这一批会重新处理，已经导入的会被跳过
+		err = i.importBatch(ctx, batch, &report, opts.DryRun)
+		if err != nil {
+			return report, err
+		}
+		report.Processed += int64(len(batch))
+		err = i.saveCheckpoint(opts, &report)
+		if err != nil {
+			return report, err
+		}
+		i.l.Info("导入了一批评论",
+			logger.Int64("processed", report.Processed),
+			logger.Int64("imported", report.Imported))
+	}
+	report.Finished = true
+	return report, i.saveCheckpoint(opts, &report)
+}
+
+func (i *LegacyImporter) importBatch(ctx context.Context, batch []legacyRecord,
+	report *ImportReport, dryRun bool) error {
+	// 先给这一批分配新的 id，之前导入过的沿用原来的
+	oldIds := make([]int64, 0, len(batch))
+	for _, rec := range batch {
+		if rec.Err == nil {
+			oldIds = append(oldIds, rec.Comment.Id)
+		}
+	}
+	existing, err := i.mapping.FindNewIds(ctx, oldIds)
+	if err != nil {
+		return err
+	}
+	newIds, rejected, err := i.allocateIds(ctx, batch, existing, dryRun)
+	if err != nil {
+		return err
+	}
+
+	// 导入的评论不经过缓存，涉及到的 <biz,bizId> 的评论数缓存要删掉
+	touched := make(map[bizKey]struct{})
+	for _, rec := range batch {
+		if rec.Err != nil {
+			report.Invalid++
+			report.addFailure(rec.Seq, 0, rec.Err.Error())
+			continue
+		}
+		lc := rec.Comment
+		if reason, ok := rejected[lc.Id]; ok {
+			report.Invalid++
+			report.addFailure(rec.Seq, lc.Id, reason)
+			continue
+		}
+		newId := newIds[lc.Id]
+		if _, ok := existing[lc.Id]; ok {
+			node, found, er := i.findImported(ctx, newId)
+			if er != nil {
+				return er
+			}
+			if found {
+				i.nodes[lc.Id] = node
+				report.Skipped++
+				// 上次插入之后可能还没来得及删缓存就中断了
+				if !dryRun {
+					touched[bizKey{biz: node.biz, bizId: node.bizId}] = struct{}{}
+				}
+				continue
+			}
+		}
+		c, reason, er := i.toEntity(ctx, lc, newId)
+		if er != nil {
+			return er
+		}
+		if reason != "" {
+			report.Orphaned++
+			report.addFailure(rec.Seq, lc.Id, reason)
+			continue
+		}
+		if !dryRun {
+			touched[bizKey{biz: c.Biz, bizId: c.BizId}] = struct{}{}
+			_, er = i.dao.InsertWithTime(ctx, c)
+			switch {
+			case errors.Is(er, dao.ErrDuplicatedKey):
+				report.Skipped++
+				i.nodes[lc.Id] = toImportedNode(c)
+				continue
+			case errors.Is(er, dao.ErrForeignKeyViolated):
+				// 父评论在导入之后被删掉了
+				report.Orphaned++
+				report.addFailure(rec.Seq, lc.Id, "父评论已经不存在")
+				continue
+			case er != nil:
+				return er
+			}
+		}
+		i.nodes[lc.Id] = toImportedNode(c)
+		report.Imported++
+	}
+	// 删不掉的话不写断点，重新执行的时候这一批会再删一次
+	for key := range touched {
+		err = i.cache.DelBizCommentCount(ctx, key.biz, key.bizId)
+		if err != nil {
+			return fmt.Errorf("删除评论数缓存失败 biz %d bizId %d: %w", key.biz, key.bizId, err)
+		}
+	}
+	return nil
+}
+
+// allocateIds 按照老评论的创建时间生成新的 id，这样导入的评论按照 id 排序依旧是按照时间排序。
+// 同一毫秒可能有多条评论，序列号从老 id 的低位开始试，撞上了就往后挪一个，
+// 映射表的 new_id 上有唯一索引，保存之后再查一遍就知道哪些撞上了。
+// 早于 id 起始时间的生成不了 id，放在 rejected 里面返回原因
+func (i *LegacyImporter) allocateIds(ctx context.Context, batch []legacyRecord,
+	existing map[int64]int64, dryRun bool) (map[int64]int64, map[int64]string, error) {
+	newIds := make(map[int64]int64, len(batch))
+	rejected := make(map[int64]string)
+	var pending []LegacyComment
+	for _, rec := range batch {
+		if rec.Err != nil {
+			continue
+		}
+		oldId := rec.Comment.Id
+		if newId, ok := existing[oldId]; ok {
+			newIds[oldId] = newId
+			continue
+		}
+		if _, ok := newIds[oldId]; ok {
+			continue
+		}
+		newIds[oldId] = 0
+		pending = append(pending, rec.Comment)
+	}
+	now := time.Now().UnixMilli()
+	for attempt := int64(0); len(pending) > 0; attempt++ {
+		if attempt > maxBackfillAttempts {
+			return nil, nil, fmt.Errorf("%d 条评论分配 id 一直冲突", len(pending))
+		}
+		// 这一轮用掉的 id，避免同一批里面先自己撞上
+		used := make(map[int64]struct{}, len(pending))
+		mappings := make([]dao.LegacyIdMapping, 0, len(pending))
+		for _, lc := range pending {
+			newId, err := idgen.Backfill(lc.Ctime, lc.Id+attempt)
+			if errors.Is(err, idgen.ErrBeforeEpoch) {
+				delete(newIds, lc.Id)
+				rejected[lc.Id] = "创建时间早于 2010-01-01，生成不了按时间排序的 id"
+				continue
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			if _, ok := used[newId]; ok {
+				continue
+			}
+			used[newId] = struct{}{}
+			mappings = append(mappings, dao.LegacyIdMapping{OldId: lc.Id, NewId: newId, Ctime: now})
+		}
+		// 演练的时候不写映射，只保证这一批里面不重复
+		if dryRun {
+			for _, m := range mappings {
+				newIds[m.OldId] = m.NewId
+			}
+		} else {
+			// 映射要先于评论落库，这样中断之后重新执行能找到已经插入的评论，不会重复插入
+			err := i.mapping.Save(ctx, mappings)
+			if err != nil {
+				return nil, nil, err
+			}
+			saved, err := i.mapping.FindNewIds(ctx, legacyIds(pending))
+			if err != nil {
+				return nil, nil, err
+			}
+			for oldId, newId := range saved {
+				newIds[oldId] = newId
+			}
+		}
+		pending = slices.DeleteFunc(pending, func(lc LegacyComment) bool {
+			_, ok := rejected[lc.Id]
+			return ok || newIds[lc.Id] != 0
+		})
+	}
+	return newIds, rejected, nil
+}
+
+func legacyIds(comments []LegacyComment) []int64 {
+	res := make([]int64, 0, len(comments))
+	for _, lc := range comments {
+		res = append(res, lc.Id)
+	}
+	return res
+}
+
+// toEntity 转换成新的评论，父评论或者根评论找不到的时候返回原因
+func (i *LegacyImporter) toEntity(ctx context.Context, lc LegacyComment, newId int64) (dao.Comment, string, error) {
+	c := dao.Comment{
+		Id:         newId,
+		Uid:        lc.Uid,
+		Biz:        int32(lc.Biz),
+		BizId:      lc.BizId,
+		ReplyToUid: lc.ReplyToUid,
+		Content:    lc.Content,
+		Ctime:      lc.Ctime,
+		Utime:      lc.Utime,
+	}
+	var parent importedNode
+	if lc.Pid != 0 {
+		node, ok, err := i.lookup(ctx, lc.Pid)
+		if err != nil || !ok {
+			return c, fmt.Sprintf("父评论 %d 没有导入", lc.Pid), err
+		}
+		if node.biz != c.Biz || node.bizId != c.BizId {
+			return c, fmt.Sprintf("父评论 %d 不属于同一个 biz", lc.Pid), nil
+		}
+		parent = node
+		c.PID = sql.NullInt64{Int64: node.id, Valid: true}
+		if c.ReplyToUid == 0 {
+			c.ReplyToUid = node.uid
+		}
+	}
+	switch {
+	case lc.RootId != 0 && lc.RootId != lc.Id:
+		node, ok, err := i.lookup(ctx, lc.RootId)
+		if err != nil || !ok {
+			return c, fmt.Sprintf("根评论 %d 没有导入", lc.RootId), err
+		}
+		c.RootID = sql.NullInt64{Int64: node.id, Valid: true}
+	case c.PID.Valid:
+		// 老数据没有根评论的，沿着父评论找
+		rootId := parent.rootId
+		if rootId == 0 {
+			rootId = parent.id
+		}
+		c.RootID = sql.NullInt64{Int64: rootId, Valid: true}
+	}
+	return c, "", nil
+}
+
+// lookup 先找这一次导入的，找不到再按照映射去数据库里面找之前导入的
+func (i *LegacyImporter) lookup(ctx context.Context, oldId int64) (importedNode, bool, error) {
+	if node, ok := i.nodes[oldId]; ok {
+		return node, true, nil
+	}
+	ids, err := i.mapping.FindNewIds(ctx, []int64{oldId})
+	if err != nil {
+		return importedNode{}, false, err
+	}
+	newId, ok := ids[oldId]
+	if !ok {
+		return importedNode{}, false, nil
+	}
+	node, found, err := i.findImported(ctx, newId)
+	if found {
+		i.nodes[oldId] = node
+	}
+	return node, found, err
+}
+
+func (i *LegacyImporter) findImported(ctx context.Context, newId int64) (importedNode, bool, error) {
+	c, err := i.dao.FindById(ctx, newId)
+	if errors.Is(err, dao.ErrRecordNotFound) {
+		return importedNode{}, false, nil
+	}
+	if err != nil {
+		return importedNode{}, false, err
+	}
+	return toImportedNode(c), true, nil
+}
+
+func toImportedNode(c dao.Comment) importedNode {
+	return importedNode{
+		id:     c.Id,
+		rootId: c.RootID.Int64,
+		uid:    c.Uid,
+		biz:    c.Biz,
+		bizId:  c.BizId,
+	}
+}
+
+func (r *ImportReport) addFailure(seq int64, oldId int64, reason string) {
+	if len(r.Failures) >= maxReportedFailures {
+		return
+	}
+	r.Failures = append(r.Failures, ImportFailure{Seq: seq, OldId: oldId, Reason: reason})
+}
+
+// loadCheckpoint 断点文件不存在或者演练的时候从头开始
+func (i *LegacyImporter) loadCheckpoint(opts ImportOptions) (ImportReport, error) {
+	report := ImportReport{
+		File:      opts.File,
+		DryRun:    opts.DryRun,
+		StartedAt: time.Now(),
+	}
+	if opts.DryRun || opts.CheckpointFile == "" {
+		return report, nil
+	}
+	data, err := os.ReadFile(opts.CheckpointFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return report, nil
+	}
+	if err != nil {
+		return report, err
+	}
+	var saved ImportReport
+	err = json.Unmarshal(data, &saved)
+	if err != nil {
+		return report, fmt.Errorf("解析断点文件失败: %w", err)
+	}
+	if saved.File != opts.File {
+		return report, fmt.Errorf("断点文件对应的是 %s，不是 %s", saved.File, opts.File)
+	}
+	return saved, nil
+}
+
+// saveCheckpoint 先写临时文件再重命名，避免中途崩溃留下写了一半的断点
+func (i *LegacyImporter) saveCheckpoint(opts ImportOptions, report *ImportReport) error {
+	report.UpdatedAt = time.Now()
+	if opts.DryRun || opts.CheckpointFile == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := opts.CheckpointFile + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, opts.CheckpointFile)
+}
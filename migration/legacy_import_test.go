@@ -0,0 +1,353 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/MuxiKeStack/be-comment/repository/cache"
+	"github.com/MuxiKeStack/be-comment/repository/dao"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errInterrupted = errors.New("导入到一半中断了")
+
+// memoryCommentDAO 导入只用到 InsertWithTime 和 FindById
+type memoryCommentDAO struct {
+	dao.CommentDAO
+	comments map[int64]dao.Comment
+	// 第 failAt 次插入的时候返回错误，模拟导入到一半中断，0 表示不中断
+	failAt  int
+	inserts int
+}
+
+func newMemoryCommentDAO() *memoryCommentDAO {
+	return &memoryCommentDAO{comments: make(map[int64]dao.Comment)}
+}
+
+func (d *memoryCommentDAO) InsertWithTime(ctx context.Context, c dao.Comment) (int64, error) {
+	d.inserts++
+	if d.inserts == d.failAt {
+		return 0, errInterrupted
+	}
+	if _, ok := d.comments[c.Id]; ok {
+		return 0, dao.ErrDuplicatedKey
+	}
+	if c.PID.Valid {
+		if _, ok := d.comments[c.PID.Int64]; !ok {
+			return 0, dao.ErrForeignKeyViolated
+		}
+	}
+	d.comments[c.Id] = c
+	return c.Id, nil
+}
+
+func (d *memoryCommentDAO) FindById(ctx context.Context, id int64) (dao.Comment, error) {
+	c, ok := d.comments[id]
+	if !ok {
+		return dao.Comment{}, dao.ErrRecordNotFound
+	}
+	return c, nil
+}
+
+// memoryMappingDAO old_id 和 new_id 都是唯一的，冲突的时候什么都不做
+type memoryMappingDAO struct {
+	byOld map[int64]int64
+	byNew map[int64]int64
+}
+
+func newMemoryMappingDAO() *memoryMappingDAO {
+	return &memoryMappingDAO{byOld: make(map[int64]int64), byNew: make(map[int64]int64)}
+}
+
+func (d *memoryMappingDAO) FindNewIds(ctx context.Context, oldIds []int64) (map[int64]int64, error) {
+	res := make(map[int64]int64, len(oldIds))
+	for _, oldId := range oldIds {
+		if newId, ok := d.byOld[oldId]; ok {
+			res[oldId] = newId
+		}
+	}
+	return res, nil
+}
+
+func (d *memoryMappingDAO) Save(ctx context.Context, mappings []dao.LegacyIdMapping) error {
+	for _, m := range mappings {
+		_, oldTaken := d.byOld[m.OldId]
+		_, newTaken := d.byNew[m.NewId]
+		if oldTaken || newTaken {
+			continue
+		}
+		d.byOld[m.OldId] = m.NewId
+		d.byNew[m.NewId] = m.OldId
+	}
+	return nil
+}
+
+// recordingCountCache 记录删掉了哪些评论数缓存
+type recordingCountCache struct {
+	cache.CommentCache
+	deleted map[bizKey]int
+	err     error
+}
+
+func newRecordingCountCache() *recordingCountCache {
+	return &recordingCountCache{deleted: make(map[bizKey]int)}
+}
+
+func (c *recordingCountCache) DelBizCommentCount(ctx context.Context, biz int32, bizId int64) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.deleted[bizKey{biz: biz, bizId: bizId}]++
+	return nil
+}
+
+// legacyLine 生成一行 ndjson，ctime 是 2024-03-01 之后的第几毫秒
+func legacyLine(id, uid, bizId, pid int64, ctime int64) string {
+	base := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	return fmt.Sprintf(`{"id":%d,"uid":%d,"biz":1,"biz_id":%d,"pid":%d,"content":"评论%d","ctime":%d}`,
+		id, uid, bizId, pid, id, base+ctime)
+}
+
+func writeLegacyFile(t *testing.T, lines ...string) string {
+	file := filepath.Join(t.TempDir(), "comments.ndjson")
+	require.NoError(t, os.WriteFile(file, []byte(strings.Join(lines, "\n")), 0644))
+	return file
+}
+
+func TestLegacyImporter_Import(t *testing.T) {
+	testCases := []struct {
+		name  string
+		lines []string
+
+		wantImported int64
+		wantInvalid  int64
+		wantOrphaned int64
+		// 导入之后按照新 id 升序排列的老 id
+		wantOrder []int64
+		// 老 id 到老的父评论 id
+		wantParents     map[int64]int64
+		wantDeletedKeys []bizKey
+	}{
+		{
+			name: "按照创建时间分配 id",
+			lines: []string{
+				legacyLine(1, 100, 10, 0, 3000),
+				legacyLine(2, 101, 10, 1, 1000),
+				legacyLine(3, 102, 20, 0, 2000),
+				legacyLine(4, 103, 10, 2, 4000),
+			},
+			wantImported: 4,
+			// 老系统的 id 顺序和创建时间不一致的时候以创建时间为准
+			wantOrder:       []int64{2, 3, 1, 4},
+			wantParents:     map[int64]int64{2: 1, 4: 2},
+			wantDeletedKeys: []bizKey{{biz: 1, bizId: 10}, {biz: 1, bizId: 20}},
+		},
+		{
+			name: "同一毫秒的评论",
+			lines: []string{
+				// 老 id 的低 12 位相同，第一次分配的 id 会撞上
+				legacyLine(1, 100, 10, 0, 1000),
+				legacyLine(1+4096, 101, 10, 0, 1000),
+				legacyLine(2, 102, 10, 0, 1000),
+			},
+			wantImported:    3,
+			wantOrder:       []int64{1, 4097, 2},
+			wantDeletedKeys: []bizKey{{biz: 1, bizId: 10}},
+		},
+		{
+			name: "2024 年之前的评论",
+			lines: []string{
+				`{"id":1,"uid":100,"biz":1,"biz_id":10,"content":"老评论","ctime":"2016-06-01 00:00:00"}`,
+				legacyLine(2, 101, 10, 1, 1000),
+				`{"id":3,"uid":102,"biz":1,"biz_id":10,"content":"老评论","ctime":"2023-06-01 00:00:00"}`,
+			},
+			wantImported:    3,
+			wantOrder:       []int64{1, 3, 2},
+			wantParents:     map[int64]int64{2: 1},
+			wantDeletedKeys: []bizKey{{biz: 1, bizId: 10}},
+		},
+		{
+			name: "早于 id 起始时间的评论",
+			lines: []string{
+				`{"id":1,"uid":100,"biz":1,"biz_id":10,"content":"老评论","ctime":"2009-06-01 00:00:00"}`,
+				legacyLine(2, 101, 10, 1, 1000),
+				legacyLine(3, 102, 10, 0, 2000),
+			},
+			wantImported: 1,
+			wantInvalid:  1,
+			// 父评论导入不了，回复也导入不了
+			wantOrphaned:    1,
+			wantOrder:       []int64{3},
+			wantDeletedKeys: []bizKey{{biz: 1, bizId: 10}},
+		},
+		{
+			name: "格式不对的跳过",
+			lines: []string{
+				legacyLine(1, 100, 10, 0, 1000),
+				`{"id":2`,
+				legacyLine(3, 102, 10, 9, 2000),
+			},
+			wantImported:    1,
+			wantInvalid:     1,
+			wantOrphaned:    1,
+			wantOrder:       []int64{1},
+			wantDeletedKeys: []bizKey{{biz: 1, bizId: 10}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			comments, mapping, countCache := newMemoryCommentDAO(), newMemoryMappingDAO(), newRecordingCountCache()
+			importer := NewLegacyImporter(comments, mapping, countCache, logger.NewNopLogger())
+			report, err := importer.Import(context.Background(), ImportOptions{
+				File:      writeLegacyFile(t, tc.lines...),
+				Format:    FormatNDJSON,
+				BatchSize: 2,
+			})
+			require.NoError(t, err)
+			assert.True(t, report.Finished)
+			assert.Equal(t, int64(len(tc.lines)), report.Processed)
+			assert.Equal(t, tc.wantImported, report.Imported)
+			assert.Equal(t, tc.wantInvalid, report.Invalid)
+			assert.Equal(t, tc.wantOrphaned, report.Orphaned)
+
+			for i := 1; i < len(tc.wantOrder); i++ {
+				prev, cur := mapping.byOld[tc.wantOrder[i-1]], mapping.byOld[tc.wantOrder[i]]
+				assert.Less(t, prev, cur)
+			}
+			for _, oldId := range tc.wantOrder {
+				c, ok := comments.comments[mapping.byOld[oldId]]
+				require.True(t, ok, "老评论 %d 没有导入", oldId)
+				if pid, ok := tc.wantParents[oldId]; ok {
+					assert.Equal(t, mapping.byOld[pid], c.PID.Int64)
+				}
+			}
+			assert.Len(t, comments.comments, len(tc.wantOrder))
+			deleted := make([]bizKey, 0, len(countCache.deleted))
+			for key := range countCache.deleted {
+				deleted = append(deleted, key)
+			}
+			assert.ElementsMatch(t, tc.wantDeletedKeys, deleted)
+		})
+	}
+}
+
+func TestLegacyImporter_Resume(t *testing.T) {
+	file := writeLegacyFile(t,
+		legacyLine(1, 100, 10, 0, 1000),
+		legacyLine(2, 101, 10, 1, 2000),
+		legacyLine(3, 102, 30, 0, 3000),
+		// 父评论在第一批里面，续传的时候要靠映射找回来
+		legacyLine(4, 103, 10, 1, 4000),
+		legacyLine(5, 104, 20, 0, 5000),
+	)
+	opts := ImportOptions{
+		File:           file,
+		Format:         FormatNDJSON,
+		BatchSize:      2,
+		CheckpointFile: file + ".checkpoint.json",
+	}
+	comments, mapping, countCache := newMemoryCommentDAO(), newMemoryMappingDAO(), newRecordingCountCache()
+	// 第二批的第二条插入失败
+	comments.failAt = 4
+	report, err := NewLegacyImporter(comments, mapping, countCache, logger.NewNopLogger()).
+		Import(context.Background(), opts)
+	require.ErrorIs(t, err, errInterrupted)
+	assert.Equal(t, int64(2), report.Processed)
+	assert.Len(t, comments.comments, 3)
+	firstIds := map[int64]int64{1: mapping.byOld[1], 2: mapping.byOld[2], 3: mapping.byOld[3]}
+
+	// 重新执行的时候是一个新的进程，内存里面什么都没有
+	comments.failAt = 0
+	report, err = NewLegacyImporter(comments, mapping, countCache, logger.NewNopLogger()).
+		Import(context.Background(), opts)
+	require.NoError(t, err)
+	assert.True(t, report.Finished)
+	assert.Equal(t, int64(5), report.Processed)
+	assert.Equal(t, int64(4), report.Imported)
+	// 第二批重新处理的时候，上次已经插入的那条跳过
+	assert.Equal(t, int64(1), report.Skipped)
+	assert.Len(t, comments.comments, 5)
+	// 已经分配过的 id 不会变
+	for oldId, newId := range firstIds {
+		assert.Equal(t, newId, mapping.byOld[oldId])
+	}
+	assert.Equal(t, mapping.byOld[1], comments.comments[mapping.byOld[4]].PID.Int64)
+	// 中断的那一批插入了但是没有删缓存，重新执行的时候跳过的也要删
+	assert.Equal(t, map[bizKey]int{
+		{biz: 1, bizId: 10}: 2,
+		{biz: 1, bizId: 30}: 1,
+		{biz: 1, bizId: 20}: 1,
+	}, countCache.deleted)
+
+	// 已经完成了的不再导入
+	inserts := comments.inserts
+	report, err = NewLegacyImporter(comments, mapping, countCache, logger.NewNopLogger()).
+		Import(context.Background(), opts)
+	require.NoError(t, err)
+	assert.True(t, report.Finished)
+	assert.Equal(t, inserts, comments.inserts)
+}
+
+func TestLegacyImporter_Checkpoint(t *testing.T) {
+	errRedis := errors.New("redis 挂了")
+	testCases := []struct {
+		name string
+		// 事先写好的断点文件内容
+		checkpoint string
+		cacheErr   error
+
+		wantErr       bool
+		wantProcessed int64
+	}{
+		{
+			name:          "没有断点从头开始",
+			wantProcessed: 2,
+		},
+		{
+			name:          "断点对应别的文件",
+			checkpoint:    `{"file":"other.ndjson","processed":1}`,
+			wantErr:       true,
+			wantProcessed: 0,
+		},
+		{
+			name:       "断点文件坏了",
+			checkpoint: `{"file":`,
+			wantErr:    true,
+		},
+		{
+			name:     "删不掉评论数缓存不推进断点",
+			cacheErr: errRedis,
+			wantErr:  true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			file := writeLegacyFile(t,
+				legacyLine(1, 100, 10, 0, 1000),
+				legacyLine(2, 101, 10, 0, 2000),
+			)
+			checkpoint := file + ".checkpoint.json"
+			if tc.checkpoint != "" {
+				require.NoError(t, os.WriteFile(checkpoint, []byte(tc.checkpoint), 0644))
+			}
+			countCache := newRecordingCountCache()
+			countCache.err = tc.cacheErr
+			importer := NewLegacyImporter(newMemoryCommentDAO(), newMemoryMappingDAO(), countCache, logger.NewNopLogger())
+			report, err := importer.Import(context.Background(), ImportOptions{
+				File:           file,
+				Format:         FormatNDJSON,
+				BatchSize:      2,
+				CheckpointFile: checkpoint,
+			})
+			assert.Equal(t, tc.wantErr, err != nil)
+			assert.Equal(t, tc.wantProcessed, report.Processed)
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package migration
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	commentv1 "github.com/MuxiKeStack/be-api/gen/proto/comment/v1"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+// 老系统导出的时间格式，没有时区的按照本地时间解析
+const legacyTimeLayout = "2006-01-02 15:04:05"
+
+// LegacyComment 老系统导出的一条评论，id 都是老系统里面的 id
+type LegacyComment struct {
+	Id         int64
+	Uid        int64
+	Biz        commentv1.Biz
+	BizId      int64
+	RootId     int64
+	Pid        int64
+	ReplyToUid int64
+	Content    string
+	// 毫秒
+	Ctime int64
+	Utime int64
+}
+
+// legacyRecord 读出来的一条记录，Err 不为 nil 说明这一条格式不对，但是不影响后面的
+type legacyRecord struct {
+	// 第几条记录，从 1 开始，CSV 不算表头
+	Seq     int64
+	Comment LegacyComment
+	Err     error
+}
+
+type legacyReader interface {
+	// Next 读完了返回 io.EOF
+	Next() (legacyRecord, error)
+}
+
+func newLegacyReader(format string, r io.Reader) (legacyReader, error) {
+	switch format {
+	case FormatNDJSON:
+		scanner := bufio.NewScanner(r)
+		// 评论内容可能比较长
+		scanner.Buffer(make([]byte, 0, 1<<20), 16<<20)
+		return &ndjsonReader{scanner: scanner}, nil
+	case FormatCSV:
+		reader := csv.NewReader(r)
+		header, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("读取 CSV 表头失败: %w", err)
+		}
+		for i := range header {
+			header[i] = strings.TrimSpace(header[i])
+		}
+		// 表头之外的行列数不一致的按照格式错误处理，不中断导入
+		reader.FieldsPerRecord = -1
+		return &csvReader{reader: reader, header: header}, nil
+	default:
+		return nil, fmt.Errorf("不支持的格式 %s", format)
+	}
+}
+
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	seq     int64
+}
+
+func (r *ndjsonReader) Next() (legacyRecord, error) {
+	for r.scanner.Scan() {
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		r.seq++
+		rec := legacyRecord{Seq: r.seq}
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		// 老系统导出的 id 可能超过 float64 的精度
+		decoder.UseNumber()
+		var raw map[string]any
+		err := decoder.Decode(&raw)
+		if err != nil {
+			rec.Err = err
+			return rec, nil
+		}
+		fields := make(map[string]string, len(raw))
+		for k, v := range raw {
+			switch val := v.(type) {
+			case nil:
+			case string:
+				fields[k] = val
+			case json.Number:
+				fields[k] = val.String()
+			default:
+				fields[k] = fmt.Sprint(val)
+			}
+		}
+		rec.Comment, rec.Err = parseLegacyComment(fields)
+		return rec, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return legacyRecord{}, err
+	}
+	return legacyRecord{}, io.EOF
+}
+
+type csvReader struct {
+	reader *csv.Reader
+	header []string
+	seq    int64
+}
+
+func (r *csvReader) Next() (legacyRecord, error) {
+	row, err := r.reader.Read()
+	if err == io.EOF {
+		return legacyRecord{}, io.EOF
+	}
+	r.seq++
+	rec := legacyRecord{Seq: r.seq}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		rec.Err = err
+		return rec, nil
+	}
+	if err != nil {
+		return legacyRecord{}, err
+	}
+	if len(row) != len(r.header) {
+		rec.Err = fmt.Errorf("列数 %d 和表头 %d 不一致", len(row), len(r.header))
+		return rec, nil
+	}
+	fields := make(map[string]string, len(row))
+	for i, name := range r.header {
+		fields[name] = row[i]
+	}
+	rec.Comment, rec.Err = parseLegacyComment(fields)
+	return rec, nil
+}
+
+// parseLegacyComment 两种格式用同样的字段名：
+// id, uid, biz, biz_id, root_id, pid, reply_to_uid, content, ctime, utime
+func parseLegacyComment(fields map[string]string) (LegacyComment, error) {
+	var (
+		c   LegacyComment
+		err error
+	)
+	ints := []struct {
+		name     string
+		dst      *int64
+		required bool
+	}{
+		{"id", &c.Id, true},
+		{"uid", &c.Uid, true},
+		{"biz_id", &c.BizId, true},
+		{"root_id", &c.RootId, false},
+		{"pid", &c.Pid, false},
+		{"reply_to_uid", &c.ReplyToUid, false},
+	}
+	for _, f := range ints {
+		val := strings.TrimSpace(fields[f.name])
+		if val == "" {
+			if f.required {
+				return c, fmt.Errorf("缺少字段 %s", f.name)
+			}
+			continue
+		}
+		*f.dst, err = strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return c, fmt.Errorf("字段 %s: %w", f.name, err)
+		}
+	}
+	c.Biz, err = parseLegacyBiz(fields["biz"])
+	if err != nil {
+		return c, err
+	}
+	c.Content = fields["content"]
+	if strings.TrimSpace(c.Content) == "" {
+		return c, errors.New("评论内容为空")
+	}
+	c.Ctime, err = parseLegacyTime(fields["ctime"])
+	if err != nil {
+		return c, fmt.Errorf("字段 ctime: %w", err)
+	}
+	if c.Ctime == 0 {
+		return c, errors.New("缺少字段 ctime")
+	}
+	c.Utime, err = parseLegacyTime(fields["utime"])
+	if err != nil {
+		return c, fmt.Errorf("字段 utime: %w", err)
+	}
+	if c.Utime == 0 {
+		c.Utime = c.Ctime
+	}
+	return c, nil
+}
+
+// parseLegacyBiz 可以是数字，也可以是枚举的名字，比如 Evaluation
+func parseLegacyBiz(val string) (commentv1.Biz, error) {
+	val = strings.TrimSpace(val)
+	if val == "" {
+		return 0, errors.New("缺少字段 biz")
+	}
+	if biz, err := strconv.ParseInt(val, 10, 32); err == nil {
+		if _, ok := commentv1.Biz_name[int32(biz)]; !ok {
+			return 0, fmt.Errorf("未知的 biz %s", val)
+		}
+		return commentv1.Biz(biz), nil
+	}
+	biz, ok := commentv1.Biz_value[val]
+	if !ok {
+		return 0, fmt.Errorf("未知的 biz %s", val)
+	}
+	return commentv1.Biz(biz), nil
+}
+
+// parseLegacyTime 支持秒、毫秒时间戳和 2006-01-02 15:04:05 格式，返回毫秒
+func parseLegacyTime(val string) (int64, error) {
+	val = strings.TrimSpace(val)
+	if val == "" {
+		return 0, nil
+	}
+	if ts, err := strconv.ParseInt(val, 10, 64); err == nil {
+		// 秒级时间戳在 2286 年之前都小于 1e10
+		if ts < 1e10 {
+			return ts * 1000, nil
+		}
+		return ts, nil
+	}
+	t, err := time.ParseInLocation(legacyTimeLayout, val, time.Local)
+	if err != nil {
+		return 0, err
+	}
+	return t.UnixMilli(), nil
+}
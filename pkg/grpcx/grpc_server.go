@@ -71,7 +71,12 @@ func (s *GRPCServer) register(ctx context.Context, port string) error {
 		endpoints.Endpoint{Addr: addr}, clientv3.WithLease(leaseResp.ID))
 }
 
+// Close 先从注册中心摘除，再等正在处理的请求处理完。
+// EtcdClient 还被其他组件使用，由调用方负责关闭
 func (s *GRPCServer) Close() error {
+	if s.cancel == nil {
+		return nil
+	}
 	s.cancel()
 	if s.etcdManager != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
@@ -81,10 +86,6 @@ func (s *GRPCServer) Close() error {
 			return err
 		}
 	}
-	err := s.EtcdClient.Close()
-	if err != nil {
-		return err
-	}
 	s.Server.GracefulStop()
 	return nil
 }
@@ -0,0 +1,22 @@
+package grpcx
+
+import (
+	"context"
+	"github.com/go-kratos/kratos/v2/transport/grpc"
+)
+
+// InternalServer 只在内网监听的服务器，不注册到注册中心，
+// 导出之类的管理接口放在这里，调用方直接连配置的地址
+type InternalServer struct {
+	*grpc.Server
+}
+
+// Serve 启动服务器并且阻塞
+func (s *InternalServer) Serve() error {
+	return s.Server.Start(context.Background())
+}
+
+// Close 停止接收新的请求，等正在处理的请求处理完之后返回
+func (s *InternalServer) Close() error {
+	return s.Server.Stop(context.Background())
+}
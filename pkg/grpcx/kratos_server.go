@@ -7,6 +7,7 @@ import (
 	"github.com/go-kratos/kratos/v2/transport/grpc"
 	etcdv3 "go.etcd.io/etcd/client/v3"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -16,8 +17,10 @@ type KratosServer struct {
 	Weight     int
 	EtcdTTL    time.Duration
 	EtcdClient *etcdv3.Client
-	stop       func() error
 	L          logger.Logger
+	mutex      sync.Mutex
+	stop       func() error
+	done       chan struct{}
 }
 
 // Serve 启动服务器并且阻塞
@@ -33,14 +36,25 @@ func (s *KratosServer) Serve() error {
 		),
 		kratos.Registrar(r),
 	)
-	s.stop = app.Stop
+	done := make(chan struct{})
+	defer close(done)
+	s.mutex.Lock()
+	s.stop, s.done = app.Stop, done
+	s.mutex.Unlock()
 	return app.Run()
 }
 
+// Close 先从注册中心摘除，再停止接收新的请求，等正在处理的请求处理完之后返回。
+// kratos 自己收到信号的时候也会停止，这里重复调用是安全的。
+// EtcdClient 还被其他组件使用，由调用方负责关闭
 func (s *KratosServer) Close() error {
-	err := s.EtcdClient.Close()
-	if err != nil {
-		return err
+	s.mutex.Lock()
+	stop, done := s.stop, s.done
+	s.mutex.Unlock()
+	if stop == nil {
+		return nil
 	}
-	return s.stop()
+	err := stop()
+	<-done
+	return err
 }
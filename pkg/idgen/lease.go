@@ -0,0 +1,151 @@
+package idgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrNoFreeNode = errors.New("没有空闲的节点号")
+	// ErrNodeLeaseExpired 节点号的租约没能及时续期，节点号可能已经被别的实例拿走了，不能再生成 id
+	ErrNodeLeaseExpired = errors.New("节点号租约已过期")
+)
+
+// 只有持有者才能续期和释放
+var (
+	renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// NodeLease 每个节点号在 redis 里面对应一个 key，SET NX 成功了这个节点号就归自己，
+// key 带过期时间并且定时续期，实例退出的时候释放，实例挂掉的话等过期之后别的实例才能复用。
+// 续期失败超过 ttl 之后认为租约已经失效，这时候别的实例可能已经拿到了同一个节点号
+type NodeLease struct {
+	client redis.Cmdable
+	prefix string
+	node   int64
+	owner  string
+	ttl    time.Duration
+	// 租约确定有效的截止时间，unix 毫秒
+	deadline atomic.Int64
+	l        logger.Logger
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// AcquireNodeLease 从随机的位置开始依次尝试每个节点号，拿到第一个空闲的
+func AcquireNodeLease(ctx context.Context, client redis.Cmdable, prefix string,
+	ttl time.Duration, l logger.Logger) (*NodeLease, error) {
+	lease := &NodeLease{
+		client: client,
+		prefix: prefix,
+		owner:  uuid.NewString(),
+		ttl:    ttl,
+		l:      l,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	// BackfillNode 不参与分配
+	start := rand.Int64N(BackfillNode)
+	for i := int64(0); i < BackfillNode; i++ {
+		node := (start + i) % BackfillNode
+		now := time.Now()
+		ok, err := client.SetNX(ctx, lease.key(node), lease.owner, ttl).Result()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			lease.node = node
+			lease.extend(now)
+			go lease.keepAlive()
+			return lease, nil
+		}
+	}
+	return nil, ErrNoFreeNode
+}
+
+func (lease *NodeLease) Node() int64 {
+	return lease.node
+}
+
+// Valid 按照最后一次续期成功的时间判断，留出一秒的余量
+func (lease *NodeLease) Valid() bool {
+	return time.Now().UnixMilli() < lease.deadline.Load()-time.Second.Milliseconds()
+}
+
+// Close 停止续期并释放节点号
+func (lease *NodeLease) Close() error {
+	close(lease.stop)
+	<-lease.done
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	return releaseScript.Run(ctx, lease.client, []string{lease.key(lease.node)}, lease.owner).Err()
+}
+
+func (lease *NodeLease) keepAlive() {
+	defer close(lease.done)
+	ticker := time.NewTicker(lease.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lease.stop:
+			return
+		case <-ticker.C:
+			err := lease.renew()
+			if err != nil {
+				lease.l.Error("节点号续期失败",
+					logger.Error(err),
+					logger.Int64("node", lease.node))
+			}
+		}
+	}
+}
+
+// renew key 还是自己的就续期，已经过期了就重新抢，被别人拿走了只能等别人释放
+func (lease *NodeLease) renew() error {
+	ctx, cancel := context.WithTimeout(context.Background(), lease.ttl/3)
+	defer cancel()
+	now := time.Now()
+	key := lease.key(lease.node)
+	res, err := renewScript.Run(ctx, lease.client, []string{key}, lease.owner, lease.ttl.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if res == 1 {
+		lease.extend(now)
+		return nil
+	}
+	ok, err := lease.client.SetNX(ctx, key, lease.owner, lease.ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("节点号 %d 已经被别的实例占用", lease.node)
+	}
+	lease.extend(now)
+	return nil
+}
+
+// extend 以发出请求之前的时间为准，redis 里面的 key 一定比这个时间晚过期
+func (lease *NodeLease) extend(now time.Time) {
+	lease.deadline.Store(now.Add(lease.ttl).UnixMilli())
+}
+
+func (lease *NodeLease) key(node int64) string {
+	return fmt.Sprintf("%s:%d", lease.prefix, node)
+}
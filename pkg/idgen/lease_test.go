@@ -0,0 +1,168 @@
+package idgen
+
+import (
+	"context"
+	"fmt"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+const testPrefix = "test:idgen_node"
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, redis.Cmdable) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return mr, client
+}
+
+func TestAcquireNodeLease(t *testing.T) {
+	testCases := []struct {
+		name string
+		// 事先被占用的节点号
+		taken   []int64
+		wantErr error
+		// 拿到的节点号只能是这些
+		wantNodes []int64
+	}{
+		{
+			name: "随便拿一个",
+		},
+		{
+			name: "只剩一个空闲的",
+			taken: func() []int64 {
+				var res []int64
+				for i := int64(0); i <= maxNode; i++ {
+					if i != 7 {
+						res = append(res, i)
+					}
+				}
+				return res
+			}(),
+			wantNodes: []int64{7},
+		},
+		{
+			name: "全部被占用",
+			taken: func() []int64 {
+				var res []int64
+				for i := int64(0); i <= maxNode; i++ {
+					res = append(res, i)
+				}
+				return res
+			}(),
+			wantErr: ErrNoFreeNode,
+		},
+		{
+			name: "只剩留给导入的节点号",
+			taken: func() []int64 {
+				var res []int64
+				for i := int64(0); i < BackfillNode; i++ {
+					res = append(res, i)
+				}
+				return res
+			}(),
+			wantErr: ErrNoFreeNode,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mr, client := newTestRedis(t)
+			for _, node := range tc.taken {
+				require.NoError(t, mr.Set(fmt.Sprintf("%s:%d", testPrefix, node), "other"))
+			}
+			lease, err := AcquireNodeLease(context.Background(), client, testPrefix, time.Second*30, logger.NewNopLogger())
+			assert.ErrorIs(t, err, tc.wantErr)
+			if err != nil {
+				return
+			}
+			defer lease.Close()
+			assert.True(t, lease.Valid())
+			if tc.wantNodes != nil {
+				assert.Contains(t, tc.wantNodes, lease.Node())
+			}
+			owner, err := mr.Get(lease.key(lease.Node()))
+			require.NoError(t, err)
+			assert.Equal(t, lease.owner, owner)
+			assert.Equal(t, time.Second*30, mr.TTL(lease.key(lease.Node())))
+		})
+	}
+}
+
+func TestNodeLease_DistinctNodes(t *testing.T) {
+	_, client := newTestRedis(t)
+	seen := make(map[int64]struct{})
+	for i := 0; i < 50; i++ {
+		lease, err := AcquireNodeLease(context.Background(), client, testPrefix, time.Second*30, logger.NewNopLogger())
+		require.NoError(t, err)
+		_, dup := seen[lease.Node()]
+		require.False(t, dup, "节点号 %d 被重复分配", lease.Node())
+		seen[lease.Node()] = struct{}{}
+	}
+}
+
+func TestNodeLease_Close(t *testing.T) {
+	mr, client := newTestRedis(t)
+	lease, err := AcquireNodeLease(context.Background(), client, testPrefix, time.Second*30, logger.NewNopLogger())
+	require.NoError(t, err)
+	require.NoError(t, lease.Close())
+	assert.False(t, mr.Exists(lease.key(lease.Node())))
+}
+
+func TestNodeLease_Renew(t *testing.T) {
+	testCases := []struct {
+		name string
+		// 续期之前 redis 里面的状态
+		before     func(mr *miniredis.Miniredis, key string)
+		wantErr    bool
+		wantOwner  string
+		wantExtend bool
+	}{
+		{
+			name:       "还是自己的",
+			before:     func(mr *miniredis.Miniredis, key string) {},
+			wantExtend: true,
+		},
+		{
+			name: "过期了重新抢到",
+			before: func(mr *miniredis.Miniredis, key string) {
+				mr.Del(key)
+			},
+			wantExtend: true,
+		},
+		{
+			name: "被别的实例拿走了",
+			before: func(mr *miniredis.Miniredis, key string) {
+				require.NoError(t, mr.Set(key, "other"))
+			},
+			wantErr:   true,
+			wantOwner: "other",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mr, client := newTestRedis(t)
+			lease, err := AcquireNodeLease(context.Background(), client, testPrefix, time.Second*30, logger.NewNopLogger())
+			require.NoError(t, err)
+			key := lease.key(lease.Node())
+			// 假装很久没有续期了
+			lease.deadline.Store(time.Now().Add(-time.Second).UnixMilli())
+			tc.before(mr, key)
+
+			err = lease.renew()
+			assert.Equal(t, tc.wantErr, err != nil)
+			assert.Equal(t, tc.wantExtend, lease.Valid())
+			owner, _ := mr.Get(key)
+			if tc.wantOwner == "" {
+				tc.wantOwner = lease.owner
+			}
+			assert.Equal(t, tc.wantOwner, owner)
+			close(lease.stop)
+			<-lease.done
+		})
+	}
+}
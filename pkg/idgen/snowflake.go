@@ -0,0 +1,83 @@
+package idgen
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	nodeBits = 10
+	seqBits  = 12
+	maxNode  = 1<<nodeBits - 1
+	maxSeq   = 1<<seqBits - 1
+	// 2010-01-01 00:00:00 UTC，早于老系统最早的评论，导入的历史数据也能生成 id。
+	// 41 位毫秒能用到 2079 年
+	epoch int64 = 1262304000000
+	// BackfillNode 留给导入历史数据用，租约不会分配这个节点号，
+	// 所以按照历史时间生成的 id 不会和线上生成的撞上
+	BackfillNode = maxNode
+)
+
+var ErrBeforeEpoch = errors.New("时间早于 id 的起始时间 2010-01-01")
+
+type Generator interface {
+	// Next 节点号的租约失效之后返回 ErrNodeLeaseExpired
+	Next() (int64, error)
+}
+
+// Snowflake 41 位毫秒时间戳 + 10 位节点 + 12 位序列号，生成的 id 随时间递增
+type Snowflake struct {
+	mutex  sync.Mutex
+	node   int64
+	lastMs int64
+	seq    int64
+	// 节点号的租约，为 nil 的时候节点号是固定的
+	lease *NodeLease
+}
+
+func NewSnowflake(node int64) *Snowflake {
+	return &Snowflake{node: node & maxNode}
+}
+
+// NewLeasedSnowflake 节点号来自租约，租约失效之后不再生成 id，避免和拿到同一个节点号的实例重复
+func NewLeasedSnowflake(lease *NodeLease) *Snowflake {
+	return &Snowflake{node: lease.Node(), lease: lease}
+}
+
+func (s *Snowflake) Next() (int64, error) {
+	if s.lease != nil && !s.lease.Valid() {
+		return 0, ErrNodeLeaseExpired
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now().UnixMilli()
+	// 时钟回拨的时候继续用上一次的时间，靠序列号往后走
+	if now < s.lastMs {
+		now = s.lastMs
+	}
+	if now == s.lastMs {
+		s.seq = (s.seq + 1) & maxSeq
+		if s.seq == 0 {
+			// 这一毫秒的序列号用完了，等到下一毫秒
+			for now <= s.lastMs {
+				time.Sleep(time.Millisecond)
+				now = time.Now().UnixMilli()
+			}
+		}
+	} else {
+		s.seq = 0
+	}
+	s.lastMs = now
+	return (now-epoch)<<(nodeBits+seqBits) | s.node<<seqBits | s.seq, nil
+}
+
+// Backfill 用指定的毫秒时间戳生成 BackfillNode 上的 id，导入历史数据的时候用，
+// 这样导入的评论和线上的评论按照 id 排序依旧是按照创建时间排序。
+// 同一毫秒的 seq 由调用方保证不重复，只取低 12 位
+func Backfill(ms int64, seq int64) (int64, error) {
+	if ms < epoch {
+		return 0, ErrBeforeEpoch
+	}
+	return (ms-epoch)<<(nodeBits+seqBits) | BackfillNode<<seqBits | seq&maxSeq, nil
+}
@@ -0,0 +1,115 @@
+package idgen
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnowflake_Next(t *testing.T) {
+	s := NewSnowflake(3)
+	const n = 10000
+	var (
+		mutex sync.Mutex
+		ids   = make(map[int64]struct{}, n)
+		wg    sync.WaitGroup
+	)
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var last int64
+			for i := 0; i < n/4; i++ {
+				id, err := s.Next()
+				require.NoError(t, err)
+				// 同一个协程里面拿到的 id 是递增的
+				assert.Greater(t, id, last)
+				last = id
+				assert.Equal(t, int64(3), id>>seqBits&maxNode)
+				mutex.Lock()
+				ids[id] = struct{}{}
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, ids, n)
+}
+
+func TestSnowflake_LeaseExpired(t *testing.T) {
+	_, client := newTestRedis(t)
+	lease, err := AcquireNodeLease(context.Background(), client, testPrefix, time.Second*30, logger.NewNopLogger())
+	require.NoError(t, err)
+	defer lease.Close()
+	s := NewLeasedSnowflake(lease)
+	id, err := s.Next()
+	require.NoError(t, err)
+	assert.Equal(t, lease.Node(), id>>seqBits&maxNode)
+
+	lease.deadline.Store(time.Now().UnixMilli())
+	_, err = s.Next()
+	assert.ErrorIs(t, err, ErrNodeLeaseExpired)
+}
+
+func TestBackfill(t *testing.T) {
+	ms := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC).UnixMilli()
+	testCases := []struct {
+		name    string
+		ms      int64
+		seq     int64
+		wantErr error
+		wantSeq int64
+	}{
+		{
+			name:    "正常生成",
+			ms:      ms,
+			seq:     5,
+			wantSeq: 5,
+		},
+		{
+			name:    "序列号只取低位",
+			ms:      ms,
+			seq:     maxSeq + 3,
+			wantSeq: 2,
+		},
+		{
+			name:    "2024 年之前的历史数据",
+			ms:      time.Date(2015, 9, 1, 8, 0, 0, 0, time.UTC).UnixMilli(),
+			seq:     1,
+			wantSeq: 1,
+		},
+		{
+			name:    "早于起始时间",
+			ms:      epoch - 1,
+			wantErr: ErrBeforeEpoch,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			id, err := Backfill(tc.ms, tc.seq)
+			assert.ErrorIs(t, err, tc.wantErr)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tc.ms-epoch, id>>(nodeBits+seqBits))
+			assert.Equal(t, int64(BackfillNode), id>>seqBits&maxNode)
+			assert.Equal(t, tc.wantSeq, id&maxSeq)
+		})
+	}
+}
+
+func TestBackfill_Order(t *testing.T) {
+	old, err := Backfill(time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC).UnixMilli(), maxSeq)
+	require.NoError(t, err)
+	later, err := Backfill(time.Date(2024, 3, 1, 8, 0, 0, int(time.Millisecond), time.UTC).UnixMilli(), 0)
+	require.NoError(t, err)
+	now, err := NewSnowflake(maxNode - 1).Next()
+	require.NoError(t, err)
+	// 历史数据的 id 按照时间排在线上数据前面
+	assert.Less(t, old, later)
+	assert.Less(t, later, now)
+}
@@ -0,0 +1,94 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"os"
+	"os/signal"
+	"time"
+)
+
+type hook struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+// Manager 按照注册的顺序依次关闭各个组件，整个过程共用一个截止时间。
+// 先注册的先关闭，所以上游（比如 RPC 服务器）要先注册，被依赖的（比如数据库）要后注册
+type Manager struct {
+	hooks []hook
+	l     logger.Logger
+}
+
+func NewManager(l logger.Logger) *Manager {
+	return &Manager{l: l}
+}
+
+func (m *Manager) Append(name string, stop func(ctx context.Context) error) {
+	m.hooks = append(m.hooks, hook{name: name, stop: stop})
+}
+
+// AppendCloser 注册不接受 ctx 的关闭方法，超时的时候不会等它返回
+func (m *Manager) AppendCloser(name string, closeFn func() error) {
+	m.Append(name, func(ctx context.Context) error {
+		return closeFn()
+	})
+}
+
+// Notify 收到 sigs 中任意一个信号的时候返回的 channel 会被关闭
+func Notify(sigs ...os.Signal) <-chan struct{} {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	done := make(chan struct{})
+	go func() {
+		<-ch
+		signal.Stop(ch)
+		close(done)
+	}()
+	return done
+}
+
+// Shutdown 依次执行关闭逻辑，某一步失败了只记录下来，继续关闭后面的。
+// ctx 到期之后剩下的步骤不再执行，进程退出的时候操作系统会回收连接
+func (m *Manager) Shutdown(ctx context.Context) error {
+	var errs []error
+	for i, h := range m.hooks {
+		if ctx.Err() != nil {
+			skipped := make([]string, 0, len(m.hooks)-i)
+			for _, rest := range m.hooks[i:] {
+				skipped = append(skipped, rest.name)
+			}
+			m.l.Error("关闭超时，跳过剩下的步骤", logger.Any("skipped", skipped))
+			errs = append(errs, ctx.Err())
+			break
+		}
+		start := time.Now()
+		err := m.run(ctx, h)
+		if err != nil {
+			m.l.Error("关闭失败",
+				logger.String("name", h.name),
+				logger.Error(err))
+			errs = append(errs, fmt.Errorf("%s: %w", h.name, err))
+			continue
+		}
+		m.l.Info("关闭成功",
+			logger.String("name", h.name),
+			logger.Int64("costMs", time.Since(start).Milliseconds()))
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Manager) run(ctx context.Context, h hook) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- h.stop(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
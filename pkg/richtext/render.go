@@ -0,0 +1,83 @@
+// Package richtext 把评论内容渲染成可以直接展示的 HTML，
+// Web 和小程序都用服务端渲染的结果，不再各自解析
+package richtext
+
+import (
+	"bytes"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	mdhtml "github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
+	"html"
+	"strings"
+)
+
+// Markdown 的子集：段落、换行、加粗、斜体、删除线、行内代码、代码块、引用、列表和链接。
+// 标题、分割线和原始 HTML 的解析器都不注册，它们会被当成普通文字转义之后展示；
+// 渲染之后再用白名单过滤一遍，图片之类的漏网之鱼也会被去掉，图片请用附件
+var md = goldmark.New(
+	goldmark.WithParser(parser.NewParser(
+		parser.WithBlockParsers(
+			util.Prioritized(parser.NewListParser(), 300),
+			util.Prioritized(parser.NewListItemParser(), 400),
+			util.Prioritized(parser.NewCodeBlockParser(), 500),
+			util.Prioritized(parser.NewFencedCodeBlockParser(), 700),
+			util.Prioritized(parser.NewBlockquoteParser(), 800),
+			util.Prioritized(parser.NewParagraphParser(), 1000),
+		),
+		parser.WithInlineParsers(
+			util.Prioritized(parser.NewCodeSpanParser(), 100),
+			util.Prioritized(parser.NewLinkParser(), 200),
+			util.Prioritized(parser.NewAutoLinkParser(), 300),
+			util.Prioritized(parser.NewEmphasisParser(), 500),
+		),
+		parser.WithParagraphTransformers(parser.DefaultParagraphTransformers()...),
+	)),
+	goldmark.WithExtensions(extension.Strikethrough, extension.Linkify),
+	goldmark.WithRendererOptions(mdhtml.WithHardWraps()),
+)
+
+var policy = newPolicy()
+
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements("p", "br", "strong", "em", "del", "code", "pre", "blockquote", "ul", "ol", "li")
+	p.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowURLSchemes("http", "https")
+	p.RequireParseableURLs(true)
+	p.RequireNoReferrerOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}
+
+// RenderMarkdown 渲染并过滤 Markdown
+func RenderMarkdown(src string) (string, error) {
+	var buf bytes.Buffer
+	err := md.Convert([]byte(src), &buf)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(policy.Sanitize(buf.String())), nil
+}
+
+// RenderPlain 纯文本转义之后按空行分段，段内的换行保留
+func RenderPlain(src string) string {
+	src = strings.ReplaceAll(strings.TrimSpace(src), "\r\n", "\n")
+	if src == "" {
+		return ""
+	}
+	var sb strings.Builder
+	for _, para := range strings.Split(src, "\n\n") {
+		para = strings.TrimSpace(para)
+		if para == "" {
+			continue
+		}
+		sb.WriteString("<p>")
+		sb.WriteString(strings.ReplaceAll(html.EscapeString(para), "\n", "<br>"))
+		sb.WriteString("</p>")
+	}
+	return sb.String()
+}
@@ -0,0 +1,187 @@
+package richtext
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	testCases := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "script 标签被转义",
+			src:  "<script>alert(1)</script>",
+			want: "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>",
+		},
+		{
+			name: "行内 HTML 被转义",
+			src:  `hi <b>bold</b> <span onclick="x()">s</span>`,
+			want: "<p>hi &lt;b&gt;bold&lt;/b&gt; &lt;span onclick=&#34;x()&#34;&gt;s&lt;/span&gt;</p>",
+		},
+		{
+			name: "javascript 链接只留文字",
+			src:  "[x](javascript:alert(1))",
+			want: "<p>x</p>",
+		},
+		{
+			name: "data 链接只留文字",
+			src:  "[x](data:text/html;base64,PHNjcmlwdD4=)",
+			want: "<p>x</p>",
+		},
+		{
+			name: "相对链接只留文字",
+			src:  "[x](/relative)",
+			want: "<p>x</p>",
+		},
+		{
+			name: "外链新窗口打开并且不带 referrer",
+			src:  "[x](https://example.com/a?b=1)",
+			want: `<p><a href="https://example.com/a?b=1" rel="noreferrer noopener" target="_blank">x</a></p>`,
+		},
+		{
+			name: "裸链接自动识别",
+			src:  "see https://example.com",
+			want: `<p>see <a href="https://example.com" rel="noreferrer noopener" target="_blank">https://example.com</a></p>`,
+		},
+		{
+			name: "图片被去掉",
+			src:  "![img](https://example.com/a.png)",
+			want: "<p></p>",
+		},
+		{
+			name: "标题当成普通文字",
+			src:  "# title",
+			want: "<p># title</p>",
+		},
+		{
+			name: "分割线当成普通文字",
+			src:  "---",
+			want: "<p>---</p>",
+		},
+		{
+			name: "行内格式",
+			src:  "**b** *i* ~~d~~ `c`",
+			want: "<p><strong>b</strong> <em>i</em> <del>d</del> <code>c</code></p>",
+		},
+		{
+			name: "换行保留",
+			src:  "a\nb",
+			want: "<p>a<br>\nb</p>",
+		},
+		{
+			name: "引用",
+			src:  "> q",
+			want: "<blockquote>\n<p>q</p>\n</blockquote>",
+		},
+		{
+			name: "有序列表保留起始编号",
+			src:  "3. a\n4. b",
+			want: "<ol start=\"3\">\n<li>a</li>\n<li>b</li>\n</ol>",
+		},
+		{
+			name: "代码块里面的 HTML 被转义",
+			src:  "```\n<script>\n```",
+			want: "<pre><code>&lt;script&gt;\n</code></pre>",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := RenderMarkdown(tc.src)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, res)
+		})
+	}
+}
+
+func TestRenderPlain(t *testing.T) {
+	testCases := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "空内容",
+			src:  "  \n ",
+			want: "",
+		},
+		{
+			name: "HTML 被转义",
+			src:  "<script>alert(1)</script>",
+			want: "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>",
+		},
+		{
+			name: "Markdown 不解析",
+			src:  "**b** [x](https://example.com)",
+			want: "<p>**b** [x](https://example.com)</p>",
+		},
+		{
+			name: "空行分段，段内换行保留",
+			src:  "a\r\nb\n\n\n\nc",
+			want: "<p>a<br>b</p><p>c</p>",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, RenderPlain(tc.src))
+		})
+	}
+}
+
+// TestPolicy 解析器漏掉的 HTML 也要靠白名单挡住
+func TestPolicy(t *testing.T) {
+	testCases := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "script 整个去掉",
+			src:  "<p>a<script>alert(1)</script></p>",
+			want: "<p>a</p>",
+		},
+		{
+			name: "事件属性去掉",
+			src:  `<p onclick="x()">a</p>`,
+			want: "<p>a</p>",
+		},
+		{
+			name: "图片去掉",
+			src:  `<p><img src="https://example.com/a.png" onerror="x()"></p>`,
+			want: "<p></p>",
+		},
+		{
+			name: "不在白名单里面的标签只留内容",
+			src:  "<h1>t</h1><span>s</span>",
+			want: "ts",
+		},
+		{
+			name: "javascript 链接去掉",
+			src:  `<a href="javascript:alert(1)">x</a>`,
+			want: "x",
+		},
+		{
+			name: "data 链接去掉",
+			src:  `<a href="data:text/html;base64,PHNjcmlwdD4=">x</a>`,
+			want: "x",
+		},
+		{
+			name: "链接自带的 target 和 rel 被替换",
+			src:  `<a href="https://example.com" target="_self" rel="opener">x</a>`,
+			want: `<a href="https://example.com" rel="noreferrer noopener" target="_blank">x</a>`,
+		},
+		{
+			name: "ol 的 start 只能是数字",
+			src:  `<ol start="x"><li>a</li></ol>`,
+			want: "<ol><li>a</li></ol>",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, policy.Sanitize(tc.src))
+		})
+	}
+}
@@ -2,22 +2,40 @@ package saramax
 
 import (
 	"context"
-	"encoding/json"
 	"github.com/IBM/sarama"
 	"github.com/MuxiKeStack/be-comment/pkg/logger"
-	"time"
 )
 
 type BatchHandler[T any] struct {
-	l  logger.Logger
-	fn func(msgs []*sarama.ConsumerMessage, t []T) error
+	l logger.Logger
+	// fn 返回和 msgs 一一对应的处理结果，返回 nil 表示全部成功
+	fn   func(msgs []*sarama.ConsumerMessage, t []T) []error
+	opts handlerOptions
 }
 
+// NewBatchHandler fn 返回错误的时候认为整个批次都失败了
 func NewBatchHandler[T any](l logger.Logger,
-	fn func(msgs []*sarama.ConsumerMessage, t []T) error) *BatchHandler[T] {
+	fn func(msgs []*sarama.ConsumerMessage, t []T) error, opts ...Option) *BatchHandler[T] {
+	return NewBatchHandlerWithResults[T](l, func(msgs []*sarama.ConsumerMessage, t []T) []error {
+		err := fn(msgs, t)
+		if err == nil {
+			return nil
+		}
+		errs := make([]error, len(msgs))
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}, opts...)
+}
+
+// NewBatchHandlerWithResults fn 逐条返回处理结果，部分失败的时候只重试失败的那些
+func NewBatchHandlerWithResults[T any](l logger.Logger,
+	fn func(msgs []*sarama.ConsumerMessage, t []T) []error, opts ...Option) *BatchHandler[T] {
 	return &BatchHandler[T]{
-		l:  l,
-		fn: fn,
+		l:    l,
+		fn:   fn,
+		opts: newHandlerOptions(opts),
 	}
 }
 
@@ -25,22 +43,29 @@ func (h *BatchHandler[T]) Setup(session sarama.ConsumerGroupSession) error {
 	return nil
 }
 
+// Cleanup ConsumeClaim 都退出之后、提交 offset 之前调用
 func (h *BatchHandler[T]) Cleanup(session sarama.ConsumerGroupSession) error {
-	return nil
+	if h.opts.onCleanup == nil {
+		return nil
+	}
+	return h.opts.onCleanup(session)
 }
 
-// ConsumeClaim 可以考虑在这个封装里面提供统一的重试机制
+// ConsumeClaim 按照数量、等待时间和字节数凑批，失败的消息按照重试策略重试，
+// 重试之后依旧失败的逐条转发到死信队列再提交
 func (h *BatchHandler[T]) ConsumeClaim(session sarama.ConsumerGroupSession,
 	claim sarama.ConsumerGroupClaim) error {
 	msgsCh := claim.Messages()
-	// 这个可以做成参数
-	const batchSize = 10
 	for {
-		msgs := make([]*sarama.ConsumerMessage, 0, batchSize)
-		ts := make([]T, 0, batchSize)
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-		done := false
-		for i := 0; i < batchSize && !done; i++ {
+		// msgs 里面只放反序列化成功的，和 ts 一一对应
+		msgs := make([]*sarama.ConsumerMessage, 0, h.opts.batchSize)
+		ts := make([]T, 0, h.opts.batchSize)
+		// 反序列化失败的，等这一批处理完了再一起提交，不然前面还没处理的消息会被跟着提交掉
+		var skipped []*sarama.ConsumerMessage
+		var bytes int
+		ctx, cancel := context.WithTimeout(context.Background(), h.opts.batchMaxWait)
+		done, closed := false, false
+		for len(msgs)+len(skipped) < h.opts.batchSize && !done {
 			select {
 			case <-ctx.Done():
 				// 这一批次已经超时了，
@@ -49,13 +74,17 @@ func (h *BatchHandler[T]) ConsumeClaim(session sarama.ConsumerGroupSession,
 				done = true
 			case msg, ok := <-msgsCh:
 				if !ok {
-					cancel()
-					// channel 被关闭了
-					return nil
+					// channel 被关闭了，说明发生了 rebalance 或者消费者关闭了，
+					// 把已经凑到的这一批处理完再退出
+					done, closed = true, true
+					break
+				}
+				bytes += len(msg.Key) + len(msg.Value)
+				if h.opts.batchMaxBytes > 0 && bytes >= h.opts.batchMaxBytes {
+					done = true
 				}
-				msgs = append(msgs, msg)
 				var t T
-				err := json.Unmarshal(msg.Value, &t)
+				err := h.opts.codecs.Decode(msg, &t)
 				if err != nil {
 					// 消息格式都不对，没啥好处理的
 					// 但是也不能直接返回，在线上的时候要继续处理下去
@@ -65,23 +94,112 @@ func (h *BatchHandler[T]) ConsumeClaim(session sarama.ConsumerGroupSession,
 						logger.Int64("offset", msg.Offset),
 						// 这里也可以考虑打印 msg.Value，但是有些时候 msg 本身也包含敏感数据
 						logger.Error(err))
+					if er := giveUp(h.l, h.opts, msg, err, 0); er != nil {
+						cancel()
+						return er
+					}
 					// 不中断，继续下一个
-					session.MarkMessage(msg, "")
+					skipped = append(skipped, msg)
 					continue
 				}
+				msgs = append(msgs, msg)
 				ts = append(ts, t)
 			}
 		}
-		err := h.fn(msgs, ts)
-		if err == nil {
-			// 这边就要都提交了
-			for _, msg := range msgs {
-				session.MarkMessage(msg, "")
+		cancel()
+		if len(msgs) > 0 {
+			err := h.process(session, msgs, ts)
+			if err != nil {
+				return err
+			}
+			if session.Context().Err() != nil {
+				return nil
 			}
-		} else {
-			// 这里可以考虑重试，也可以在具体的业务逻辑里面重试
-			// 也就是 eg.Go 里面重试
 		}
-		cancel()
+		for _, msg := range skipped {
+			session.MarkMessage(msg, "")
+		}
+		if closed {
+			return nil
+		}
+	}
+}
+
+// process 处理一批消息并提交。
+// 部分失败的时候只提交失败消息之前连续成功的那部分，失败的按照重试策略单独重试，
+// 重试之后依旧失败的转发到死信队列，最后再提交整个批次
+func (h *BatchHandler[T]) process(session sarama.ConsumerGroupSession,
+	msgs []*sarama.ConsumerMessage, ts []T) error {
+	// 还没有处理成功的下标
+	pending := make([]int, len(msgs))
+	for i := range pending {
+		pending[i] = i
+	}
+	var (
+		retries int
+		// 不可重试的或者重试次数用完的
+		failed    []int
+		failedErr = make(map[int]error)
+	)
+	for {
+		curMsgs := make([]*sarama.ConsumerMessage, 0, len(pending))
+		curTs := make([]T, 0, len(pending))
+		for _, idx := range pending {
+			curMsgs = append(curMsgs, msgs[idx])
+			curTs = append(curTs, ts[idx])
+		}
+		errs := h.fn(curMsgs, curTs)
+		var next []int
+		for i, idx := range pending {
+			if i >= len(errs) || errs[i] == nil {
+				continue
+			}
+			failedErr[idx] = errs[i]
+			if retries >= h.opts.retry.MaxRetries || !h.opts.retry.retryable(errs[i]) {
+				failed = append(failed, idx)
+				continue
+			}
+			next = append(next, idx)
+		}
+		// 第一个还没有处理成功的消息之前的都可以提交了
+		h.markPrefix(session, msgs, append(failed, next...))
+		if len(next) == 0 {
+			break
+		}
+		if h.opts.retry.wait(session.Context(), retries) != nil {
+			// rebalance 或者消费者关闭了，没有提交的会被重新消费
+			return nil
+		}
+		retries++
+		pending = next
+	}
+	for _, idx := range failed {
+		msg := msgs[idx]
+		h.l.Error("处理消息失败",
+			logger.String("topic", msg.Topic),
+			logger.Int32("partition", msg.Partition),
+			logger.Int64("offset", msg.Offset),
+			logger.Int("retries", retries),
+			logger.Error(failedErr[idx]))
+		err := giveUp(h.l, h.opts, msg, failedErr[idx], retries)
+		if err != nil {
+			return err
+		}
+	}
+	for _, msg := range msgs {
+		session.MarkMessage(msg, "")
+	}
+	return nil
+}
+
+// markPrefix 提交 unresolved 里面最小的下标之前的消息
+func (h *BatchHandler[T]) markPrefix(session sarama.ConsumerGroupSession,
+	msgs []*sarama.ConsumerMessage, unresolved []int) {
+	end := len(msgs)
+	for _, idx := range unresolved {
+		end = min(end, idx)
+	}
+	for _, msg := range msgs[:end] {
+		session.MarkMessage(msg, "")
 	}
 }
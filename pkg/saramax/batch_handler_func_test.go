@@ -0,0 +1,165 @@
+package saramax
+
+import (
+	"errors"
+	"github.com/IBM/sarama"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestBatchHandler_ConsumeClaim(t *testing.T) {
+	errTemporary := errors.New("临时错误")
+	errPermanent := errors.New("永久错误")
+	errDLQ := errors.New("死信队列写不进去")
+	testCases := []struct {
+		name  string
+		keys  []string
+		retry RetryPolicy
+		// 消息 id 第 i 次处理的结果是 errs[id][i]，超出的部分返回 nil
+		errs map[int64][]error
+		// 为 nil 的时候不设置死信队列
+		dlq []dlqExpectation
+
+		// 每一次调用 fn 传进去的消息 id
+		wantCalls [][]int64
+		// 每一次调用 fn 的时候已经提交到的 offset
+		wantCommitted []int64
+		wantMarked    []int64
+		wantErr       error
+	}{
+		{
+			name:          "全部成功",
+			keys:          []string{"a", "b", "c"},
+			wantCalls:     [][]int64{{0, 1, 2}},
+			wantCommitted: []int64{-1},
+			wantMarked:    []int64{0, 1, 2},
+		},
+		{
+			name:  "只重试失败的那条",
+			keys:  []string{"a", "b", "c"},
+			retry: RetryPolicy{MaxRetries: 3, InitialBackoff: time.Millisecond},
+			errs: map[int64][]error{
+				1: {errTemporary},
+			},
+			wantCalls: [][]int64{{0, 1, 2}, {1}},
+			// 重试的时候失败的那条之前的已经提交了，之后的还不能提交
+			wantCommitted: []int64{-1, 0},
+			wantMarked:    []int64{0, 1, 2},
+		},
+		{
+			name:  "重试次数用完转发死信队列",
+			keys:  []string{"a", "b", "c"},
+			retry: RetryPolicy{MaxRetries: 2, InitialBackoff: time.Millisecond},
+			errs: map[int64][]error{
+				1: {errTemporary, errTemporary, errTemporary},
+			},
+			dlq:           []dlqExpectation{{offset: 1, retries: 2}},
+			wantCalls:     [][]int64{{0, 1, 2}, {1}, {1}},
+			wantCommitted: []int64{-1, 0, 0},
+			wantMarked:    []int64{0, 1, 2},
+		},
+		{
+			name:  "NonRetryable 直接转发死信队列",
+			keys:  []string{"a", "b", "c"},
+			retry: RetryPolicy{MaxRetries: 3, InitialBackoff: time.Millisecond},
+			errs: map[int64][]error{
+				0: {NonRetryable(errPermanent)},
+			},
+			dlq:           []dlqExpectation{{offset: 0}},
+			wantCalls:     [][]int64{{0, 1, 2}},
+			wantCommitted: []int64{-1},
+			wantMarked:    []int64{0, 1, 2},
+		},
+		{
+			name: "没有死信队列只记日志",
+			keys: []string{"a", "b"},
+			errs: map[int64][]error{
+				1: {errPermanent},
+			},
+			wantCalls:     [][]int64{{0, 1}},
+			wantCommitted: []int64{-1},
+			wantMarked:    []int64{0, 1},
+		},
+		{
+			name: "死信队列写失败不提交",
+			keys: []string{"a", "b", "c"},
+			errs: map[int64][]error{
+				1: {errPermanent},
+			},
+			dlq:           []dlqExpectation{{offset: 1, err: errDLQ}},
+			wantCalls:     [][]int64{{0, 1, 2}},
+			wantCommitted: []int64{-1},
+			// 失败之前的可以提交
+			wantMarked: []int64{0},
+			wantErr:    errDLQ,
+		},
+		{
+			name:          "反序列化失败的跳过",
+			keys:          []string{"a", "bad", "c"},
+			dlq:           []dlqExpectation{{offset: 1}},
+			wantCalls:     [][]int64{{0, 2}},
+			wantCommitted: []int64{-1},
+			wantMarked:    []int64{0, 1, 2},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			session := newFakeSession()
+			opts := []Option{WithRetry(tc.retry)}
+			if tc.dlq != nil {
+				dlq, producer := newTestDLQ(t, tc.dlq)
+				defer func() {
+					require.NoError(t, producer.Close())
+				}()
+				opts = append(opts, WithDeadLetterQueue(dlq))
+			}
+			var (
+				calls     [][]int64
+				committed []int64
+				attempts  = make(map[int64]int)
+			)
+			h := NewBatchHandlerWithResults[testEvent](logger.NewNopLogger(),
+				func(msgs []*sarama.ConsumerMessage, evts []testEvent) []error {
+					committed = append(committed, session.committed())
+					ids := make([]int64, 0, len(evts))
+					errs := make([]error, len(evts))
+					for i, evt := range evts {
+						ids = append(ids, evt.Id)
+						if n := attempts[evt.Id]; n < len(tc.errs[evt.Id]) {
+							errs[i] = tc.errs[evt.Id][n]
+						}
+						attempts[evt.Id]++
+					}
+					calls = append(calls, ids)
+					return errs
+				}, opts...)
+
+			err := h.ConsumeClaim(session, newFakeClaim(testMessages(tc.keys...)))
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.wantCalls, calls)
+			assert.Equal(t, tc.wantCommitted, committed)
+			assert.Equal(t, tc.wantMarked, session.markedSet())
+		})
+	}
+}
+
+func TestBatchHandler_BatchSize(t *testing.T) {
+	session := newFakeSession()
+	var calls [][]int64
+	h := NewBatchHandler[testEvent](logger.NewNopLogger(),
+		func(msgs []*sarama.ConsumerMessage, evts []testEvent) error {
+			ids := make([]int64, 0, len(evts))
+			for _, evt := range evts {
+				ids = append(ids, evt.Id)
+			}
+			calls = append(calls, ids)
+			return nil
+		}, WithBatchSize(2))
+	err := h.ConsumeClaim(session, newFakeClaim(testMessages("a", "b", "c", "d", "e")))
+	require.NoError(t, err)
+	assert.Equal(t, [][]int64{{0, 1}, {2, 3}, {4}}, calls)
+	assert.Equal(t, []int64{0, 1, 2, 3, 4}, session.markedSet())
+}
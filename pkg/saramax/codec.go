@@ -0,0 +1,128 @@
+package saramax
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/IBM/sarama"
+	"google.golang.org/protobuf/proto"
+	"reflect"
+)
+
+// HeaderContentType 生产者通过这个 header 标明消息体的编码方式，消费者据此选择解码器
+const HeaderContentType = "content-type"
+
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+var ErrUnknownContentType = errors.New("未知的消息编码")
+
+type Codec interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// ProtoCodec 处理 be-api 里面生成的 protobuf 消息
+type ProtoCodec struct{}
+
+func (ProtoCodec) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+func (ProtoCodec) Marshal(v any) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T 不是 protobuf 消息", v)
+	}
+	return proto.Marshal(msg)
+}
+
+// Unmarshal v 可以是 *XxxMessage，也可以是 **XxxMessage（Handler[*XxxMessage] 的情况），
+// 后者为 nil 的时候会自动分配
+func (ProtoCodec) Unmarshal(data []byte, v any) error {
+	if msg, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, msg)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		elem := rv.Elem()
+		if elem.Kind() == reflect.Pointer {
+			if elem.IsNil() {
+				elem.Set(reflect.New(elem.Type().Elem()))
+			}
+			if msg, ok := elem.Interface().(proto.Message); ok {
+				return proto.Unmarshal(data, msg)
+			}
+		}
+	}
+	return fmt.Errorf("%T 不是 protobuf 消息", v)
+}
+
+// Codecs 按照 content-type 选择解码器，没有这个 header 的消息用 fallback 解码，
+// 兼容以前没有带 header 的 JSON 消息
+type Codecs struct {
+	codecs   map[string]Codec
+	fallback Codec
+}
+
+func NewCodecs(fallback Codec, others ...Codec) *Codecs {
+	res := &Codecs{
+		codecs:   make(map[string]Codec, len(others)+1),
+		fallback: fallback,
+	}
+	res.codecs[fallback.ContentType()] = fallback
+	for _, c := range others {
+		res.codecs[c.ContentType()] = c
+	}
+	return res
+}
+
+// DefaultCodecs 默认是 JSON，同时支持 protobuf
+func DefaultCodecs() *Codecs {
+	return NewCodecs(JSONCodec{}, ProtoCodec{})
+}
+
+func (c *Codecs) Decode(msg *sarama.ConsumerMessage, v any) error {
+	codec, err := c.codecFor(msg)
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(msg.Value, v)
+}
+
+func (c *Codecs) codecFor(msg *sarama.ConsumerMessage) (Codec, error) {
+	contentType := HeaderValue(msg, HeaderContentType)
+	if contentType == "" {
+		return c.fallback, nil
+	}
+	codec, ok := c.codecs[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownContentType, contentType)
+	}
+	return codec, nil
+}
+
+// ContentTypeHeader 生产者发送消息的时候带上，标明编码方式
+func ContentTypeHeader(codec Codec) sarama.RecordHeader {
+	return sarama.RecordHeader{
+		Key:   []byte(HeaderContentType),
+		Value: []byte(codec.ContentType()),
+	}
+}
@@ -1,21 +1,22 @@
 package saramax
 
 import (
-	"encoding/json"
 	"github.com/IBM/sarama"
 	"github.com/MuxiKeStack/be-comment/pkg/logger"
 )
 
 type Handler[T any] struct {
-	l  logger.Logger
-	fn func(msg *sarama.ConsumerMessage, t T) error
+	l    logger.Logger
+	fn   func(msg *sarama.ConsumerMessage, t T) error
+	opts handlerOptions
 }
 
 func NewHandler[T any](l logger.Logger,
-	fn func(msg *sarama.ConsumerMessage, t T) error) *Handler[T] {
+	fn func(msg *sarama.ConsumerMessage, t T) error, opts ...Option) *Handler[T] {
 	return &Handler[T]{
-		l:  l,
-		fn: fn,
+		l:    l,
+		fn:   fn,
+		opts: newHandlerOptions(opts),
 	}
 }
 
@@ -27,15 +28,15 @@ func (h *Handler[T]) Cleanup(session sarama.ConsumerGroupSession) error {
 	return nil
 }
 
-// ConsumeClaim 可以考虑在这个封装里面提供统一的重试机制
+// ConsumeClaim 失败的消息按照重试策略重试，重试之后依旧失败的转发到死信队列再提交
 func (h *Handler[T]) ConsumeClaim(session sarama.ConsumerGroupSession,
 	claim sarama.ConsumerGroupClaim) error {
 	msgs := claim.Messages()
 	for msg := range msgs {
 		var t T
-		err := json.Unmarshal(msg.Value, &t)
+		err := h.opts.codecs.Decode(msg, &t)
 		if err != nil {
-			// 消息格式都不对，没啥好处理的
+			// 消息格式都不对，没啥好处理的，也不用重试
 			// 但是也不能直接返回，在线上的时候要继续处理下去
 			h.l.Error("反序列化消息体失败",
 				logger.String("topic", msg.Topic),
@@ -43,19 +44,51 @@ func (h *Handler[T]) ConsumeClaim(session sarama.ConsumerGroupSession,
 				logger.Int64("offset", msg.Offset),
 				// 这里也可以考虑打印 msg.Value，但是有些时候 msg 本身也包含敏感数据
 				logger.Error(err))
+			err = giveUp(h.l, h.opts, msg, err, 0)
+			if err != nil {
+				return err
+			}
 			// 不中断，继续下一个
 			session.MarkMessage(msg, "")
 			continue
 		}
-		err = h.fn(msg, t)
+		retries, err := h.opts.retry.Do(session.Context(), func() error {
+			return h.fn(msg, t)
+		})
 		if err != nil {
+			if session.Context().Err() != nil {
+				// rebalance 或者消费者关闭了，不提交，这条消息会被重新消费
+				return nil
+			}
 			h.l.Error("处理消息失败",
 				logger.String("topic", msg.Topic),
 				logger.Int32("partition", msg.Partition),
 				logger.Int64("offset", msg.Offset),
+				logger.Int("retries", retries),
 				logger.Error(err))
+			err = giveUp(h.l, h.opts, msg, err, retries)
+			if err != nil {
+				return err
+			}
 		}
 		session.MarkMessage(msg, "")
 	}
 	return nil
 }
+
+// giveUp 放弃处理这条消息，有死信队列的话转发过去。
+// 返回错误说明转发失败了，这个时候不能提交，不然消息就丢了
+func giveUp(l logger.Logger, opts handlerOptions, msg *sarama.ConsumerMessage, cause error, retries int) error {
+	if opts.dlq == nil {
+		return nil
+	}
+	err := opts.dlq.Send(msg, cause, retries)
+	if err != nil {
+		l.Error("转发死信队列失败",
+			logger.String("topic", msg.Topic),
+			logger.Int32("partition", msg.Partition),
+			logger.Int64("offset", msg.Offset),
+			logger.Error(err))
+	}
+	return err
+}
@@ -0,0 +1,57 @@
+package saramax
+
+import (
+	"github.com/IBM/sarama"
+	"strconv"
+	"time"
+)
+
+// 转发到死信队列时附加的 header
+const (
+	HeaderDLQError             = "x-dlq-error"
+	HeaderDLQOriginalTopic     = "x-dlq-original-topic"
+	HeaderDLQOriginalPartition = "x-dlq-original-partition"
+	HeaderDLQOriginalOffset    = "x-dlq-original-offset"
+	HeaderDLQRetries           = "x-dlq-retries"
+	HeaderDLQTime              = "x-dlq-time"
+)
+
+// DeadLetterQueue 重试之后依旧处理失败的消息转发到这里，保留原本的 key 和 header
+type DeadLetterQueue struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+func NewDeadLetterQueue(producer sarama.SyncProducer, topic string) *DeadLetterQueue {
+	return &DeadLetterQueue{
+		producer: producer,
+		topic:    topic,
+	}
+}
+
+func (q *DeadLetterQueue) Send(msg *sarama.ConsumerMessage, cause error, retries int) error {
+	headers := make([]sarama.RecordHeader, 0, len(msg.Headers)+6)
+	for _, h := range msg.Headers {
+		if h != nil {
+			headers = append(headers, *h)
+		}
+	}
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(HeaderDLQError), Value: []byte(cause.Error())},
+		sarama.RecordHeader{Key: []byte(HeaderDLQOriginalTopic), Value: []byte(msg.Topic)},
+		sarama.RecordHeader{Key: []byte(HeaderDLQOriginalPartition), Value: []byte(strconv.Itoa(int(msg.Partition)))},
+		sarama.RecordHeader{Key: []byte(HeaderDLQOriginalOffset), Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		sarama.RecordHeader{Key: []byte(HeaderDLQRetries), Value: []byte(strconv.Itoa(retries))},
+		sarama.RecordHeader{Key: []byte(HeaderDLQTime), Value: []byte(strconv.FormatInt(time.Now().UnixMilli(), 10))},
+	)
+	pm := &sarama.ProducerMessage{
+		Topic:   q.topic,
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	}
+	if msg.Key != nil {
+		pm.Key = sarama.ByteEncoder(msg.Key)
+	}
+	_, _, err := q.producer.SendMessage(pm)
+	return err
+}
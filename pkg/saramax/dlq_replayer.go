@@ -0,0 +1,189 @@
+package saramax
+
+import (
+	"context"
+	"errors"
+	"github.com/IBM/sarama"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Replayer 把死信队列里面的消息重新投递回原本的 topic。
+// 使用单独的消费者组消费死信队列，进度会提交，所以多次执行不会重复投递
+type Replayer struct {
+	client   sarama.Client
+	producer sarama.SyncProducer
+	l        logger.Logger
+}
+
+func NewReplayer(client sarama.Client, producer sarama.SyncProducer, l logger.Logger) *Replayer {
+	return &Replayer{
+		client:   client,
+		producer: producer,
+		l:        l,
+	}
+}
+
+// Replay 最多重新投递 limit 条消息，超过 idle 时间没有新消息就认为死信队列已经消费完了，返回投递的条数
+func (r *Replayer) Replay(ctx context.Context, dlqTopic string, limit int64, idle time.Duration) (int64, error) {
+	cg, err := sarama.NewConsumerGroupFromClient(dlqTopic+"_replay", r.client)
+	if err != nil {
+		return 0, err
+	}
+	defer cg.Close()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	h := &replayHandler{
+		topic:  dlqTopic,
+		limit:  limit,
+		idle:   idle,
+		cancel: cancel,
+		replay: r.replay,
+	}
+	for ctx.Err() == nil {
+		er := cg.Consume(ctx, []string{dlqTopic}, h)
+		if er != nil && !errors.Is(er, context.Canceled) {
+			return h.replayed.Load(), er
+		}
+	}
+	return h.replayed.Load(), h.err()
+}
+
+// replayHandler 一个分区空闲了不代表死信队列消费完了，别的分区可能还有消息，
+// 所以要分到的所有分区都空闲了才结束
+type replayHandler struct {
+	topic  string
+	limit  int64
+	idle   time.Duration
+	cancel context.CancelFunc
+	replay func(msg *sarama.ConsumerMessage) error
+
+	replayed atomic.Int64
+	idleness *idleTracker
+
+	errMutex  sync.Mutex
+	replayErr error
+}
+
+func (h *replayHandler) Setup(session sarama.ConsumerGroupSession) error {
+	// 每次 rebalance 分到的分区可能不一样，重新统计
+	h.idleness = newIdleTracker(len(session.Claims()[h.topic]))
+	return nil
+}
+
+func (h *replayHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *replayHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	timer := time.NewTimer(h.idle)
+	defer timer.Stop()
+	for {
+		select {
+		case <-session.Context().Done():
+			return nil
+		case <-timer.C:
+			// 其它分区还在投递的话接着等，这个分区来了新消息就不算空闲了
+			if h.idleness.markIdle(claim.Partition()) {
+				h.cancel()
+				return nil
+			}
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			h.idleness.markBusy(claim.Partition())
+			if h.replayed.Load() >= h.limit {
+				h.cancel()
+				return nil
+			}
+			er := h.replay(msg)
+			if er != nil {
+				h.errMutex.Lock()
+				h.replayErr = er
+				h.errMutex.Unlock()
+				h.cancel()
+				return er
+			}
+			session.MarkMessage(msg, "")
+			h.replayed.Add(1)
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(h.idle)
+		}
+	}
+}
+
+func (h *replayHandler) err() error {
+	h.errMutex.Lock()
+	defer h.errMutex.Unlock()
+	return h.replayErr
+}
+
+// idleTracker 记录哪些分区已经空闲了
+type idleTracker struct {
+	mutex sync.Mutex
+	total int
+	idle  map[int32]struct{}
+}
+
+func newIdleTracker(total int) *idleTracker {
+	return &idleTracker{total: total, idle: make(map[int32]struct{}, total)}
+}
+
+// markIdle 返回是不是所有分区都空闲了
+func (t *idleTracker) markIdle(partition int32) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.idle[partition] = struct{}{}
+	return len(t.idle) >= t.total
+}
+
+func (t *idleTracker) markBusy(partition int32) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.idle, partition)
+}
+
+func (r *Replayer) replay(msg *sarama.ConsumerMessage) error {
+	var topic string
+	headers := make([]sarama.RecordHeader, 0, len(msg.Headers))
+	for _, h := range msg.Headers {
+		if h == nil {
+			continue
+		}
+		key := string(h.Key)
+		if key == HeaderDLQOriginalTopic {
+			topic = string(h.Value)
+		}
+		// 死信队列附加的 header 去掉，只保留原始的
+		if strings.HasPrefix(key, "x-dlq-") {
+			continue
+		}
+		headers = append(headers, *h)
+	}
+	if topic == "" {
+		r.l.Error("死信消息缺少原始 topic，跳过",
+			logger.String("topic", msg.Topic),
+			logger.Int32("partition", msg.Partition),
+			logger.Int64("offset", msg.Offset))
+		return nil
+	}
+	pm := &sarama.ProducerMessage{
+		Topic:   topic,
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	}
+	if msg.Key != nil {
+		pm.Key = sarama.ByteEncoder(msg.Key)
+	}
+	_, _, err := r.producer.SendMessage(pm)
+	return err
+}
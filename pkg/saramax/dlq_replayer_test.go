@@ -0,0 +1,68 @@
+package saramax
+
+import (
+	"context"
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIdleTracker(t *testing.T) {
+	tracker := newIdleTracker(2)
+	assert.False(t, tracker.markIdle(0))
+	// 同一个分区重复空闲不算数
+	assert.False(t, tracker.markIdle(0))
+	tracker.markBusy(0)
+	assert.False(t, tracker.markIdle(1))
+	assert.True(t, tracker.markIdle(0))
+}
+
+func TestReplayHandler_WaitForAllPartitions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := newFakeSession()
+	session.ctx = ctx
+	session.claims = map[string][]int32{"test_dlq": {0, 1}}
+
+	// 分区 1 的消息投递得很慢，卡住的时候分区 0 早就空闲了
+	release := make(chan struct{})
+	h := &replayHandler{
+		topic:  "test_dlq",
+		limit:  10,
+		idle:   time.Millisecond * 20,
+		cancel: cancel,
+		replay: func(msg *sarama.ConsumerMessage) error {
+			<-release
+			return nil
+		},
+	}
+	require.NoError(t, h.Setup(session))
+	empty := &fakeClaim{partition: 0, msgs: make(chan *sarama.ConsumerMessage)}
+	busy := &fakeClaim{partition: 1, msgs: make(chan *sarama.ConsumerMessage, 1)}
+	busy.msgs <- &sarama.ConsumerMessage{Topic: "test_dlq", Partition: 1, Offset: 0}
+
+	var wg sync.WaitGroup
+	for _, claim := range []*fakeClaim{empty, busy} {
+		wg.Add(1)
+		go func(claim *fakeClaim) {
+			defer wg.Done()
+			assert.NoError(t, h.ConsumeClaim(session, claim))
+		}(claim)
+	}
+
+	time.Sleep(time.Millisecond * 100)
+	assert.NoError(t, ctx.Err(), "还有分区在投递，不能结束")
+
+	close(release)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("所有分区都空闲了还没有结束")
+	}
+	wg.Wait()
+	assert.Equal(t, int64(1), h.replayed.Load())
+	assert.Equal(t, []int64{0}, session.markedSet())
+}
@@ -0,0 +1,13 @@
+package saramax
+
+import "github.com/IBM/sarama"
+
+// HeaderValue 取出消息里面第一个名字是 key 的 header，没有的话返回空字符串
+func HeaderValue(msg *sarama.ConsumerMessage, key string) string {
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,228 @@
+package saramax
+
+import (
+	"container/list"
+	"context"
+	"github.com/IBM/sarama"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"hash/fnv"
+	"sync"
+)
+
+// 每个 worker 排队的消息上限，满了之后分发会阻塞，起到背压的作用
+const keyedWorkerQueueSize = 128
+
+// KeyedHandler 把同一个分区的消息按照 key 分发给多个 worker 并行处理，
+// key 相同的消息一定落到同一个 worker 上，所以同一个 key 内部依旧是有序的。
+// 提交的 offset 是已经处理完的消息里面从头开始连续的最高水位，
+// 没处理完的消息之后的 offset 不会被提交，崩溃之后会重新消费，所以 fn 需要是幂等的
+type KeyedHandler[T any] struct {
+	l       logger.Logger
+	workers int
+	// keyFn 为 nil 的时候使用消息的 key
+	keyFn func(msg *sarama.ConsumerMessage, t T) string
+	fn    func(msg *sarama.ConsumerMessage, t T) error
+	opts  handlerOptions
+}
+
+func NewKeyedHandler[T any](l logger.Logger, workers int,
+	keyFn func(msg *sarama.ConsumerMessage, t T) string,
+	fn func(msg *sarama.ConsumerMessage, t T) error, opts ...Option) *KeyedHandler[T] {
+	return &KeyedHandler[T]{
+		l:       l,
+		workers: max(workers, 1),
+		keyFn:   keyFn,
+		fn:      fn,
+		opts:    newHandlerOptions(opts),
+	}
+}
+
+func (h *KeyedHandler[T]) Setup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *KeyedHandler[T]) Cleanup(session sarama.ConsumerGroupSession) error {
+	if h.opts.onCleanup == nil {
+		return nil
+	}
+	return h.opts.onCleanup(session)
+}
+
+type keyedTask[T any] struct {
+	msg *sarama.ConsumerMessage
+	t   T
+}
+
+func (h *KeyedHandler[T]) ConsumeClaim(session sarama.ConsumerGroupSession,
+	claim sarama.ConsumerGroupClaim) error {
+	ctx, cancel := context.WithCancel(session.Context())
+	defer cancel()
+	tracker := newOffsetTracker(session)
+	var (
+		wg       sync.WaitGroup
+		errMutex sync.Mutex
+		fatalErr error
+	)
+	queues := make([]chan keyedTask[T], h.workers)
+	for i := range queues {
+		queue := make(chan keyedTask[T], keyedWorkerQueueSize)
+		queues[i] = queue
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range queue {
+				// 已经取消了的话只是把队列排空，不再处理
+				if ctx.Err() != nil {
+					continue
+				}
+				ok, err := h.handle(ctx, task.msg, task.t)
+				if err != nil {
+					errMutex.Lock()
+					fatalErr = err
+					errMutex.Unlock()
+					cancel()
+					continue
+				}
+				if ok {
+					tracker.done(task.msg)
+				}
+			}
+		}()
+	}
+
+	msgs := claim.Messages()
+dispatch:
+	for {
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case msg, ok := <-msgs:
+			if !ok {
+				// rebalance 或者消费者关闭了，把已经分发出去的处理完再退出
+				break dispatch
+			}
+			tracker.add(msg)
+			var t T
+			err := h.opts.codecs.Decode(msg, &t)
+			if err != nil {
+				h.l.Error("反序列化消息体失败",
+					logger.String("topic", msg.Topic),
+					logger.Int32("partition", msg.Partition),
+					logger.Int64("offset", msg.Offset),
+					logger.Error(err))
+				err = giveUp(h.l, h.opts, msg, err, 0)
+				if err != nil {
+					errMutex.Lock()
+					fatalErr = err
+					errMutex.Unlock()
+					break dispatch
+				}
+				tracker.done(msg)
+				continue
+			}
+			select {
+			case queues[h.worker(msg, t)] <- keyedTask[T]{msg: msg, t: t}:
+			case <-ctx.Done():
+				break dispatch
+			}
+		}
+	}
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+	errMutex.Lock()
+	defer errMutex.Unlock()
+	return fatalErr
+}
+
+// handle 处理单条消息，返回能不能提交。返回错误说明转发死信队列失败了，整个 claim 都要停下来
+func (h *KeyedHandler[T]) handle(ctx context.Context, msg *sarama.ConsumerMessage, t T) (bool, error) {
+	retries, err := h.opts.retry.Do(ctx, func() error {
+		return h.fn(msg, t)
+	})
+	if err == nil {
+		return true, nil
+	}
+	if ctx.Err() != nil {
+		// 不提交，这条消息会被重新消费
+		return false, nil
+	}
+	h.l.Error("处理消息失败",
+		logger.String("topic", msg.Topic),
+		logger.Int32("partition", msg.Partition),
+		logger.Int64("offset", msg.Offset),
+		logger.Int("retries", retries),
+		logger.Error(err))
+	err = giveUp(h.l, h.opts, msg, err, retries)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (h *KeyedHandler[T]) worker(msg *sarama.ConsumerMessage, t T) int {
+	var key string
+	if h.keyFn != nil {
+		key = h.keyFn(msg, t)
+	} else {
+		key = string(msg.Key)
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return int(hash.Sum32() % uint32(h.workers))
+}
+
+// offsetTracker 记录一个分区里面已经分发出去的消息，按照 offset 的顺序，
+// 只有从头开始连续处理完的消息才会被提交
+type offsetTracker struct {
+	mutex   sync.Mutex
+	session sarama.ConsumerGroupSession
+	// 按 offset 升序排列的 *trackedMsg
+	pending *list.List
+	index   map[int64]*list.Element
+}
+
+type trackedMsg struct {
+	msg  *sarama.ConsumerMessage
+	done bool
+}
+
+func newOffsetTracker(session sarama.ConsumerGroupSession) *offsetTracker {
+	return &offsetTracker{
+		session: session,
+		pending: list.New(),
+		index:   make(map[int64]*list.Element),
+	}
+}
+
+// add 同一个分区的消息是按照 offset 递增的顺序过来的，直接追加在末尾
+func (t *offsetTracker) add(msg *sarama.ConsumerMessage) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.index[msg.Offset] = t.pending.PushBack(&trackedMsg{msg: msg})
+}
+
+func (t *offsetTracker) done(msg *sarama.ConsumerMessage) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	elem, ok := t.index[msg.Offset]
+	if !ok {
+		return
+	}
+	elem.Value.(*trackedMsg).done = true
+	// 推进低水位
+	var last *sarama.ConsumerMessage
+	for front := t.pending.Front(); front != nil; front = t.pending.Front() {
+		tm := front.Value.(*trackedMsg)
+		if !tm.done {
+			break
+		}
+		last = tm.msg
+		t.pending.Remove(front)
+		delete(t.index, tm.msg.Offset)
+	}
+	if last != nil {
+		t.session.MarkMessage(last, "")
+	}
+}
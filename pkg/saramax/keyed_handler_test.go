@@ -0,0 +1,158 @@
+package saramax
+
+import (
+	"errors"
+	"github.com/IBM/sarama"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOffsetTracker(t *testing.T) {
+	testCases := []struct {
+		name string
+		n    int
+		// 按照这个顺序处理完
+		doneOrder []int64
+		// 每处理完一条之后提交到的 offset，-1 表示还没有提交
+		wantCommitted []int64
+	}{
+		{
+			name:          "按顺序处理完",
+			n:             3,
+			doneOrder:     []int64{0, 1, 2},
+			wantCommitted: []int64{0, 1, 2},
+		},
+		{
+			name:          "倒序处理完",
+			n:             3,
+			doneOrder:     []int64{2, 1, 0},
+			wantCommitted: []int64{-1, -1, 2},
+		},
+		{
+			name:          "中间有空洞",
+			n:             5,
+			doneOrder:     []int64{0, 2, 3, 1, 4},
+			wantCommitted: []int64{0, 0, 0, 3, 4},
+		},
+		{
+			name:          "重复处理完",
+			n:             3,
+			doneOrder:     []int64{1, 1, 0, 0, 2},
+			wantCommitted: []int64{-1, -1, 1, 1, 2},
+		},
+		{
+			name:          "第一条一直没处理完",
+			n:             4,
+			doneOrder:     []int64{3, 2, 1},
+			wantCommitted: []int64{-1, -1, -1},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			session := newFakeSession()
+			tracker := newOffsetTracker(session)
+			keys := make([]string, tc.n)
+			msgs := testMessages(keys...)
+			for _, msg := range msgs {
+				tracker.add(msg)
+			}
+			committed := make([]int64, 0, len(tc.doneOrder))
+			for _, offset := range tc.doneOrder {
+				tracker.done(msgs[offset])
+				committed = append(committed, session.committed())
+			}
+			assert.Equal(t, tc.wantCommitted, committed)
+			// 提交的 offset 只会往前走
+			assert.True(t, slices.IsSorted(session.marked))
+		})
+	}
+}
+
+func TestKeyedHandler_ConsumeClaim(t *testing.T) {
+	keys := []string{"a", "b", "a", "c", "b", "a", "c", "c", "b", "a"}
+	session := newFakeSession()
+	var (
+		mutex sync.Mutex
+		// 每个 key 处理的顺序
+		seen = make(map[string][]int64)
+	)
+	h := NewKeyedHandler[testEvent](logger.NewNopLogger(), 3, nil,
+		func(msg *sarama.ConsumerMessage, evt testEvent) error {
+			// 越靠前的消息处理得越慢，打乱不同 key 之间完成的顺序
+			time.Sleep(time.Millisecond * time.Duration(len(keys)-int(evt.Id)))
+			mutex.Lock()
+			defer mutex.Unlock()
+			seen[evt.Key] = append(seen[evt.Key], evt.Id)
+			return nil
+		})
+	err := h.ConsumeClaim(session, newFakeClaim(testMessages(keys...)))
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]int64{
+		"a": {0, 2, 5, 9},
+		"b": {1, 4, 8},
+		"c": {3, 6, 7},
+	}, seen)
+	assert.Equal(t, int64(len(keys)-1), session.committed())
+	assert.True(t, slices.IsSorted(session.marked))
+}
+
+func TestKeyedHandler_GiveUp(t *testing.T) {
+	errPermanent := errors.New("永久错误")
+	errDLQ := errors.New("死信队列写不进去")
+	testCases := []struct {
+		name string
+		keys []string
+		// 处理失败的消息 id
+		fail map[int64]bool
+		dlq  []dlqExpectation
+
+		wantCommitted int64
+		wantErr       error
+	}{
+		{
+			name:          "转发死信队列之后接着提交",
+			keys:          []string{"a", "a", "a"},
+			fail:          map[int64]bool{1: true},
+			dlq:           []dlqExpectation{{offset: 1}},
+			wantCommitted: 2,
+		},
+		{
+			name:          "反序列化失败的转发死信队列",
+			keys:          []string{"a", "bad", "a"},
+			dlq:           []dlqExpectation{{offset: 1}},
+			wantCommitted: 2,
+		},
+		{
+			name:          "死信队列写失败停在失败的那条之前",
+			keys:          []string{"a", "a", "a"},
+			fail:          map[int64]bool{1: true},
+			dlq:           []dlqExpectation{{offset: 1, err: errDLQ}},
+			wantCommitted: 0,
+			wantErr:       errDLQ,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			session := newFakeSession()
+			dlq, producer := newTestDLQ(t, tc.dlq)
+			defer func() {
+				require.NoError(t, producer.Close())
+			}()
+			h := NewKeyedHandler[testEvent](logger.NewNopLogger(), 1, nil,
+				func(msg *sarama.ConsumerMessage, evt testEvent) error {
+					if tc.fail[evt.Id] {
+						return NonRetryable(errPermanent)
+					}
+					return nil
+				}, WithDeadLetterQueue(dlq))
+			err := h.ConsumeClaim(session, newFakeClaim(testMessages(tc.keys...)))
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.wantCommitted, session.committed())
+		})
+	}
+}
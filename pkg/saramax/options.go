@@ -0,0 +1,86 @@
+package saramax
+
+import (
+	"github.com/IBM/sarama"
+	"time"
+)
+
+type handlerOptions struct {
+	retry  RetryPolicy
+	dlq    *DeadLetterQueue
+	codecs *Codecs
+
+	// 以下只对 BatchHandler 生效
+	batchSize int
+	// 凑一批最多等多久
+	batchMaxWait time.Duration
+	// 一批消息体的总字节数上限，0 表示不限制
+	batchMaxBytes int
+	// rebalance 或者关闭的时候，在 Cleanup 里面调用
+	onCleanup func(session sarama.ConsumerGroupSession) error
+}
+
+type Option func(opts *handlerOptions)
+
+func newHandlerOptions(opts []Option) handlerOptions {
+	// 默认不重试，也不转发死信队列，和以前的行为保持一致
+	res := handlerOptions{
+		codecs:       DefaultCodecs(),
+		batchSize:    10,
+		batchMaxWait: time.Second,
+	}
+	for _, opt := range opts {
+		opt(&res)
+	}
+	return res
+}
+
+func WithRetry(policy RetryPolicy) Option {
+	return func(opts *handlerOptions) {
+		opts.retry = policy
+	}
+}
+
+// WithDeadLetterQueue 重试之后依旧失败的消息转发到死信队列。
+// 不设置的话只会记录日志，然后照样提交
+func WithDeadLetterQueue(dlq *DeadLetterQueue) Option {
+	return func(opts *handlerOptions) {
+		opts.dlq = dlq
+	}
+}
+
+// WithCodecs 指定解码器，默认按照 content-type 在 JSON 和 protobuf 之间选择
+func WithCodecs(codecs *Codecs) Option {
+	return func(opts *handlerOptions) {
+		opts.codecs = codecs
+	}
+}
+
+// WithBatchSize 一批最多多少条消息
+func WithBatchSize(size int) Option {
+	return func(opts *handlerOptions) {
+		opts.batchSize = size
+	}
+}
+
+// WithBatchMaxWait 凑一批最多等多久，时间到了不管凑没凑够都处理
+func WithBatchMaxWait(d time.Duration) Option {
+	return func(opts *handlerOptions) {
+		opts.batchMaxWait = d
+	}
+}
+
+// WithBatchMaxBytes 一批消息的 key 和 value 加起来最多多少字节
+func WithBatchMaxBytes(n int) Option {
+	return func(opts *handlerOptions) {
+		opts.batchMaxBytes = n
+	}
+}
+
+// WithCleanupHook rebalance 或者消费者关闭的时候调用，
+// 适合在这里把业务自己缓存在内存里面的数据（例如聚合到一半的计数）刷出去
+func WithCleanupHook(fn func(session sarama.ConsumerGroupSession) error) Option {
+	return func(opts *handlerOptions) {
+		opts.onCleanup = fn
+	}
+}
@@ -0,0 +1,97 @@
+package saramax
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+// RetryPolicy 处理消息失败之后的重试策略，退避时间按指数增长
+type RetryPolicy struct {
+	// 最多重试几次，0 表示不重试
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Retryable 判断错误是否值得重试，为 nil 的时候除了 NonRetryable 包装过的错误都重试
+	Retryable func(err error) bool
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries:     3,
+		InitialBackoff: time.Millisecond * 100,
+		MaxBackoff:     time.Second * 5,
+	}
+}
+
+type nonRetryableError struct {
+	err error
+}
+
+func (e *nonRetryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *nonRetryableError) Unwrap() error {
+	return e.err
+}
+
+// NonRetryable 标记错误不需要重试，例如消息本身就有问题，重试多少次都不会成功
+func NonRetryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &nonRetryableError{err: err}
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	var nre *nonRetryableError
+	if errors.As(err, &nre) {
+		return false
+	}
+	if p.Retryable == nil {
+		return true
+	}
+	return p.Retryable(err)
+}
+
+func (p RetryPolicy) backoff(retries int) time.Duration {
+	d := p.InitialBackoff << retries
+	// 左移溢出了（结果不一定是负数）就当作无穷大，再按上限截断
+	if retries >= 63 || d < 0 || d>>retries != p.InitialBackoff {
+		d = math.MaxInt64
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return d
+}
+
+// Do 执行 fn，失败了就按照策略重试，返回重试的次数和最后一次的错误。
+// ctx 被取消的时候（例如 rebalance）不再重试，直接返回 ctx 的错误
+func (p RetryPolicy) Do(ctx context.Context, fn func() error) (int, error) {
+	var retries int
+	for {
+		err := fn()
+		if err == nil || retries >= p.MaxRetries || !p.retryable(err) {
+			return retries, err
+		}
+		if er := p.wait(ctx, retries); er != nil {
+			return retries, er
+		}
+		retries++
+	}
+}
+
+// wait 等待第 retries+1 次重试前的退避时间
+func (p RetryPolicy) wait(ctx context.Context, retries int) error {
+	timer := time.NewTimer(p.backoff(retries))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
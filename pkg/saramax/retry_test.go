@@ -0,0 +1,194 @@
+package saramax
+
+import (
+	"context"
+	"errors"
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	testCases := []struct {
+		name    string
+		policy  RetryPolicy
+		retries int
+		want    time.Duration
+	}{
+		{
+			name:    "第一次重试",
+			policy:  RetryPolicy{InitialBackoff: time.Millisecond * 100, MaxBackoff: time.Second},
+			retries: 0,
+			want:    time.Millisecond * 100,
+		},
+		{
+			name:    "指数增长",
+			policy:  RetryPolicy{InitialBackoff: time.Millisecond * 100, MaxBackoff: time.Second},
+			retries: 3,
+			want:    time.Millisecond * 800,
+		},
+		{
+			name:    "超过上限",
+			policy:  RetryPolicy{InitialBackoff: time.Millisecond * 100, MaxBackoff: time.Second},
+			retries: 4,
+			want:    time.Second,
+		},
+		{
+			name:    "溢出按上限算",
+			policy:  RetryPolicy{InitialBackoff: time.Millisecond * 100, MaxBackoff: time.Second},
+			retries: 62,
+			want:    time.Second,
+		},
+		{
+			name:    "没有上限",
+			policy:  RetryPolicy{InitialBackoff: time.Millisecond},
+			retries: 10,
+			want:    time.Millisecond * 1024,
+		},
+		{
+			name:    "没有上限的时候溢出",
+			policy:  RetryPolicy{InitialBackoff: time.Duration(math.MaxInt64 / 2)},
+			retries: 2,
+			want:    time.Duration(math.MaxInt64),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.policy.backoff(tc.retries))
+		})
+	}
+}
+
+func TestRetryPolicy_Do(t *testing.T) {
+	errTemporary := errors.New("临时错误")
+	errPermanent := errors.New("永久错误")
+	testCases := []struct {
+		name   string
+		policy RetryPolicy
+		// 第 i 次调用返回 errs[i]，超出的部分返回 nil
+		errs []error
+		ctx  func() context.Context
+
+		wantRetries int
+		wantCalls   int
+		wantErr     error
+	}{
+		{
+			name:      "第一次就成功",
+			policy:    RetryPolicy{MaxRetries: 3, InitialBackoff: time.Millisecond},
+			wantCalls: 1,
+		},
+		{
+			name:        "重试之后成功",
+			policy:      RetryPolicy{MaxRetries: 3, InitialBackoff: time.Millisecond},
+			errs:        []error{errTemporary, errTemporary},
+			wantRetries: 2,
+			wantCalls:   3,
+		},
+		{
+			name:        "重试次数用完",
+			policy:      RetryPolicy{MaxRetries: 2, InitialBackoff: time.Millisecond},
+			errs:        []error{errTemporary, errTemporary, errTemporary, errTemporary},
+			wantRetries: 2,
+			wantCalls:   3,
+			wantErr:     errTemporary,
+		},
+		{
+			name:      "不重试",
+			policy:    RetryPolicy{},
+			errs:      []error{errTemporary},
+			wantCalls: 1,
+			wantErr:   errTemporary,
+		},
+		{
+			name:      "NonRetryable 不重试",
+			policy:    RetryPolicy{MaxRetries: 3, InitialBackoff: time.Millisecond},
+			errs:      []error{NonRetryable(errPermanent)},
+			wantCalls: 1,
+			wantErr:   errPermanent,
+		},
+		{
+			name: "Retryable 判断不重试",
+			policy: RetryPolicy{MaxRetries: 3, InitialBackoff: time.Millisecond, Retryable: func(err error) bool {
+				return !errors.Is(err, errPermanent)
+			}},
+			errs:        []error{errTemporary, errPermanent},
+			wantRetries: 1,
+			wantCalls:   2,
+			wantErr:     errPermanent,
+		},
+		{
+			name:   "ctx 取消之后不再重试",
+			policy: RetryPolicy{MaxRetries: 3, InitialBackoff: time.Hour},
+			errs:   []error{errTemporary},
+			ctx: func() context.Context {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx
+			},
+			wantCalls: 1,
+			wantErr:   context.Canceled,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.ctx != nil {
+				ctx = tc.ctx()
+			}
+			var calls int
+			retries, err := tc.policy.Do(ctx, func() error {
+				calls++
+				if calls <= len(tc.errs) {
+					return tc.errs[calls-1]
+				}
+				return nil
+			})
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.wantRetries, retries)
+			assert.Equal(t, tc.wantCalls, calls)
+		})
+	}
+}
+
+func TestDeadLetterQueue_Send(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(pm *sarama.ProducerMessage) error {
+		assert.Equal(t, "comment_write_dlq", pm.Topic)
+		key, err := pm.Key.Encode()
+		require.NoError(t, err)
+		assert.Equal(t, "k", string(key))
+		val, err := pm.Value.Encode()
+		require.NoError(t, err)
+		assert.Equal(t, "v", string(val))
+		headers := make(map[string]string, len(pm.Headers))
+		for _, h := range pm.Headers {
+			headers[string(h.Key)] = string(h.Value)
+		}
+		assert.Equal(t, "trace", headers["x-trace-id"])
+		assert.Equal(t, "失败了", headers[HeaderDLQError])
+		assert.Equal(t, "comment_write", headers[HeaderDLQOriginalTopic])
+		assert.Equal(t, "2", headers[HeaderDLQOriginalPartition])
+		assert.Equal(t, "42", headers[HeaderDLQOriginalOffset])
+		assert.Equal(t, "3", headers[HeaderDLQRetries])
+		assert.NotEmpty(t, headers[HeaderDLQTime])
+		return nil
+	})
+	dlq := NewDeadLetterQueue(producer, "comment_write_dlq")
+	err := dlq.Send(&sarama.ConsumerMessage{
+		Topic:     "comment_write",
+		Partition: 2,
+		Offset:    42,
+		Key:       []byte("k"),
+		Value:     []byte("v"),
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte("x-trace-id"), Value: []byte("trace")},
+		},
+	}, errors.New("失败了"), 3)
+	require.NoError(t, err)
+	require.NoError(t, producer.Close())
+}
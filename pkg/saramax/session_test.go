@@ -0,0 +1,146 @@
+package saramax
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// fakeSession 只记录提交了哪些 offset
+type fakeSession struct {
+	ctx    context.Context
+	claims map[string][]int32
+	mutex  sync.Mutex
+	marked []int64
+}
+
+func newFakeSession() *fakeSession {
+	return &fakeSession{ctx: context.Background()}
+}
+
+func (s *fakeSession) Claims() map[string][]int32 { return s.claims }
+
+func (s *fakeSession) MemberID() string { return "" }
+
+func (s *fakeSession) GenerationID() int32 { return 0 }
+
+func (s *fakeSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {}
+
+func (s *fakeSession) Commit() {}
+
+func (s *fakeSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {}
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.marked = append(s.marked, msg.Offset)
+}
+
+func (s *fakeSession) Context() context.Context { return s.ctx }
+
+// markedSet 提交过的 offset，去重之后升序
+func (s *fakeSession) markedSet() []int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	res := slices.Clone(s.marked)
+	slices.Sort(res)
+	return slices.Compact(res)
+}
+
+// committed 最后提交的 offset，没有提交过返回 -1
+func (s *fakeSession) committed() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	res := int64(-1)
+	for _, offset := range s.marked {
+		res = max(res, offset)
+	}
+	return res
+}
+
+type fakeClaim struct {
+	partition int32
+	msgs      chan *sarama.ConsumerMessage
+}
+
+// newFakeClaim 消息全部放进去之后关闭 channel，相当于发生了 rebalance
+func newFakeClaim(msgs []*sarama.ConsumerMessage) *fakeClaim {
+	ch := make(chan *sarama.ConsumerMessage, len(msgs))
+	for _, msg := range msgs {
+		ch <- msg
+	}
+	close(ch)
+	return &fakeClaim{msgs: ch}
+}
+
+func (c *fakeClaim) Topic() string { return "test" }
+
+func (c *fakeClaim) Partition() int32 { return c.partition }
+
+func (c *fakeClaim) InitialOffset() int64 { return 0 }
+
+func (c *fakeClaim) HighWaterMarkOffset() int64 { return 0 }
+
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return c.msgs }
+
+type testEvent struct {
+	Id  int64  `json:"id"`
+	Key string `json:"key"`
+}
+
+// testMessages 第 i 条消息的 offset 是 i，消息体里面的 id 也是 i，
+// key 为 bad 的位置放一条反序列化会失败的消息
+func testMessages(keys ...string) []*sarama.ConsumerMessage {
+	res := make([]*sarama.ConsumerMessage, 0, len(keys))
+	for i, key := range keys {
+		val := []byte("{")
+		if key != "bad" {
+			val, _ = json.Marshal(testEvent{Id: int64(i), Key: key})
+		}
+		res = append(res, &sarama.ConsumerMessage{
+			Topic:  "test",
+			Offset: int64(i),
+			Key:    []byte(key),
+			Value:  val,
+		})
+	}
+	return res
+}
+
+// dlqExpectation 期望转发到死信队列的一条消息，err 不为 nil 的时候转发失败
+type dlqExpectation struct {
+	offset  int64
+	retries int
+	err     error
+}
+
+func newTestDLQ(t *testing.T, expects []dlqExpectation) (*DeadLetterQueue, *mocks.SyncProducer) {
+	producer := mocks.NewSyncProducer(t, nil)
+	for _, exp := range expects {
+		exp := exp
+		checker := func(pm *sarama.ProducerMessage) error {
+			headers := make(map[string]string, len(pm.Headers))
+			for _, h := range pm.Headers {
+				headers[string(h.Key)] = string(h.Value)
+			}
+			if headers[HeaderDLQOriginalOffset] != strconv.FormatInt(exp.offset, 10) {
+				t.Errorf("死信队列的消息 offset 是 %s，期望 %d", headers[HeaderDLQOriginalOffset], exp.offset)
+			}
+			if headers[HeaderDLQRetries] != strconv.Itoa(exp.retries) {
+				t.Errorf("死信队列的消息重试了 %s 次，期望 %d", headers[HeaderDLQRetries], exp.retries)
+			}
+			return nil
+		}
+		if exp.err != nil {
+			producer.ExpectSendMessageWithMessageCheckerFunctionAndFail(checker, exp.err)
+		} else {
+			producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(checker)
+		}
+	}
+	return NewDeadLetterQueue(producer, "test_dlq"), producer
+}
@@ -2,4 +2,6 @@ package saramax
 
 type Consumer interface {
 	Start() error
+	// Close 停止消费，等正在处理的消息处理完并提交 offset 之后再返回
+	Close() error
 }
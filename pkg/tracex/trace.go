@@ -0,0 +1,39 @@
+package tracex
+
+import (
+	"context"
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+	"github.com/google/uuid"
+)
+
+// HeaderTraceID 上游通过这个 header 传入 trace id，没有的话在入口生成一个
+const HeaderTraceID = "x-trace-id"
+
+type traceIDKey struct{}
+
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceID 取出 ctx 里面的 trace id，没有的话返回空字符串
+func TraceID(ctx context.Context) string {
+	val, _ := ctx.Value(traceIDKey{}).(string)
+	return val
+}
+
+// Server 从请求头里面取出 trace id 放进 ctx，没有就生成一个
+func Server() middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			var traceID string
+			if tr, ok := transport.FromServerContext(ctx); ok {
+				traceID = tr.RequestHeader().Get(HeaderTraceID)
+			}
+			if traceID == "" {
+				traceID = uuid.NewString()
+			}
+			return handler(WithTraceID(ctx, traceID), req)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-comment/repository/dao"
+)
+
+type AnonymousAliasRepository interface {
+	// GetOrCreate 用户在 rootId 这个楼里面的化名编号，第一次匿名发言的时候分配
+	GetOrCreate(ctx context.Context, rootId int64, uid int64) (int32, error)
+}
+
+type anonymousAliasRepository struct {
+	dao dao.AnonymousAliasDAO
+}
+
+func NewAnonymousAliasRepository(dao dao.AnonymousAliasDAO) AnonymousAliasRepository {
+	return &anonymousAliasRepository{dao: dao}
+}
+
+func (repo *anonymousAliasRepository) GetOrCreate(ctx context.Context, rootId int64, uid int64) (int32, error) {
+	return repo.dao.GetOrCreate(ctx, rootId, uid)
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/MuxiKeStack/be-comment/repository/cache"
+	"github.com/MuxiKeStack/be-comment/repository/dao"
+	"github.com/ecodeclub/ekit/slice"
+)
+
+type BlockRepository interface {
+	Block(ctx context.Context, uid int64, blockedUid int64) error
+	Unblock(ctx context.Context, uid int64, blockedUid int64) error
+	// FindBlocked uid 拉黑的所有人
+	FindBlocked(ctx context.Context, uid int64) ([]int64, error)
+	// IsBlocked uid 有没有拉黑 blockedUid
+	IsBlocked(ctx context.Context, uid int64, blockedUid int64) (bool, error)
+}
+
+type CachedBlockRepo struct {
+	dao   dao.BlockDAO
+	cache cache.BlockCache
+	l     logger.Logger
+}
+
+func NewCachedBlockRepo(dao dao.BlockDAO, cache cache.BlockCache, l logger.Logger) BlockRepository {
+	return &CachedBlockRepo{
+		dao:   dao,
+		cache: cache,
+		l:     l,
+	}
+}
+
+func (repo *CachedBlockRepo) Block(ctx context.Context, uid int64, blockedUid int64) error {
+	err := repo.dao.Block(ctx, uid, blockedUid)
+	if err != nil {
+		return err
+	}
+	return repo.cache.DelBlocked(ctx, uid)
+}
+
+func (repo *CachedBlockRepo) Unblock(ctx context.Context, uid int64, blockedUid int64) error {
+	err := repo.dao.Unblock(ctx, uid, blockedUid)
+	if err != nil {
+		return err
+	}
+	return repo.cache.DelBlocked(ctx, uid)
+}
+
+func (repo *CachedBlockRepo) FindBlocked(ctx context.Context, uid int64) ([]int64, error) {
+	res, err := repo.cache.GetBlocked(ctx, uid)
+	if err == nil {
+		return res, nil
+	}
+	if err != cache.ErrKeyNotExists {
+		repo.l.Error("获取拉黑列表缓存失败",
+			logger.Error(err),
+			logger.Int64("uid", uid))
+	}
+	res, err = repo.dao.FindBlocked(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	err = repo.cache.SetBlocked(ctx, uid, res)
+	if err != nil {
+		repo.l.Error("回写拉黑列表缓存失败",
+			logger.Error(err),
+			logger.Int64("uid", uid))
+	}
+	return res, nil
+}
+
+func (repo *CachedBlockRepo) IsBlocked(ctx context.Context, uid int64, blockedUid int64) (bool, error) {
+	blocked, err := repo.FindBlocked(ctx, uid)
+	if err != nil {
+		return false, err
+	}
+	return slice.Contains(blocked, blockedUid), nil
+}
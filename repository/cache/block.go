@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// BlockCache 缓存每个用户拉黑的人，列表评论的时候每次都要用
+type BlockCache interface {
+	GetBlocked(ctx context.Context, uid int64) ([]int64, error)
+	SetBlocked(ctx context.Context, uid int64, blocked []int64) error
+	DelBlocked(ctx context.Context, uid int64) error
+}
+
+type RedisBlockCache struct {
+	cmd redis.Cmdable
+}
+
+func NewRedisBlockCache(cmd redis.Cmdable) BlockCache {
+	return &RedisBlockCache{cmd: cmd}
+}
+
+func (cache *RedisBlockCache) GetBlocked(ctx context.Context, uid int64) ([]int64, error) {
+	data, err := cache.cmd.Get(ctx, blockedKey(uid)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var res []int64
+	err = json.Unmarshal(data, &res)
+	return res, err
+}
+
+// SetBlocked 没有拉黑任何人的也缓存一个空列表，大部分用户都是这样
+func (cache *RedisBlockCache) SetBlocked(ctx context.Context, uid int64, blocked []int64) error {
+	if blocked == nil {
+		blocked = []int64{}
+	}
+	data, err := json.Marshal(blocked)
+	if err != nil {
+		return err
+	}
+	return cache.cmd.Set(ctx, blockedKey(uid), data, time.Minute*30).Err()
+}
+
+func (cache *RedisBlockCache) DelBlocked(ctx context.Context, uid int64) error {
+	return cache.cmd.Del(ctx, blockedKey(uid)).Err()
+}
+
+func blockedKey(uid int64) string {
+	return fmt.Sprintf("kstack:comment:blocked:%d", uid)
+}
@@ -3,12 +3,25 @@ package cache
 import (
 	"context"
 	_ "embed"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/MuxiKeStack/be-comment/domain"
 	"github.com/redis/go-redis/v9"
 	"time"
 )
 
-var ErrKeyNotExists = redis.Nil
+var (
+	ErrKeyNotExists = redis.Nil
+	// ErrNotFoundCached 缓存了"评论不存在"这个结果
+	ErrNotFoundCached = errors.New("评论不存在（缓存）")
+)
+
+// 评论不存在时缓存的空值，过期时间要短，避免评论（比如迁移导入的）出现之后还一直查不到
+const (
+	commentNotFoundVal = "null"
+	commentNotFoundTTL = time.Second * 30
+)
 
 //go:embed lua/comment_cnt_incr.lua
 var commentCntIncrLuaScript string
@@ -18,6 +31,16 @@ type CommentCache interface {
 	SetBizCommentCount(ctx context.Context, biz int32, bizId int64, count int64) error
 	IncrBizCommentCountIfPresent(ctx context.Context, biz int32, bizId int64) error
 	DecrBizCommentCountIfPresent(ctx context.Context, biz int32, bizId int64) error
+	// AddBizCommentCountIfPresent 批量落库的时候按 <biz,bizId> 汇总之后一次性加上
+	AddBizCommentCountIfPresent(ctx context.Context, biz int32, bizId int64, delta int64) error
+	DelBizCommentCount(ctx context.Context, biz int32, bizId int64) error
+	GetComment(ctx context.Context, commentId int64) (domain.Comment, error)
+	SetComment(ctx context.Context, comment domain.Comment) error
+	DelComment(ctx context.Context, commentId int64) error
+	// DelComments 一次删掉多条评论，删除评论的时候级联删除的回复也要一起删掉
+	DelComments(ctx context.Context, commentIds ...int64) error
+	// SetCommentNotFound 短暂地缓存评论不存在，防止反复查询不存在的 id 打到数据库
+	SetCommentNotFound(ctx context.Context, commentId int64) error
 }
 
 type RedisCommentCache struct {
@@ -29,25 +52,83 @@ func NewRedisCommentCache(cmd redis.Cmdable) CommentCache {
 }
 
 func (cache *RedisCommentCache) GetBizCommentCount(ctx context.Context, biz int32, bizId int64) (int64, error) {
-	key := cache.bizCommentCountKey(biz, bizId)
+	key := bizCommentCountKey(biz, bizId)
 	return cache.cmd.Get(ctx, key).Int64()
 }
 
 func (cache *RedisCommentCache) SetBizCommentCount(ctx context.Context, biz int32, bizId int64, count int64) error {
-	key := cache.bizCommentCountKey(biz, bizId)
+	key := bizCommentCountKey(biz, bizId)
 	return cache.cmd.Set(ctx, key, count, time.Minute*10).Err()
 }
 
 func (cache *RedisCommentCache) IncrBizCommentCountIfPresent(ctx context.Context, biz int32, bizId int64) error {
-	key := cache.bizCommentCountKey(biz, bizId)
+	key := bizCommentCountKey(biz, bizId)
 	return cache.cmd.Eval(ctx, commentCntIncrLuaScript, []string{key}, 1).Err()
 }
 
 func (cache *RedisCommentCache) DecrBizCommentCountIfPresent(ctx context.Context, biz int32, bizId int64) error {
-	key := cache.bizCommentCountKey(biz, bizId)
+	key := bizCommentCountKey(biz, bizId)
 	return cache.cmd.Eval(ctx, commentCntIncrLuaScript, []string{key}, -1).Err()
 }
 
-func (cache *RedisCommentCache) bizCommentCountKey(biz int32, bizId int64) string {
+func (cache *RedisCommentCache) AddBizCommentCountIfPresent(ctx context.Context, biz int32, bizId int64, delta int64) error {
+	key := bizCommentCountKey(biz, bizId)
+	return cache.cmd.Eval(ctx, commentCntIncrLuaScript, []string{key}, delta).Err()
+}
+
+func (cache *RedisCommentCache) DelBizCommentCount(ctx context.Context, biz int32, bizId int64) error {
+	key := bizCommentCountKey(biz, bizId)
+	return cache.cmd.Del(ctx, key).Err()
+}
+
+func (cache *RedisCommentCache) GetComment(ctx context.Context, commentId int64) (domain.Comment, error) {
+	data, err := cache.cmd.Get(ctx, commentKey(commentId)).Bytes()
+	if err != nil {
+		return domain.Comment{}, err
+	}
+	if string(data) == commentNotFoundVal {
+		return domain.Comment{}, ErrNotFoundCached
+	}
+	var c domain.Comment
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+func (cache *RedisCommentCache) SetComment(ctx context.Context, comment domain.Comment) error {
+	data, err := json.Marshal(comment)
+	if err != nil {
+		return err
+	}
+	return cache.cmd.Set(ctx, commentKey(comment.Id), data, time.Minute*10).Err()
+}
+
+func (cache *RedisCommentCache) SetCommentNotFound(ctx context.Context, commentId int64) error {
+	return cache.cmd.Set(ctx, commentKey(commentId), commentNotFoundVal, commentNotFoundTTL).Err()
+}
+
+func (cache *RedisCommentCache) DelComment(ctx context.Context, commentId int64) error {
+	return cache.cmd.Del(ctx, commentKey(commentId)).Err()
+}
+
+func (cache *RedisCommentCache) DelComments(ctx context.Context, commentIds ...int64) error {
+	if len(commentIds) == 0 {
+		return nil
+	}
+	return cache.cmd.Del(ctx, commentKeys(commentIds)...).Err()
+}
+
+func commentKeys(commentIds []int64) []string {
+	keys := make([]string, 0, len(commentIds))
+	for _, id := range commentIds {
+		keys = append(keys, commentKey(id))
+	}
+	return keys
+}
+
+func bizCommentCountKey(biz int32, bizId int64) string {
 	return fmt.Sprintf("kstack:comment:biz_comment_count:<%d,%d>", biz, bizId)
 }
+
+func commentKey(commentId int64) string {
+	return fmt.Sprintf("kstack:comment:comment:%d", commentId)
+}
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LocalCache 进程内的 LRU 缓存，容量和过期时间都是有界的，
+// 只用来挡住热点 key 打到 redis 上的流量，所以过期时间要设置得比较短
+type LocalCache struct {
+	mutex    sync.Mutex
+	capacity int
+	ttl      time.Duration
+	// 队头是最近访问的
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type localEntry struct {
+	key      string
+	val      any
+	deadline time.Time
+}
+
+func NewLocalCache(capacity int, ttl time.Duration) *LocalCache {
+	return &LocalCache{
+		capacity: capacity,
+		ttl:      ttl,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element, capacity),
+	}
+}
+
+func (c *LocalCache) Get(key string) (any, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*localEntry)
+	if time.Now().After(entry.deadline) {
+		c.removeElement(elem)
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return entry.val, true
+}
+
+func (c *LocalCache) Set(key string, val any) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	deadline := time.Now().Add(c.ttl)
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*localEntry)
+		entry.val = val
+		entry.deadline = deadline
+		c.ll.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&localEntry{key: key, val: val, deadline: deadline})
+	// 超过容量就淘汰最久没有访问的
+	for c.capacity > 0 && c.ll.Len() > c.capacity {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *LocalCache) Delete(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+func (c *LocalCache) removeElement(elem *list.Element) {
+	c.ll.Remove(elem)
+	delete(c.items, elem.Value.(*localEntry).key)
+}
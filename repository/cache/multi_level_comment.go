@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/redis/go-redis/v9"
+)
+
+// 各个实例之间通过这个 channel 广播失效的 key
+const localInvalidationChannel = "kstack:comment:local_cache_invalidation"
+
+// 本地缓存里表示评论不存在的值
+type commentNotFound struct{}
+
+// MultiLevelCommentCache 本地缓存 + redis 的二级缓存。
+// 任何写操作都会先更新 redis，再删掉本地的 key，并且广播给其他实例让它们也删掉，
+// 广播丢失的情况下（比如订阅断线）靠本地缓存较短的过期时间兜底
+type MultiLevelCommentCache struct {
+	local  *LocalCache
+	remote CommentCache
+	client redis.UniversalClient
+	l      logger.Logger
+}
+
+func NewMultiLevelCommentCache(local *LocalCache, remote CommentCache,
+	client redis.UniversalClient, l logger.Logger) CommentCache {
+	c := &MultiLevelCommentCache{
+		local:  local,
+		remote: remote,
+		client: client,
+		l:      l,
+	}
+	go c.subscribeInvalidation()
+	return c
+}
+
+func (c *MultiLevelCommentCache) GetBizCommentCount(ctx context.Context, biz int32, bizId int64) (int64, error) {
+	key := bizCommentCountKey(biz, bizId)
+	if val, ok := c.local.Get(key); ok {
+		return val.(int64), nil
+	}
+	count, err := c.remote.GetBizCommentCount(ctx, biz, bizId)
+	if err != nil {
+		return 0, err
+	}
+	c.local.Set(key, count)
+	return count, nil
+}
+
+func (c *MultiLevelCommentCache) SetBizCommentCount(ctx context.Context, biz int32, bizId int64, count int64) error {
+	err := c.remote.SetBizCommentCount(ctx, biz, bizId, count)
+	if err != nil {
+		return err
+	}
+	return c.invalidate(ctx, bizCommentCountKey(biz, bizId))
+}
+
+func (c *MultiLevelCommentCache) IncrBizCommentCountIfPresent(ctx context.Context, biz int32, bizId int64) error {
+	err := c.remote.IncrBizCommentCountIfPresent(ctx, biz, bizId)
+	if err != nil {
+		return err
+	}
+	return c.invalidate(ctx, bizCommentCountKey(biz, bizId))
+}
+
+func (c *MultiLevelCommentCache) DecrBizCommentCountIfPresent(ctx context.Context, biz int32, bizId int64) error {
+	err := c.remote.DecrBizCommentCountIfPresent(ctx, biz, bizId)
+	if err != nil {
+		return err
+	}
+	return c.invalidate(ctx, bizCommentCountKey(biz, bizId))
+}
+
+func (c *MultiLevelCommentCache) AddBizCommentCountIfPresent(ctx context.Context, biz int32, bizId int64, delta int64) error {
+	err := c.remote.AddBizCommentCountIfPresent(ctx, biz, bizId, delta)
+	if err != nil {
+		return err
+	}
+	return c.invalidate(ctx, bizCommentCountKey(biz, bizId))
+}
+
+func (c *MultiLevelCommentCache) DelBizCommentCount(ctx context.Context, biz int32, bizId int64) error {
+	err := c.remote.DelBizCommentCount(ctx, biz, bizId)
+	if err != nil {
+		return err
+	}
+	return c.invalidate(ctx, bizCommentCountKey(biz, bizId))
+}
+
+func (c *MultiLevelCommentCache) GetComment(ctx context.Context, commentId int64) (domain.Comment, error) {
+	key := commentKey(commentId)
+	if val, ok := c.local.Get(key); ok {
+		if _, notFound := val.(commentNotFound); notFound {
+			return domain.Comment{}, ErrNotFoundCached
+		}
+		return val.(domain.Comment), nil
+	}
+	comment, err := c.remote.GetComment(ctx, commentId)
+	if err == ErrNotFoundCached {
+		c.local.Set(key, commentNotFound{})
+	}
+	if err != nil {
+		return domain.Comment{}, err
+	}
+	c.local.Set(key, comment)
+	return comment, nil
+}
+
+func (c *MultiLevelCommentCache) SetComment(ctx context.Context, comment domain.Comment) error {
+	err := c.remote.SetComment(ctx, comment)
+	if err != nil {
+		return err
+	}
+	return c.invalidate(ctx, commentKey(comment.Id))
+}
+
+func (c *MultiLevelCommentCache) SetCommentNotFound(ctx context.Context, commentId int64) error {
+	err := c.remote.SetCommentNotFound(ctx, commentId)
+	if err != nil {
+		return err
+	}
+	return c.invalidate(ctx, commentKey(commentId))
+}
+
+func (c *MultiLevelCommentCache) DelComment(ctx context.Context, commentId int64) error {
+	err := c.remote.DelComment(ctx, commentId)
+	if err != nil {
+		return err
+	}
+	return c.invalidate(ctx, commentKey(commentId))
+}
+
+func (c *MultiLevelCommentCache) DelComments(ctx context.Context, commentIds ...int64) error {
+	if len(commentIds) == 0 {
+		return nil
+	}
+	err := c.remote.DelComments(ctx, commentIds...)
+	if err != nil {
+		return err
+	}
+	return c.invalidate(ctx, commentKeys(commentIds)...)
+}
+
+// invalidate 删除本地的 key，并通知其他实例删除，多个 key 的时候用 pipeline 一起发出去
+func (c *MultiLevelCommentCache) invalidate(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		c.local.Delete(key)
+	}
+	if len(keys) == 1 {
+		return c.client.Publish(ctx, localInvalidationChannel, keys[0]).Err()
+	}
+	_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Publish(ctx, localInvalidationChannel, key)
+		}
+		return nil
+	})
+	return err
+}
+
+func (c *MultiLevelCommentCache) subscribeInvalidation() {
+	// go-redis 的 PubSub 断线之后会自己重连，channel 只有在 Close 的时候才会关闭
+	pubsub := c.client.Subscribe(context.Background(), localInvalidationChannel)
+	for msg := range pubsub.Channel() {
+		c.local.Delete(msg.Payload)
+	}
+	c.l.Warn("本地缓存失效订阅已关闭")
+}
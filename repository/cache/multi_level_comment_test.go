@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestMultiLevelCommentCache_Invalidation(t *testing.T) {
+	testCases := []struct {
+		name string
+		// 在实例 a 上面做的写操作
+		write func(ctx context.Context, a CommentCache) error
+		// 实例 b 本地缓存里面应该被删掉的 key
+		wantEvicted []string
+		// 实例 b 本地缓存里面应该还在的 key
+		wantKept []string
+	}{
+		{
+			name: "删除一条评论",
+			write: func(ctx context.Context, a CommentCache) error {
+				return a.DelComment(ctx, 1)
+			},
+			wantEvicted: []string{commentKey(1)},
+			wantKept:    []string{commentKey(2), commentKey(3), bizCommentCountKey(1, 100)},
+		},
+		{
+			name: "级联删除的回复一起删掉",
+			write: func(ctx context.Context, a CommentCache) error {
+				return a.DelComments(ctx, 1, 2, 3)
+			},
+			wantEvicted: []string{commentKey(1), commentKey(2), commentKey(3)},
+			wantKept:    []string{bizCommentCountKey(1, 100)},
+		},
+		{
+			name: "没有要删除的评论",
+			write: func(ctx context.Context, a CommentCache) error {
+				return a.DelComments(ctx)
+			},
+			wantKept: []string{commentKey(1), commentKey(2), commentKey(3), bizCommentCountKey(1, 100)},
+		},
+		{
+			name: "更新评论",
+			write: func(ctx context.Context, a CommentCache) error {
+				return a.SetComment(ctx, domain.Comment{Id: 2, Content: "new"})
+			},
+			wantEvicted: []string{commentKey(2)},
+			wantKept:    []string{commentKey(1), commentKey(3), bizCommentCountKey(1, 100)},
+		},
+		{
+			name: "评论数变化",
+			write: func(ctx context.Context, a CommentCache) error {
+				return a.AddBizCommentCountIfPresent(ctx, 1, 100, -3)
+			},
+			wantEvicted: []string{bizCommentCountKey(1, 100)},
+			wantKept:    []string{commentKey(1), commentKey(2), commentKey(3)},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			mr := miniredis.RunT(t)
+			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			// 关闭客户端之后订阅的协程会退出
+			t.Cleanup(func() { _ = client.Close() })
+			remote := NewRedisCommentCache(client)
+			localB := NewLocalCache(100, time.Minute)
+			a := NewMultiLevelCommentCache(NewLocalCache(100, time.Minute), remote, client, logger.NewNopLogger())
+			b := NewMultiLevelCommentCache(localB, remote, client, logger.NewNopLogger())
+			require.Eventually(t, func() bool {
+				return mr.PubSubNumSub(localInvalidationChannel)[localInvalidationChannel] == 2
+			}, time.Second, time.Millisecond*10)
+
+			// b 先把数据读到本地
+			for _, id := range []int64{1, 2, 3} {
+				require.NoError(t, remote.SetComment(ctx, domain.Comment{Id: id}))
+				_, err := b.GetComment(ctx, id)
+				require.NoError(t, err)
+			}
+			require.NoError(t, remote.SetBizCommentCount(ctx, 1, 100, 3))
+			_, err := b.GetBizCommentCount(ctx, 1, 100)
+			require.NoError(t, err)
+
+			require.NoError(t, tc.write(ctx, a))
+			assert.Eventually(t, func() bool {
+				for _, key := range tc.wantEvicted {
+					if _, ok := localB.Get(key); ok {
+						return false
+					}
+				}
+				return true
+			}, time.Second, time.Millisecond*10)
+			for _, key := range tc.wantKept {
+				_, ok := localB.Get(key)
+				assert.True(t, ok, key)
+			}
+		})
+	}
+}
+
+func TestLocalCache(t *testing.T) {
+	testCases := []struct {
+		name     string
+		capacity int
+		ttl      time.Duration
+		sets     []string
+		// 在写入之后访问的 key，会变成最近访问的
+		gets     []string
+		wait     time.Duration
+		wantKeys []string
+		wantMiss []string
+	}{
+		{
+			name:     "超过容量淘汰最久没有访问的",
+			capacity: 2,
+			ttl:      time.Minute,
+			sets:     []string{"a", "b", "c"},
+			wantKeys: []string{"b", "c"},
+			wantMiss: []string{"a"},
+		},
+		{
+			name:     "访问过的不会被淘汰",
+			capacity: 2,
+			ttl:      time.Minute,
+			sets:     []string{"a", "b"},
+			gets:     []string{"a"},
+			wantKeys: []string{"a"},
+		},
+		{
+			name:     "过期",
+			capacity: 2,
+			ttl:      time.Millisecond * 10,
+			sets:     []string{"a"},
+			wait:     time.Millisecond * 20,
+			wantMiss: []string{"a"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewLocalCache(tc.capacity, tc.ttl)
+			for _, key := range tc.sets {
+				c.Set(key, key)
+			}
+			for _, key := range tc.gets {
+				c.Get(key)
+			}
+			if len(tc.gets) > 0 {
+				// 再写一个，淘汰掉没有访问的那个
+				c.Set("z", "z")
+			}
+			time.Sleep(tc.wait)
+			for _, key := range tc.wantKeys {
+				val, ok := c.Get(key)
+				assert.True(t, ok, key)
+				assert.Equal(t, key, val)
+			}
+			for _, key := range tc.wantMiss {
+				_, ok := c.Get(key)
+				assert.False(t, ok, key)
+			}
+		})
+	}
+}
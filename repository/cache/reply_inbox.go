@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// ReplyInboxCache 缓存每个用户"回复我的"未读数
+type ReplyInboxCache interface {
+	GetUnreadCount(ctx context.Context, uid int64) (int64, error)
+	SetUnreadCount(ctx context.Context, uid int64, count int64) error
+	// AddUnreadCountIfPresent 缓存不存在的时候不处理，下次读取的时候会重新统计
+	AddUnreadCountIfPresent(ctx context.Context, uid int64, delta int64) error
+	DelUnreadCount(ctx context.Context, uid int64) error
+}
+
+type RedisReplyInboxCache struct {
+	cmd redis.Cmdable
+}
+
+func NewRedisReplyInboxCache(cmd redis.Cmdable) ReplyInboxCache {
+	return &RedisReplyInboxCache{cmd: cmd}
+}
+
+func (cache *RedisReplyInboxCache) GetUnreadCount(ctx context.Context, uid int64) (int64, error) {
+	return cache.cmd.Get(ctx, replyUnreadCountKey(uid)).Int64()
+}
+
+func (cache *RedisReplyInboxCache) SetUnreadCount(ctx context.Context, uid int64, count int64) error {
+	return cache.cmd.Set(ctx, replyUnreadCountKey(uid), count, time.Minute*10).Err()
+}
+
+func (cache *RedisReplyInboxCache) AddUnreadCountIfPresent(ctx context.Context, uid int64, delta int64) error {
+	return cache.cmd.Eval(ctx, commentCntIncrLuaScript, []string{replyUnreadCountKey(uid)}, delta).Err()
+}
+
+func (cache *RedisReplyInboxCache) DelUnreadCount(ctx context.Context, uid int64) error {
+	return cache.cmd.Del(ctx, replyUnreadCountKey(uid)).Err()
+}
+
+func replyUnreadCountKey(uid int64) string {
+	return fmt.Sprintf("kstack:comment:reply_unread_count:%d", uid)
+}
@@ -4,39 +4,112 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	commentv1 "github.com/MuxiKeStack/be-api/gen/proto/comment/v1"
 	"github.com/MuxiKeStack/be-comment/domain"
 	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/MuxiKeStack/be-comment/pkg/richtext"
 	"github.com/MuxiKeStack/be-comment/repository/cache"
 	"github.com/MuxiKeStack/be-comment/repository/dao"
 	"github.com/ecodeclub/ekit/slice"
+	"golang.org/x/sync/singleflight"
+	"slices"
 	"time"
 )
 
 var (
 	ErrPermissionDenied = errors.New("没有该资源访问权限")
 	ErrCommentNotFound  = dao.ErrRecordNotFound
+	// ErrParentCommentNotFound 落库的时候父评论已经不存在了（比如被删除了）
+	ErrParentCommentNotFound = dao.ErrForeignKeyViolated
+	// ErrCommentIdConflict 评论的 id 已经被另一条评论占用了
+	ErrCommentIdConflict = dao.ErrIdConflict
 )
 
 type CommentRepository interface {
 	FindByBiz(ctx context.Context, biz commentv1.Biz, bizId int64, curCommentId int64, limit int64) ([]domain.Comment, error)
-	DeleteComment(ctx context.Context, commentId int64, uid int64) error
+	// DeleteComment 返回被删掉的评论（包括级联删除的回复）回复的人
+	DeleteComment(ctx context.Context, commentId int64, uid int64) ([]int64, error)
 	GetCountByBiz(ctx context.Context, biz commentv1.Biz, bizId int64) (int64, error)
 	GetMoreReplies(ctx context.Context, rid int64, curCommentId int64, limit int64) ([]domain.Comment, error)
-	CreateCommentAsync(ctx context.Context, comment domain.Comment) error
+	// BatchCreateComments 批量落库已经分配好 id 的评论，返回真正插入的（重复投递的会被跳过），
+	// id 被内容不同的评论占用的时候返回 ErrCommentIdConflict
+	BatchCreateComments(ctx context.Context, comments []domain.Comment) ([]domain.Comment, error)
 	FindById(ctx context.Context, commentId int64) (domain.Comment, error)
 	CreateCommentSync(ctx context.Context, comment domain.Comment) (int64, error)
+	// FindByUid 用户自己发表的评论，先新后旧
+	FindByUid(ctx context.Context, uid int64, biz commentv1.Biz, curCommentId int64, limit int64) ([]domain.Comment, error)
+	// FindByBizPage 从 cursor 往 dir 方向的一页根评论，按照 sort 排好序返回，cursor 为 nil 的时候是第一页
+	FindByBizPage(ctx context.Context, biz commentv1.Biz, bizId int64, sort domain.SortMode, cursor *domain.Cursor, dir domain.PageDirection, limit int64) ([]domain.Comment, error)
+	// FindRepliesPage 从 cursor 往 dir 方向的一页回复，按照 sort 排好序返回，cursor 为 nil 的时候是第一页
+	FindRepliesPage(ctx context.Context, rid int64, sort domain.SortMode, cursor *domain.Cursor, dir domain.PageDirection, limit int64) ([]domain.Comment, error)
+	// FindByBizSince 写入序号比 sinceSeq 大的根评论，后提交的在前，不包括 excludeUids 发表的
+	FindByBizSince(ctx context.Context, biz commentv1.Biz, bizId int64, sinceSeq int64, excludeUids []int64, limit int64) ([]domain.Comment, error)
+	CountByBizSince(ctx context.Context, biz commentv1.Biz, bizId int64, sinceSeq int64, excludeUids []int64) (int64, error)
+	// FindSiblings 和 anchor 同一个父评论的评论，按 id 升序返回
+	FindSiblings(ctx context.Context, anchor domain.Comment, before bool, limit int64) ([]domain.Comment, error)
+	// FindByFilter 按 id 升序遍历，curCommentId 为 0 的时候从头开始
+	FindByFilter(ctx context.Context, filter domain.CommentFilter, curCommentId int64, limit int64) ([]domain.Comment, error)
 }
 
 type CachedCommentRepo struct {
 	dao   dao.CommentDAO
 	cache cache.CommentCache
 	l     logger.Logger
+	sfg   singleflight.Group
 }
 
 func (repo *CachedCommentRepo) FindById(ctx context.Context, commentId int64) (domain.Comment, error) {
-	comment, err := repo.dao.FindById(ctx, commentId)
-	return repo.toDomain(comment), err
+	res, err := repo.cache.GetComment(ctx, commentId)
+	switch err {
+	case nil:
+		return res, nil
+	case cache.ErrNotFoundCached:
+		return domain.Comment{}, ErrCommentNotFound
+	case cache.ErrKeyNotExists:
+	default:
+		repo.l.Error("获取评论缓存失败",
+			logger.Error(err),
+			logger.Int64("commentId", commentId))
+	}
+	// 并发的缓存未命中只放一个请求去查数据库
+	val, err, _ := repo.sfg.Do(fmt.Sprintf("comment:%d", commentId), func() (any, error) {
+		ctx, cancel := sharedLoadContext(ctx)
+		defer cancel()
+		comment, er := repo.dao.FindById(ctx, commentId)
+		if er == dao.ErrRecordNotFound {
+			er = repo.cache.SetCommentNotFound(ctx, commentId)
+			if er != nil {
+				repo.l.Error("缓存评论不存在失败",
+					logger.Error(er),
+					logger.Int64("commentId", commentId))
+			}
+			return domain.Comment{}, ErrCommentNotFound
+		}
+		if er != nil {
+			return domain.Comment{}, er
+		}
+		c := toDomain(comment)
+		er = repo.cache.SetComment(ctx, c)
+		if er != nil {
+			repo.l.Error("回写评论缓存失败",
+				logger.Error(er),
+				logger.Int64("commentId", commentId))
+		}
+		return c, nil
+	})
+	if err != nil {
+		return domain.Comment{}, err
+	}
+	return val.(domain.Comment), nil
+}
+
+// singleflight 里面的查询是所有等待的请求共享的，不能跟着第一个请求一起被取消，
+// 不然第一个请求断开之后其他的请求都会拿到 context canceled，所以单独给一个超时时间
+const sharedLoadTimeout = time.Second * 3
+
+func sharedLoadContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), sharedLoadTimeout)
 }
 
 func NewCachedCommentRepo(dao dao.CommentDAO, cache cache.CommentCache, l logger.Logger) CommentRepository {
@@ -50,17 +123,41 @@ func NewCachedCommentRepo(dao dao.CommentDAO, cache cache.CommentCache, l logger
 func (repo *CachedCommentRepo) FindByBiz(ctx context.Context, biz commentv1.Biz, bizId int64, curCommentId int64, limit int64) ([]domain.Comment, error) {
 	daoComments, err := repo.dao.FindByBiz(ctx, int32(biz), bizId, curCommentId, limit)
 	return slice.Map(daoComments, func(idx int, src dao.Comment) domain.Comment {
-		return repo.toDomain(src)
+		return toDomain(src)
 	}), err
 }
 
-func (repo *CachedCommentRepo) CreateCommentAsync(ctx context.Context, comment domain.Comment) error {
-	// 这里可以做成异步
-	_, err := repo.dao.Insert(ctx, repo.toEntity(comment))
+func (repo *CachedCommentRepo) BatchCreateComments(ctx context.Context, comments []domain.Comment) ([]domain.Comment, error) {
+	entities := slice.Map(comments, func(idx int, src domain.Comment) dao.Comment {
+		return repo.toEntity(src)
+	})
+	inserted, err := repo.dao.BatchInsert(ctx, entities)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return repo.cache.IncrBizCommentCountIfPresent(ctx, int32(comment.Biz), comment.BizId)
+	deltas := make(map[dao.BizCount]int64)
+	for _, c := range inserted {
+		deltas[dao.BizCount{Biz: c.Biz, BizId: c.BizId}]++
+		// 发送之后、落库之前可能有人查过这个 id，缓存了不存在
+		er := repo.cache.DelComment(ctx, c.Id)
+		if er != nil {
+			repo.l.Error("删除评论缓存失败",
+				logger.Error(er),
+				logger.Int64("commentId", c.Id))
+		}
+	}
+	for key, delta := range deltas {
+		er := repo.cache.AddBizCommentCountIfPresent(ctx, key.Biz, key.BizId, delta)
+		if er != nil {
+			repo.l.Error("同步评论数缓存失败",
+				logger.Error(er),
+				logger.Int32("biz", key.Biz),
+				logger.Int64("bizId", key.BizId))
+		}
+	}
+	return slice.Map(inserted, func(idx int, src dao.Comment) domain.Comment {
+		return toDomain(src)
+	}), nil
 }
 
 func (repo *CachedCommentRepo) CreateCommentSync(ctx context.Context, comment domain.Comment) (int64, error) {
@@ -68,6 +165,13 @@ func (repo *CachedCommentRepo) CreateCommentSync(ctx context.Context, comment do
 	if err != nil {
 		return 0, err
 	}
+	// 之前可能缓存过这个 id 不存在
+	err = repo.cache.DelComment(ctx, commentId)
+	if err != nil {
+		repo.l.Error("删除评论缓存失败",
+			logger.Error(err),
+			logger.Int64("commentId", commentId))
+	}
 	err = repo.cache.IncrBizCommentCountIfPresent(ctx, int32(comment.Biz), comment.BizId)
 	if err != nil {
 		repo.l.Error("同步评论数缓存失败",
@@ -79,17 +183,31 @@ func (repo *CachedCommentRepo) CreateCommentSync(ctx context.Context, comment do
 	return commentId, nil
 }
 
-func (repo *CachedCommentRepo) DeleteComment(ctx context.Context, commentId int64, uid int64) error {
+func (repo *CachedCommentRepo) DeleteComment(ctx context.Context, commentId int64, uid int64) ([]int64, error) {
 	comment, err := repo.dao.FindById(ctx, commentId)
+	if err == dao.ErrRecordNotFound {
+		return nil, ErrCommentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
 	if comment.Uid != uid {
-		return ErrPermissionDenied
+		return nil, ErrPermissionDenied
 	}
 	// 要传入<biz,bizId>，因为delete也包括减少数目delete 'count'
-	err = repo.dao.Delete(ctx, commentId, comment.Biz, comment.BizId)
+	ids, replyToUids, err := repo.dao.Delete(ctx, commentId, comment.Biz, comment.BizId)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	// 级联删除的回复也要删掉，不然 FindById 还能查到它们，还能在它们下面回复
+	err = repo.cache.DelComments(ctx, ids...)
+	if err != nil {
+		repo.l.Error("删除评论缓存失败",
+			logger.Error(err),
+			logger.Int64("commentId", commentId),
+			logger.Int("count", len(ids)))
 	}
-	return repo.cache.DecrBizCommentCountIfPresent(ctx, comment.Biz, comment.BizId)
+	return replyToUids, repo.cache.AddBizCommentCountIfPresent(ctx, comment.Biz, comment.BizId, -int64(len(ids)))
 }
 
 func (repo *CachedCommentRepo) GetCountByBiz(ctx context.Context, biz commentv1.Biz, bizId int64) (int64, error) {
@@ -106,25 +224,32 @@ func (repo *CachedCommentRepo) GetCountByBiz(ctx context.Context, biz commentv1.
 		// 降级，保护住数据库
 		return 0, err
 	}
-	count, err = repo.dao.GetCountByBiz(ctx, int32(biz), bizId)
-	if err != nil {
-		return 0, err
-	}
-	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	// 并发的缓存未命中只放一个请求去查数据库，也只回写一次
+	val, err, _ := repo.sfg.Do(fmt.Sprintf("count:%d:%d", biz, bizId), func() (any, error) {
+		ctx, cancel := sharedLoadContext(ctx)
 		defer cancel()
-		er := repo.cache.SetBizCommentCount(ctx, int32(biz), bizId, count)
+		cnt, er := repo.dao.GetCountByBiz(ctx, int32(biz), bizId)
 		if er != nil {
+			return int64(0), er
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			er := repo.cache.SetBizCommentCount(ctx, int32(biz), bizId, cnt)
 			if er != nil {
 				repo.l.Error("回写评论数信息失败",
-					logger.Error(err),
+					logger.Error(er),
 					logger.Any("biz", biz.String()),
 					logger.Int64("bizId", bizId),
 				)
 			}
-		}
-	}()
-	return count, nil
+		}()
+		return cnt, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return val.(int64), nil
 }
 
 func (repo *CachedCommentRepo) GetMoreReplies(ctx context.Context, rid int64, curCommentId int64, limit int64) ([]domain.Comment, error) {
@@ -134,23 +259,166 @@ func (repo *CachedCommentRepo) GetMoreReplies(ctx context.Context, rid int64, cu
 	}
 	res := make([]domain.Comment, 0, len(cs))
 	for _, cm := range cs {
-		res = append(res, repo.toDomain(cm))
+		res = append(res, toDomain(cm))
 	}
 	return res, nil
 }
 
-func (repo *CachedCommentRepo) toDomain(daoComment dao.Comment) domain.Comment {
+func (repo *CachedCommentRepo) FindByUid(ctx context.Context, uid int64, biz commentv1.Biz,
+	curCommentId int64, limit int64) ([]domain.Comment, error) {
+	cs, err := repo.dao.FindByUid(ctx, uid, int32(biz), curCommentId, limit)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]domain.Comment, 0, len(cs))
+	for _, cm := range cs {
+		res = append(res, toDomain(cm))
+	}
+	return res, nil
+}
+
+func (repo *CachedCommentRepo) FindByBizPage(ctx context.Context, biz commentv1.Biz, bizId int64,
+	sort domain.SortMode, cursor *domain.Cursor, dir domain.PageDirection, limit int64) ([]domain.Comment, error) {
+	cs, err := repo.dao.FindByBizPage(ctx, int32(biz), bizId, toOrder(sort, dir), toDAOCursor(cursor), limit)
+	if err != nil {
+		return nil, err
+	}
+	return toPage(cs, dir), nil
+}
+
+func (repo *CachedCommentRepo) FindRepliesPage(ctx context.Context, rid int64,
+	sort domain.SortMode, cursor *domain.Cursor, dir domain.PageDirection, limit int64) ([]domain.Comment, error) {
+	cs, err := repo.dao.FindRepliesByRidPage(ctx, rid, toOrder(sort, dir), toDAOCursor(cursor), limit)
+	if err != nil {
+		return nil, err
+	}
+	return toPage(cs, dir), nil
+}
+
+func (repo *CachedCommentRepo) FindByBizSince(ctx context.Context, biz commentv1.Biz, bizId int64,
+	sinceSeq int64, excludeUids []int64, limit int64) ([]domain.Comment, error) {
+	cs, err := repo.dao.FindByBizSince(ctx, int32(biz), bizId, sinceSeq, excludeUids, limit)
+	if err != nil {
+		return nil, err
+	}
+	return slice.Map(cs, func(idx int, src dao.Comment) domain.Comment {
+		return toDomain(src)
+	}), nil
+}
+
+func (repo *CachedCommentRepo) CountByBizSince(ctx context.Context, biz commentv1.Biz, bizId int64,
+	sinceSeq int64, excludeUids []int64) (int64, error) {
+	return repo.dao.CountByBizSince(ctx, int32(biz), bizId, sinceSeq, excludeUids)
+}
+
+// toOrder 往前翻的时候反过来排序，从游标开始取离它最近的
+func toOrder(sort domain.SortMode, dir domain.PageDirection) dao.CommentOrder {
+	var order dao.CommentOrder
+	switch sort {
+	case domain.SortOldest:
+		order = dao.CommentOrder{Column: "ctime"}
+	case domain.SortRecentlyUpdated:
+		order = dao.CommentOrder{Column: "utime", Desc: true}
+	default:
+		order = dao.CommentOrder{Column: "ctime", Desc: true}
+	}
+	if dir == domain.PagePrev {
+		order.Desc = !order.Desc
+	}
+	return order
+}
+
+// toPage 往前翻查出来的是反着的，转回原来的顺序
+func toPage(cs []dao.Comment, dir domain.PageDirection) []domain.Comment {
+	res := slice.Map(cs, func(idx int, src dao.Comment) domain.Comment {
+		return toDomain(src)
+	})
+	if dir == domain.PagePrev {
+		slices.Reverse(res)
+	}
+	return res
+}
+
+func toDAOCursor(cursor *domain.Cursor) *dao.CommentCursor {
+	if cursor == nil {
+		return nil
+	}
+	return &dao.CommentCursor{Key: cursor.Key, Id: cursor.Id}
+}
+
+func (repo *CachedCommentRepo) FindSiblings(ctx context.Context, anchor domain.Comment,
+	before bool, limit int64) ([]domain.Comment, error) {
+	var pid int64
+	if anchor.ParentComment != nil {
+		pid = anchor.ParentComment.Id
+	}
+	cs, err := repo.dao.FindSiblings(ctx, int32(anchor.Biz), anchor.BizId, pid, anchor.Id, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]domain.Comment, len(cs))
+	for i, cm := range cs {
+		if before {
+			// 往前找的是倒序查出来的
+			res[len(cs)-1-i] = toDomain(cm)
+		} else {
+			res[i] = toDomain(cm)
+		}
+	}
+	return res, nil
+}
+
+func (repo *CachedCommentRepo) FindByFilter(ctx context.Context, filter domain.CommentFilter,
+	curCommentId int64, limit int64) ([]domain.Comment, error) {
+	cs, err := repo.dao.FindByFilter(ctx, toDAOFilter(filter), curCommentId, int(limit))
+	if err != nil {
+		return nil, err
+	}
+	res := make([]domain.Comment, 0, len(cs))
+	for _, cm := range cs {
+		res = append(res, toDomain(cm))
+	}
+	return res, nil
+}
+
+func toDAOFilter(filter domain.CommentFilter) dao.CommentFilter {
+	res := dao.CommentFilter{
+		Biz:   int32(filter.Biz),
+		BizId: filter.BizId,
+		Uid:   filter.Uid,
+	}
+	if !filter.StartTime.IsZero() {
+		res.StartTime = filter.StartTime.UnixMilli()
+	}
+	if !filter.EndTime.IsZero() {
+		res.EndTime = filter.EndTime.UnixMilli()
+	}
+	return res
+}
+
+func toDomain(daoComment dao.Comment) domain.Comment {
 	val := domain.Comment{
 		Id: daoComment.Id,
 		Commentator: domain.User{
 			ID: daoComment.Uid,
 		},
-		Biz:        commentv1.Biz(daoComment.Biz),
-		BizId:      daoComment.BizId,
-		Content:    daoComment.Content,
-		ReplyToUid: daoComment.ReplyToUid,
-		CTime:      time.UnixMilli(daoComment.Ctime),
-		UTime:      time.UnixMilli(daoComment.Utime),
+		Biz:         commentv1.Biz(daoComment.Biz),
+		BizId:       daoComment.BizId,
+		Content:     daoComment.Content,
+		Format:      domain.ContentFormat(daoComment.Format),
+		ContentHTML: daoComment.ContentHTML,
+		ReplyToUid:  daoComment.ReplyToUid,
+		CTime:       time.UnixMilli(daoComment.Ctime),
+		UTime:       time.UnixMilli(daoComment.Utime),
+		WriteSeq:    daoComment.WriteSeq,
+	}
+	if daoComment.AnonymousSeq > 0 {
+		val.Anonymous = true
+		val.AnonymousSeq = daoComment.AnonymousSeq
+	}
+	val.ReplyToAnonymousSeq = daoComment.ReplyToAnonymousSeq
+	if val.ContentHTML == "" {
+		val.ContentHTML = richtext.RenderPlain(val.Content)
 	}
 	if daoComment.PID.Valid {
 		val.ParentComment = &domain.Comment{
@@ -162,6 +430,18 @@ func (repo *CachedCommentRepo) toDomain(daoComment dao.Comment) domain.Comment {
 			Id: daoComment.RootID.Int64,
 		}
 	}
+	if len(daoComment.Attachments) > 0 {
+		val.Attachments = slice.Map(daoComment.Attachments, func(idx int, src dao.CommentAttachment) domain.Attachment {
+			return domain.Attachment{
+				ObjectKey: src.ObjectKey,
+				MimeType:  src.MimeType,
+				Size:      src.Size,
+				Width:     src.Width,
+				Height:    src.Height,
+				Checksum:  src.Checksum,
+			}
+		})
+	}
 	return val
 }
 
@@ -174,6 +454,11 @@ func (repo *CachedCommentRepo) toEntity(domainComment domain.Comment) dao.Commen
 		ReplyToUid:    domainComment.ReplyToUid,
 		ParentComment: nil,
 		Content:       domainComment.Content,
+		Format:        int32(domainComment.Format),
+		ContentHTML:   domainComment.ContentHTML,
+		AnonymousSeq:  domainComment.AnonymousSeq,
+		Ctime:         domainComment.CTime.UnixMilli(),
+		Utime:         domainComment.UTime.UnixMilli(),
 	}
 	if domainComment.RootComment != nil {
 		daoComment.RootID = sql.NullInt64{
@@ -183,9 +468,22 @@ func (repo *CachedCommentRepo) toEntity(domainComment domain.Comment) dao.Commen
 	}
 	if domainComment.ParentComment != nil {
 		daoComment.PID = sql.NullInt64{
-			Valid: domainComment.RootComment.Id != 0,
+			Valid: domainComment.ParentComment.Id != 0,
 			Int64: domainComment.ParentComment.Id,
 		}
 	}
+	daoComment.ReplyToAnonymousSeq = domainComment.ReplyToAnonymousSeq
+	daoComment.Attachments = slice.Map(domainComment.Attachments, func(idx int, src domain.Attachment) dao.CommentAttachment {
+		return dao.CommentAttachment{
+			Seq:       int32(idx),
+			ObjectKey: src.ObjectKey,
+			MimeType:  src.MimeType,
+			Size:      src.Size,
+			Width:     src.Width,
+			Height:    src.Height,
+			Checksum:  src.Checksum,
+			Ctime:     daoComment.Ctime,
+		}
+	})
 	return daoComment
 }
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/MuxiKeStack/be-comment/repository/dao"
+	"github.com/ecodeclub/ekit/slice"
+)
+
+type CommentSearchRepository interface {
+	// Search 内容包含所有关键词的评论，先新后旧
+	Search(ctx context.Context, keywords []string, filter domain.CommentFilter, curCommentId int64, limit int64) ([]domain.Comment, error)
+	Count(ctx context.Context, keywords []string, filter domain.CommentFilter) (int64, error)
+}
+
+type commentSearchRepository struct {
+	dao dao.CommentSearchDAO
+}
+
+func NewCommentSearchRepository(dao dao.CommentSearchDAO) CommentSearchRepository {
+	return &commentSearchRepository{dao: dao}
+}
+
+func (repo *commentSearchRepository) Search(ctx context.Context, keywords []string, filter domain.CommentFilter,
+	curCommentId int64, limit int64) ([]domain.Comment, error) {
+	cs, err := repo.dao.Search(ctx, dao.CommentSearchQuery{
+		Keywords: keywords,
+		Filter:   toDAOFilter(filter),
+		CurId:    curCommentId,
+		Limit:    limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return slice.Map(cs, func(idx int, src dao.Comment) domain.Comment {
+		return toDomain(src)
+	}), nil
+}
+
+func (repo *commentSearchRepository) Count(ctx context.Context, keywords []string, filter domain.CommentFilter) (int64, error) {
+	return repo.dao.Count(ctx, dao.CommentSearchQuery{
+		Keywords: keywords,
+		Filter:   toDAOFilter(filter),
+	})
+}
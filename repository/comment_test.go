@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/MuxiKeStack/be-comment/repository/cache"
+	"github.com/MuxiKeStack/be-comment/repository/dao"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingCommentDAO FindById 会一直阻塞到 release 被关闭，ctx 被取消的话返回 ctx.Err()
+type blockingCommentDAO struct {
+	dao.CommentDAO
+	started chan struct{}
+	release chan struct{}
+	calls   atomic.Int32
+}
+
+func (d *blockingCommentDAO) FindById(ctx context.Context, commentId int64) (dao.Comment, error) {
+	if d.calls.Add(1) == 1 {
+		close(d.started)
+	}
+	select {
+	case <-d.release:
+		return dao.Comment{Id: commentId, Content: "hello"}, nil
+	case <-ctx.Done():
+		return dao.Comment{}, ctx.Err()
+	}
+}
+
+func TestCachedCommentRepo_FindById_LeaderCanceled(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	d := &blockingCommentDAO{started: make(chan struct{}), release: make(chan struct{})}
+	repo := NewCachedCommentRepo(d, cache.NewRedisCommentCache(client), logger.NewNopLogger())
+
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := repo.FindById(leaderCtx, 1)
+		leaderErr <- err
+	}()
+	<-d.started
+	type result struct {
+		content string
+		err     error
+	}
+	follower := make(chan result, 1)
+	go func() {
+		c, err := repo.FindById(context.Background(), 1)
+		follower <- result{content: c.Content, err: err}
+	}()
+	// 等第二个请求挂到同一次查询上面
+	time.Sleep(time.Millisecond * 50)
+	// 第一个请求断开了，共享的查询不能跟着取消
+	cancel()
+	time.Sleep(time.Millisecond * 50)
+	close(d.release)
+
+	res := <-follower
+	require.NoError(t, res.err)
+	assert.Equal(t, "hello", res.content)
+	assert.NoError(t, <-leaderErr)
+	assert.Equal(t, int32(1), d.calls.Load())
+}
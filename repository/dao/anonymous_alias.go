@@ -0,0 +1,76 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+// 并发分配编号的时候抢输了就重新取一次最大编号，正常情况下一两次就够了
+const maxAliasAttempts = 16
+
+var ErrAliasConflict = errors.New("分配化名编号的时候冲突太多次")
+
+// AnonymousAliasDAO 匿名评论的化名编号，同一个楼（根评论和它下面所有的回复）里面按第一次匿名发言的先后从 1 开始编号
+type AnonymousAliasDAO interface {
+	// GetOrCreate 已经有编号的返回原来的编号
+	GetOrCreate(ctx context.Context, rootId int64, uid int64) (int32, error)
+}
+
+type GORMAnonymousAliasDAO struct {
+	db *gorm.DB
+}
+
+func NewAnonymousAliasDAO(db *gorm.DB) AnonymousAliasDAO {
+	return &GORMAnonymousAliasDAO{db: db}
+}
+
+// GetOrCreate 不加锁，靠 <root_id,seq> 和 <root_id,uid> 两个唯一索引保证编号不重复：
+// 插入冲突说明编号被别人抢了，或者同一个人并发发了两条，重新查一次再试
+func (dao *GORMAnonymousAliasDAO) GetOrCreate(ctx context.Context, rootId int64, uid int64) (int32, error) {
+	for i := 0; i < maxAliasAttempts; i++ {
+		seq, err := dao.find(ctx, rootId, uid)
+		if err == nil || !errors.Is(err, gorm.ErrRecordNotFound) {
+			return seq, err
+		}
+		var maxSeq int32
+		err = dao.db.WithContext(ctx).Model(&AnonymousAlias{}).
+			Where("root_id = ?", rootId).
+			Select("COALESCE(MAX(seq), 0)").
+			Scan(&maxSeq).Error
+		if err != nil {
+			return 0, err
+		}
+		err = dao.db.WithContext(ctx).Create(&AnonymousAlias{
+			RootId: rootId,
+			Uid:    uid,
+			Seq:    maxSeq + 1,
+			Ctime:  time.Now().UnixMilli(),
+		}).Error
+		if err == nil {
+			return maxSeq + 1, nil
+		}
+		if !errors.Is(err, ErrDuplicatedKey) {
+			return 0, err
+		}
+	}
+	return 0, ErrAliasConflict
+}
+
+func (dao *GORMAnonymousAliasDAO) find(ctx context.Context, rootId int64, uid int64) (int32, error) {
+	var alias AnonymousAlias
+	err := dao.db.WithContext(ctx).
+		Where("root_id = ? AND uid = ?", rootId, uid).
+		First(&alias).Error
+	return alias.Seq, err
+}
+
+type AnonymousAlias struct {
+	Id int64 `gorm:"primaryKey,autoIncrement"`
+	// 楼的根评论 id，根评论自己是匿名的时候就是它自己的 id
+	RootId int64 `gorm:"uniqueIndex:root_uid;uniqueIndex:root_seq"`
+	Uid    int64 `gorm:"uniqueIndex:root_uid"`
+	Seq    int32 `gorm:"uniqueIndex:root_seq"`
+	Ctime  int64
+}
@@ -0,0 +1,65 @@
+package dao
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// BlockDAO 用户之间的拉黑关系，uid 拉黑了 blocked_uid
+type BlockDAO interface {
+	// Block 重复拉黑不报错
+	Block(ctx context.Context, uid int64, blockedUid int64) error
+	Unblock(ctx context.Context, uid int64, blockedUid int64) error
+	// FindBlocked uid 拉黑的所有人
+	FindBlocked(ctx context.Context, uid int64) ([]int64, error)
+	IsBlocked(ctx context.Context, uid int64, blockedUid int64) (bool, error)
+}
+
+type GORMBlockDAO struct {
+	db *gorm.DB
+}
+
+func NewBlockDAO(db *gorm.DB) BlockDAO {
+	return &GORMBlockDAO{db: db}
+}
+
+func (dao *GORMBlockDAO) Block(ctx context.Context, uid int64, blockedUid int64) error {
+	return dao.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&UserBlock{
+			Uid:        uid,
+			BlockedUid: blockedUid,
+			Ctime:      time.Now().UnixMilli(),
+		}).Error
+}
+
+func (dao *GORMBlockDAO) Unblock(ctx context.Context, uid int64, blockedUid int64) error {
+	return dao.db.WithContext(ctx).
+		Where("uid = ? AND blocked_uid = ?", uid, blockedUid).
+		Delete(&UserBlock{}).Error
+}
+
+func (dao *GORMBlockDAO) FindBlocked(ctx context.Context, uid int64) ([]int64, error) {
+	var res []int64
+	err := dao.db.WithContext(ctx).Model(&UserBlock{}).
+		Where("uid = ?", uid).
+		Pluck("blocked_uid", &res).Error
+	return res, err
+}
+
+func (dao *GORMBlockDAO) IsBlocked(ctx context.Context, uid int64, blockedUid int64) (bool, error) {
+	var count int64
+	err := dao.db.WithContext(ctx).Model(&UserBlock{}).
+		Where("uid = ? AND blocked_uid = ?", uid, blockedUid).
+		Count(&count).Error
+	return count > 0, err
+}
+
+type UserBlock struct {
+	Id         int64 `gorm:"primaryKey,autoIncrement"`
+	Uid        int64 `gorm:"uniqueIndex:uid_blocked_uid"`
+	BlockedUid int64 `gorm:"uniqueIndex:uid_blocked_uid"`
+	Ctime      int64
+}
@@ -4,23 +4,61 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
+	"slices"
+	"sort"
 	"time"
 )
 
-var ErrRecordNotFound = gorm.ErrRecordNotFound
+var (
+	ErrRecordNotFound = gorm.ErrRecordNotFound
+	// ErrForeignKeyViolated 需要开启 gorm 的 TranslateError
+	ErrForeignKeyViolated = gorm.ErrForeignKeyViolated
+	ErrDuplicatedKey      = gorm.ErrDuplicatedKey
+	// ErrIdConflict 要插入的评论的 id 已经被另一条内容不同的评论占用了
+	ErrIdConflict = errors.New("评论 id 冲突")
+)
 
 type CommentDAO interface {
 	FindByBiz(ctx context.Context, biz int32, bizId int64, curCommentId int64, limit int64) ([]Comment, error)
 	FindRepliesByPid(ctx context.Context, pid int64, offset int, limit int) ([]Comment, error)
-	Delete(ctx context.Context, commentId int64, biz int32, bizId int64) error
+	// Delete 删除评论，回复会被外键级联删除，返回被删掉的所有评论的 id（包括回复），
+	// 以及这些评论回复的人，去重之后的
+	Delete(ctx context.Context, commentId int64, biz int32, bizId int64) ([]int64, []int64, error)
 	GetCountByBiz(ctx context.Context, biz int32, bizId int64) (int64, error)
 	FindRepliesByRid(ctx context.Context, rid int64, curCommentId int64, limit int64) ([]Comment, error)
 	Insert(ctx context.Context, comment Comment) (int64, error)
 	// 这个是为了迁移脚本而增加的方法,ctime,utime外界来传入
 	InsertWithTime(ctx context.Context, comment Comment) (int64, error)
+	// BatchInsert 批量插入已经分配好 id 和时间的评论，并按 <biz,bizId> 汇总增加评论数。
+	// 已经存在并且内容相同的会被跳过，返回真正插入的评论；
+	// id 已经存在但是内容不同的，整批都不插入，返回 ErrIdConflict
+	BatchInsert(ctx context.Context, comments []Comment) ([]Comment, error)
 	FindById(ctx context.Context, commentId int64) (Comment, error)
+	// CountGroupByBizAfter 统计 id 比 afterId 大的 limit 条评论，按 <biz,bizId> 分组，
+	// 同时返回这些评论里面最大的 id，作为下一次的 afterId，没有评论的时候返回 0
+	CountGroupByBizAfter(ctx context.Context, afterId int64, limit int) ([]BizCount, int64, error)
+	// FindBizCommentCounts 按 id 升序遍历评论数表
+	FindBizCommentCounts(ctx context.Context, curId int64, limit int) ([]BizCommentCount, error)
+	// ReconcileBizCommentCount 用 comments 表重新统计 <biz,bizId> 的评论数并修正计数表，返回修正前后的值
+	ReconcileBizCommentCount(ctx context.Context, biz int32, bizId int64) (int64, int64, error)
+	// FindByUid 某个用户发表的评论，先新后旧，biz 为 0 表示不限制
+	FindByUid(ctx context.Context, uid int64, biz int32, curCommentId int64, limit int64) ([]Comment, error)
+	// FindByBizPage 按照 order 排序的一页根评论，cursor 为 nil 的时候是第一页
+	FindByBizPage(ctx context.Context, biz int32, bizId int64, order CommentOrder, cursor *CommentCursor, limit int64) ([]Comment, error)
+	// FindRepliesByRidPage 按照 order 排序的一页回复，cursor 为 nil 的时候是第一页
+	FindRepliesByRidPage(ctx context.Context, rid int64, order CommentOrder, cursor *CommentCursor, limit int64) ([]Comment, error)
+	// FindByBizSince 写入序号比 sinceSeq 大的根评论，后提交的在前，不包括 excludeUids 发表的
+	FindByBizSince(ctx context.Context, biz int32, bizId int64, sinceSeq int64, excludeUids []int64, limit int64) ([]Comment, error)
+	// CountByBizSince 写入序号比 sinceSeq 大的根评论的数量，不包括 excludeUids 发表的
+	CountByBizSince(ctx context.Context, biz int32, bizId int64, sinceSeq int64, excludeUids []int64) (int64, error)
+	// FindSiblings 和 anchorId 同一个父评论的评论，pid 为 0 的时候是同一个 <biz,bizId> 下面的根评论。
+	// before 为 true 的时候找 id 比 anchorId 小的，否则找大的，都是离 anchorId 近的优先
+	FindSiblings(ctx context.Context, biz int32, bizId int64, pid int64, anchorId int64, before bool, limit int64) ([]Comment, error)
+	// FindByFilter 按 id 升序遍历符合条件的评论，父评论一定排在回复的前面
+	FindByFilter(ctx context.Context, filter CommentFilter, curId int64, limit int) ([]Comment, error)
 }
 
 type GORMCommentDAO struct {
@@ -28,33 +66,77 @@ type GORMCommentDAO struct {
 }
 
 func (dao *GORMCommentDAO) InsertWithTime(ctx context.Context, c Comment) (int64, error) {
-	now := time.Now().UnixMilli()
 	err := dao.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		// 插入评论
-		err := tx.Create(&c).Error
+		// 增加评论计数，同时分配写入序号
+		cs := []Comment{c}
+		err := assignWriteSeqs(tx, cs, time.Now().UnixMilli())
 		if err != nil {
 			return err
 		}
-		// 增加评论计数
-		return tx.Clauses(
-			clause.OnConflict{
-				DoUpdates: clause.Assignments(map[string]any{
-					"utime": now,
-					"count": gorm.Expr("`count` + 1"),
-				})}).Create(&BizCommentCount{
-			Biz:   c.Biz,
-			BizID: c.BizId,
-			Count: 1,
-			Ctime: now,
-			Utime: now,
-		}).Error
+		// 插入评论
+		return tx.Create(&cs[0]).Error
 	})
 	return c.Id, err
 }
 
+func (dao *GORMCommentDAO) BatchInsert(ctx context.Context, comments []Comment) ([]Comment, error) {
+	if len(comments) == 0 {
+		return nil, nil
+	}
+	var inserted []Comment
+	err := dao.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		ids := make([]int64, 0, len(comments))
+		for _, c := range comments {
+			ids = append(ids, c.Id)
+		}
+		// 消息可能重复投递，已经插入过的跳过，不然评论数会被重复累加
+		var existing []Comment
+		err := tx.Where("id IN ?", ids).Find(&existing).Error
+		if err != nil {
+			return err
+		}
+		existingMap := make(map[int64]Comment, len(existing))
+		for _, c := range existing {
+			existingMap[c.Id] = c
+		}
+		for _, c := range comments {
+			if e, ok := existingMap[c.Id]; ok {
+				// 只有内容完全一样才是重复投递，否则是 id 撞了，不能悄悄丢掉
+				if !samePayload(e, c) {
+					return fmt.Errorf("%w: %d", ErrIdConflict, c.Id)
+				}
+				continue
+			}
+			// 同一批次里面重复的也跳过
+			existingMap[c.Id] = c
+			inserted = append(inserted, c)
+		}
+		if len(inserted) == 0 {
+			return nil
+		}
+		// 先更新计数、分配写入序号再插入
+		err = assignWriteSeqs(tx, inserted, time.Now().UnixMilli())
+		if err != nil {
+			return err
+		}
+		return tx.Create(&inserted).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return inserted, nil
+}
+
+// samePayload 比较评论本身的字段，附件以外的都一样就认为是同一条
+func samePayload(a, b Comment) bool {
+	return a.Uid == b.Uid && a.Biz == b.Biz && a.BizId == b.BizId &&
+		a.RootID == b.RootID && a.PID == b.PID && a.ReplyToUid == b.ReplyToUid &&
+		a.Content == b.Content && a.Ctime == b.Ctime
+}
+
 func (dao *GORMCommentDAO) FindById(ctx context.Context, commentId int64) (Comment, error) {
 	var c Comment
-	err := dao.db.WithContext(ctx).
+	err := dao.db.WithContext(ctx).Scopes(withAttachments).
 		Where("id = ?", commentId).
 		First(&c).Error
 	return c, err
@@ -66,19 +148,101 @@ func NewCommentDAO(db *gorm.DB) CommentDAO {
 	}
 }
 
-// FindByBiz 先新后旧
+// FindByBiz 先新后旧，id 随时间递增，按 id 排序才和 id < curCommentId 的游标对得上，
+// 按 utime 排序的话翻页会漏掉或者重复。要按修改时间排序用 FindByBizPage
 func (dao *GORMCommentDAO) FindByBiz(ctx context.Context, biz int32, bizId int64, curCommentId int64, limit int64) ([]Comment, error) {
 	var res []Comment
-	err := dao.db.WithContext(ctx).
+	err := dao.db.WithContext(ctx).Scopes(withAttachments).
 		Where("biz = ? AND biz_id = ? AND id < ? AND pid IS NULL", biz, bizId, curCommentId).
-		Order("utime desc").
+		Order("id DESC").
 		Limit(int(limit)).
 		Find(&res).Error
 	return res, err
 }
 
-func (dao *GORMCommentDAO) Delete(ctx context.Context, commentId int64, biz int32, bizId int64) error {
-	return dao.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+func (dao *GORMCommentDAO) FindByBizPage(ctx context.Context, biz int32, bizId int64,
+	order CommentOrder, cursor *CommentCursor, limit int64) ([]Comment, error) {
+	var res []Comment
+	query := dao.db.WithContext(ctx).Scopes(withAttachments).
+		Where("biz = ? AND biz_id = ? AND pid IS NULL", biz, bizId)
+	err := page(query, order, cursor, limit).Find(&res).Error
+	return res, err
+}
+
+func (dao *GORMCommentDAO) FindRepliesByRidPage(ctx context.Context, rid int64,
+	order CommentOrder, cursor *CommentCursor, limit int64) ([]Comment, error) {
+	var res []Comment
+	query := dao.db.WithContext(ctx).Scopes(withAttachments).Where("root_id = ?", rid)
+	err := page(query, order, cursor, limit).Find(&res).Error
+	return res, err
+}
+
+// FindByBizSince 写入序号比 sinceSeq 大的就是之后提交的，excludeUids 的评论不要
+func (dao *GORMCommentDAO) FindByBizSince(ctx context.Context, biz int32, bizId int64,
+	sinceSeq int64, excludeUids []int64, limit int64) ([]Comment, error) {
+	var res []Comment
+	err := dao.since(ctx, biz, bizId, sinceSeq, excludeUids).Scopes(withAttachments).
+		Order("write_seq DESC").
+		Limit(int(limit)).
+		Find(&res).Error
+	return res, err
+}
+
+func (dao *GORMCommentDAO) CountByBizSince(ctx context.Context, biz int32, bizId int64,
+	sinceSeq int64, excludeUids []int64) (int64, error) {
+	var count int64
+	err := dao.since(ctx, biz, bizId, sinceSeq, excludeUids).
+		Count(&count).Error
+	return count, err
+}
+
+func (dao *GORMCommentDAO) since(ctx context.Context, biz int32, bizId int64,
+	sinceSeq int64, excludeUids []int64) *gorm.DB {
+	query := dao.db.WithContext(ctx).Model(&Comment{}).
+		Where("biz = ? AND biz_id = ? AND write_seq > ? AND pid IS NULL", biz, bizId, sinceSeq)
+	if len(excludeUids) > 0 {
+		query = query.Where("uid NOT IN ?", excludeUids)
+	}
+	return query
+}
+
+// withAttachments 查出来的评论都要带上附件，多一次按 comment_id IN 的查询
+func withAttachments(db *gorm.DB) *gorm.DB {
+	return db.Preload("Attachments", func(db *gorm.DB) *gorm.DB {
+		return db.Order("seq ASC")
+	})
+}
+
+// page 按照 (排序字段, id) 翻页，排序字段相同的评论靠 id 区分，不会漏掉也不会重复
+func page(query *gorm.DB, order CommentOrder, cursor *CommentCursor, limit int64) *gorm.DB {
+	col := order.column()
+	op, dir := ">", "ASC"
+	if order.Desc {
+		op, dir = "<", "DESC"
+	}
+	if cursor != nil {
+		query = query.Where(fmt.Sprintf("((%s %s ?) OR (%s = ? AND id %s ?))", col, op, col, op),
+			cursor.Key, cursor.Key, cursor.Id)
+	}
+	return query.Order(col + " " + dir).Order("id " + dir).Limit(int(limit))
+}
+
+func (dao *GORMCommentDAO) Delete(ctx context.Context, commentId int64, biz int32, bizId int64) ([]int64, []int64, error) {
+	var ids, replyToUids []int64
+	err := dao.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 级联删除之前先把子孙评论都找出来，调用方要清理它们的缓存
+		descendants, err := descendantIds(tx, commentId)
+		if err != nil {
+			return err
+		}
+		// 被回复的人都少了回复，调用方要让这些人的未读数重新统计
+		err = tx.Model(&Comment{}).
+			Where("id IN ? AND reply_to_uid > 0", append([]int64{commentId}, descendants...)).
+			Distinct().
+			Pluck("reply_to_uid", &replyToUids).Error
+		if err != nil {
+			return err
+		}
 		// 删除评论
 		res := tx.Where("id = ?", commentId).
 			Delete(&Comment{})
@@ -88,13 +252,38 @@ func (dao *GORMCommentDAO) Delete(ctx context.Context, commentId int64, biz int3
 		if res.RowsAffected == 0 {
 			return errors.New("删除失败")
 		}
+		ids = append([]int64{commentId}, descendants...)
 		return tx.Model(&BizCommentCount{}).
 			Where("biz = ? and biz_id = ?", biz, bizId).
 			Updates(map[string]any{
 				"utime": time.Now().UnixMilli(),
-				"count": gorm.Expr("`count` - 1"),
+				"count": gorm.Expr("`count` - ?", len(ids)),
 			}).Error
 	})
+	return ids, replyToUids, err
+}
+
+// descendantIds 一层一层地找出所有子孙评论，找的同时加上锁，
+// 这样在删除之前不会有新的回复插到这些评论下面（插入的时候外键检查要拿父评论的共享锁）
+func descendantIds(tx *gorm.DB, commentId int64) ([]int64, error) {
+	var res []int64
+	frontier := []int64{commentId}
+	for len(frontier) > 0 {
+		var next []int64
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Model(&Comment{}).
+			Where("id IN ? OR pid IN ?", frontier, frontier).
+			Pluck("id", &next).Error
+		if err != nil {
+			return nil, err
+		}
+		// 上一层的评论自己也会被查出来，只是为了加锁
+		next = slices.DeleteFunc(next, func(id int64) bool {
+			return slices.Contains(frontier, id)
+		})
+		res = append(res, next...)
+		frontier = next
+	}
+	return res, nil
 }
 
 func (dao *GORMCommentDAO) GetCountByBiz(ctx context.Context, biz int32, bizId int64) (int64, error) {
@@ -112,7 +301,7 @@ func (dao *GORMCommentDAO) GetCountByBiz(ctx context.Context, biz int32, bizId i
 func (dao *GORMCommentDAO) FindRepliesByRid(ctx context.Context,
 	rid int64, curCommentId int64, limit int64) ([]Comment, error) {
 	var res []Comment
-	err := dao.db.WithContext(ctx).
+	err := dao.db.WithContext(ctx).Scopes(withAttachments).
 		Where("root_id = ? AND id > ?", rid, curCommentId).
 		Order("id ASC").
 		Limit(int(limit)).Find(&res).Error
@@ -122,7 +311,7 @@ func (dao *GORMCommentDAO) FindRepliesByRid(ctx context.Context,
 // FindRepliesByPid 查找评论的直接评论
 func (dao *GORMCommentDAO) FindRepliesByPid(ctx context.Context, pid int64, offset, limit int) ([]Comment, error) {
 	var res []Comment
-	err := dao.db.WithContext(ctx).Where("pid = ?", pid).
+	err := dao.db.WithContext(ctx).Scopes(withAttachments).Where("pid = ?", pid).
 		Order("id DESC").
 		Offset(offset).Limit(limit).Find(&res).Error
 	return res, err
@@ -132,27 +321,224 @@ func (dao *GORMCommentDAO) Insert(ctx context.Context, c Comment) (int64, error)
 	now := time.Now().UnixMilli()
 	c.Utime = now
 	c.Ctime = now
+	for i := range c.Attachments {
+		c.Attachments[i].Ctime = now
+	}
 	err := dao.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 增加评论计数，同时分配写入序号
+		cs := []Comment{c}
+		err := assignWriteSeqs(tx, cs, now)
+		if err != nil {
+			return err
+		}
 		// 插入评论
-		err := tx.Create(&c).Error
+		return tx.Create(&cs[0]).Error
+	})
+	return c.Id, err
+}
+
+// assignWriteSeqs 增加 <biz,bizId> 的评论数，并且给评论分配 <biz,bizId> 内的写入序号；
+// 回复别人的评论再分配被回复的人的"回复我的"里面的序号。
+// 计数的行锁一直持有到事务提交，所以序号小的评论一定先提交，按序号往后取不会漏掉晚提交的评论，
+// 按 id 就不行：异步落库的时候 id 小的评论可能更晚才写进来。
+// 按固定的顺序加锁，先 <biz,bizId> 再被回复的人，避免并发的事务之间死锁
+func assignWriteSeqs(tx *gorm.DB, cs []Comment, now int64) error {
+	bizIdx := make(map[BizCount][]int)
+	replyIdx := make(map[int64][]int)
+	for i, c := range cs {
+		key := BizCount{Biz: c.Biz, BizId: c.BizId}
+		bizIdx[key] = append(bizIdx[key], i)
+		if c.ReplyToUid > 0 && c.ReplyToUid != c.Uid {
+			replyIdx[c.ReplyToUid] = append(replyIdx[c.ReplyToUid], i)
+		}
+	}
+	keys := make([]BizCount, 0, len(bizIdx))
+	for key := range bizIdx {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Biz != keys[j].Biz {
+			return keys[i].Biz < keys[j].Biz
+		}
+		return keys[i].BizId < keys[j].BizId
+	})
+	for _, key := range keys {
+		idx := bizIdx[key]
+		n := int64(len(idx))
+		err := tx.Clauses(
+			clause.OnConflict{
+				DoUpdates: clause.Assignments(map[string]any{
+					"utime":     now,
+					"count":     gorm.Expr("`count` + ?", n),
+					"write_seq": gorm.Expr("`write_seq` + ?", n),
+				})}).Create(&BizCommentCount{
+			Biz:      key.Biz,
+			BizID:    key.BizId,
+			Count:    n,
+			WriteSeq: n,
+			Ctime:    now,
+			Utime:    now,
+		}).Error
+		if err != nil {
+			return err
+		}
+		var last int64
+		err = tx.Model(&BizCommentCount{}).
+			Where("biz = ? and biz_id = ?", key.Biz, key.BizId).
+			Pluck("write_seq", &last).Error
 		if err != nil {
 			return err
 		}
-		// 增加评论计数
+		for k, i := range idx {
+			cs[i].WriteSeq = last - n + int64(k) + 1
+		}
+	}
+	uids := make([]int64, 0, len(replyIdx))
+	for uid := range replyIdx {
+		uids = append(uids, uid)
+	}
+	slices.Sort(uids)
+	for _, uid := range uids {
+		idx := replyIdx[uid]
+		n := int64(len(idx))
+		err := tx.Clauses(
+			clause.OnConflict{
+				DoUpdates: clause.Assignments(map[string]any{
+					"utime":    now,
+					"last_seq": gorm.Expr("`last_seq` + ?", n),
+				})}).Create(&ReplyInbox{
+			Uid:     uid,
+			LastSeq: n,
+			Ctime:   now,
+			Utime:   now,
+		}).Error
+		if err != nil {
+			return err
+		}
+		var last int64
+		err = tx.Model(&ReplyInbox{}).
+			Where("uid = ?", uid).
+			Pluck("last_seq", &last).Error
+		if err != nil {
+			return err
+		}
+		for k, i := range idx {
+			cs[i].ReplySeq = last - n + int64(k) + 1
+		}
+	}
+	return nil
+}
+
+// CountGroupByBizAfter id 是雪花算法生成的，很稀疏，不能按 id 区间扫描，
+// 只能按主键往后取 limit 条再分组统计
+func (dao *GORMCommentDAO) CountGroupByBizAfter(ctx context.Context, afterId int64, limit int) ([]BizCount, int64, error) {
+	db := dao.db.WithContext(ctx)
+	sub := db.Model(&Comment{}).
+		Select("id, biz, biz_id").
+		Where("id > ?", afterId).
+		Order("id ASC").
+		Limit(limit)
+	var rows []struct {
+		Biz   int32
+		BizId int64
+		Count int64
+		MaxId int64
+	}
+	err := db.Table("(?) AS t", sub).
+		Select("biz, biz_id, COUNT(*) AS count, MAX(id) AS max_id").
+		Group("biz, biz_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	var lastId int64
+	res := make([]BizCount, 0, len(rows))
+	for _, row := range rows {
+		lastId = max(lastId, row.MaxId)
+		res = append(res, BizCount{Biz: row.Biz, BizId: row.BizId, Count: row.Count})
+	}
+	return res, lastId, nil
+}
+
+func (dao *GORMCommentDAO) FindBizCommentCounts(ctx context.Context, curId int64, limit int) ([]BizCommentCount, error) {
+	var res []BizCommentCount
+	err := dao.db.WithContext(ctx).
+		Where("id > ?", curId).
+		Order("id ASC").
+		Limit(limit).
+		Find(&res).Error
+	return res, err
+}
+
+func (dao *GORMCommentDAO) ReconcileBizCommentCount(ctx context.Context, biz int32, bizId int64) (int64, int64, error) {
+	var before, after int64
+	err := dao.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 先锁住计数行，避免重新统计的过程中并发的插入删除把计数改掉
+		var bc BizCommentCount
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("biz = ? AND biz_id = ?", biz, bizId).
+			First(&bc).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		before = bc.Count
+		err = tx.Model(&Comment{}).
+			Where("biz = ? AND biz_id = ?", biz, bizId).
+			Count(&after).Error
+		if err != nil {
+			return err
+		}
+		now := time.Now().UnixMilli()
 		return tx.Clauses(
 			clause.OnConflict{
 				DoUpdates: clause.Assignments(map[string]any{
 					"utime": now,
-					"count": gorm.Expr("`count` + 1"),
+					"count": after,
 				})}).Create(&BizCommentCount{
-			Biz:   c.Biz,
-			BizID: c.BizId,
-			Count: 1,
+			Biz:   biz,
+			BizID: bizId,
+			Count: after,
 			Ctime: now,
 			Utime: now,
 		}).Error
 	})
-	return c.Id, err
+	return before, after, err
+}
+
+func (dao *GORMCommentDAO) FindByUid(ctx context.Context, uid int64, biz int32, curCommentId int64, limit int64) ([]Comment, error) {
+	// uid 索引里面带着主键，按 id 倒序可以直接走索引
+	query := dao.db.WithContext(ctx).Scopes(withAttachments).Where("uid = ? AND id < ?", uid, curCommentId)
+	if biz != 0 {
+		query = query.Where("biz = ?", biz)
+	}
+	var res []Comment
+	err := query.Order("id DESC").Limit(int(limit)).Find(&res).Error
+	return res, err
+}
+
+func (dao *GORMCommentDAO) FindSiblings(ctx context.Context, biz int32, bizId int64, pid int64,
+	anchorId int64, before bool, limit int64) ([]Comment, error) {
+	query := dao.db.WithContext(ctx).Scopes(withAttachments)
+	if pid == 0 {
+		query = query.Where("biz = ? AND biz_id = ? AND pid IS NULL", biz, bizId)
+	} else {
+		query = query.Where("pid = ?", pid)
+	}
+	if before {
+		query = query.Where("id < ?", anchorId).Order("id DESC")
+	} else {
+		query = query.Where("id > ?", anchorId).Order("id ASC")
+	}
+	var res []Comment
+	err := query.Limit(int(limit)).Find(&res).Error
+	return res, err
+}
+
+func (dao *GORMCommentDAO) FindByFilter(ctx context.Context, filter CommentFilter, curId int64, limit int) ([]Comment, error) {
+	query := filter.apply(dao.db.WithContext(ctx).Scopes(withAttachments).Where("id > ?", curId))
+	var res []Comment
+	err := query.Order("id ASC").Limit(limit).Find(&res).Error
+	return res, err
 }
 
 type Comment struct {
@@ -166,24 +552,111 @@ type Comment struct {
 	// 根评论为0表示一级评论
 	RootID sql.NullInt64 `gorm:"column:root_id;index" json:"rootID"`
 	// 父级评论
-	PID        sql.NullInt64 `gorm:"column:pid;index" json:"pid"`
-	ReplyToUid int64         `json:"reply_to_uid"`
+	PID sql.NullInt64 `gorm:"column:pid;index" json:"pid"`
+	// 被回复的人，"回复我的"按照它查询
+	ReplyToUid int64 `gorm:"column:reply_to_uid;index" json:"reply_to_uid"`
 	// 外键 用于级联删除
 	ParentComment *Comment `gorm:"ForeignKey:PID;AssociationForeignKey:ID;constraint:OnDelete:CASCADE"`
-	// 评论内容
-	Content string `gorm:"type:text;column:content" json:"content"`
+	// 附件存在子表里面，插入评论的时候一起插入，删除评论的时候级联删除
+	Attachments []CommentAttachment `gorm:"foreignKey:CommentId;constraint:OnDelete:CASCADE"`
+	// 评论内容，内容大多是中文，全文索引用 ngram 分词
+	Content string `gorm:"type:text;column:content;index:idx_content_fulltext,class:FULLTEXT,option:WITH PARSER ngram" json:"content"`
+	// 匿名评论的化名编号，0 表示不是匿名评论，真实的 uid 仍然记在 Uid 里面
+	AnonymousSeq int32 `gorm:"column:anonymous_seq" json:"anonymous_seq"`
+	// 被回复的评论是匿名评论的时候，对方的化名编号
+	ReplyToAnonymousSeq int32 `gorm:"column:reply_to_anonymous_seq" json:"reply_to_anonymous_seq"`
+	// <biz,bizId> 里面的写入序号，按提交的先后递增，见 assignWriteSeqs
+	WriteSeq int64 `gorm:"column:write_seq;index" json:"write_seq"`
+	// 被回复的人的"回复我的"里面的写入序号，不是回复别人的评论为 0
+	ReplySeq int64 `gorm:"column:reply_seq" json:"reply_seq"`
+	// 内容格式，0 是纯文本
+	Format int32 `gorm:"column:format" json:"format"`
+	// 渲染之后的 HTML，老数据是空的，读的时候按纯文本渲染
+	ContentHTML string `gorm:"type:text;column:content_html" json:"content_html"`
 	// 创建时间
 	Ctime int64 `gorm:"column:ctime;" json:"ctime"`
 	// 更新时间
 	Utime int64 `gorm:"column:utime;" json:"utime"`
 }
 
+// CommentAttachment 评论的附件
+type CommentAttachment struct {
+	Id        int64 `gorm:"primaryKey,autoIncrement"`
+	CommentId int64 `gorm:"index"`
+	// 在评论里面的顺序，从 0 开始
+	Seq       int32
+	ObjectKey string `gorm:"type:varchar(255)"`
+	MimeType  string `gorm:"type:varchar(128)"`
+	Size      int64
+	Width     int32
+	Height    int32
+	Checksum  string `gorm:"type:varchar(128)"`
+	Ctime     int64
+}
+
 // BizCommentCount 做一个comment数量的维护，因为这个东西频率比较高
 type BizCommentCount struct {
 	ID    int64 `gorm:"primaryKey"`
 	Biz   int32 `gorm:"uniqueIndex:biz_bizId"` // 业务类型
 	BizID int64 `gorm:"uniqueIndex:biz_bizId"` // 业务ID
 	Count int64 // 评论数量
-	Ctime int64
-	Utime int64
+	// 分配给评论的最大写入序号，只增不减
+	WriteSeq int64
+	Ctime    int64
+	Utime    int64
+}
+
+// CommentFilter 零值表示不限制，时间都是毫秒
+type CommentFilter struct {
+	Biz       int32
+	BizId     int64
+	Uid       int64
+	StartTime int64
+	EndTime   int64
+}
+
+func (f CommentFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.Biz != 0 {
+		query = query.Where("biz = ?", f.Biz)
+	}
+	if f.BizId != 0 {
+		query = query.Where("biz_id = ?", f.BizId)
+	}
+	if f.Uid != 0 {
+		query = query.Where("uid = ?", f.Uid)
+	}
+	if f.StartTime != 0 {
+		query = query.Where("ctime >= ?", f.StartTime)
+	}
+	if f.EndTime != 0 {
+		query = query.Where("ctime < ?", f.EndTime)
+	}
+	return query
+}
+
+// CommentOrder 翻页的排序方式
+type CommentOrder struct {
+	// 只能是 ctime 或者 utime，其他的值都按 ctime 处理
+	Column string
+	Desc   bool
+}
+
+func (o CommentOrder) column() string {
+	if o.Column == "utime" {
+		return "utime"
+	}
+	return "ctime"
+}
+
+// CommentCursor 上一页最后一条评论的排序字段和 id
+type CommentCursor struct {
+	Key int64
+	Id  int64
+}
+
+// BizCount 按 <biz,bizId> 分组统计出来的评论数
+type BizCount struct {
+	Biz   int32
+	BizId int64
+	Count int64
 }
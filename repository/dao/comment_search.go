@@ -0,0 +1,74 @@
+package dao
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// CommentSearchQuery 搜索条件，评论内容要包含所有的关键词
+type CommentSearchQuery struct {
+	Keywords []string
+	Filter   CommentFilter
+	// 翻页用的游标，只返回 id 比它小的
+	CurId int64
+	Limit int64
+}
+
+// CommentSearchDAO 评论的全文检索，线上用 MySQL 的全文索引，
+// 测试的时候可以换成进程内的倒排索引 MemoryCommentSearchDAO
+type CommentSearchDAO interface {
+	// Search 先新后旧
+	Search(ctx context.Context, q CommentSearchQuery) ([]Comment, error)
+	// Count 符合条件的评论总数，不考虑游标
+	Count(ctx context.Context, q CommentSearchQuery) (int64, error)
+}
+
+type GORMCommentSearchDAO struct {
+	db *gorm.DB
+}
+
+func NewCommentSearchDAO(db *gorm.DB) CommentSearchDAO {
+	return &GORMCommentSearchDAO{db: db}
+}
+
+func (dao *GORMCommentSearchDAO) Search(ctx context.Context, q CommentSearchQuery) ([]Comment, error) {
+	var res []Comment
+	err := dao.match(ctx, q).Scopes(withAttachments).
+		Where("id < ?", q.CurId).
+		Order("id DESC").
+		Limit(int(q.Limit)).
+		Find(&res).Error
+	return res, err
+}
+
+func (dao *GORMCommentSearchDAO) Count(ctx context.Context, q CommentSearchQuery) (int64, error) {
+	var count int64
+	err := dao.match(ctx, q).Count(&count).Error
+	return count, err
+}
+
+func (dao *GORMCommentSearchDAO) match(ctx context.Context, q CommentSearchQuery) *gorm.DB {
+	query := dao.db.WithContext(ctx).Model(&Comment{}).
+		Where("MATCH(content) AGAINST(? IN BOOLEAN MODE)", booleanQuery(q.Keywords))
+	return q.Filter.apply(query)
+}
+
+// booleanQuery 每个关键词都按短语匹配并且必须出现，ngram 会把短语拆成相邻的 token 去匹配。
+// 短语里面只有双引号有特殊含义，直接去掉
+func booleanQuery(keywords []string) string {
+	var sb strings.Builder
+	for _, kw := range keywords {
+		kw = strings.ReplaceAll(kw, `"`, "")
+		if kw == "" {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(`+"`)
+		sb.WriteString(kw)
+		sb.WriteByte('"')
+	}
+	return sb.String()
+}
@@ -0,0 +1,151 @@
+package dao
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// MemoryCommentSearchDAO 进程内的倒排索引，和 MySQL 的 ngram 一样按两个字切分，
+// 关键词拆出来的 token 都命中之后再确认一遍内容里面确实有这个关键词。
+// 数据要通过 Add 和 Remove 自己维护，适合测试和本地调试
+type MemoryCommentSearchDAO struct {
+	mu       sync.RWMutex
+	comments map[int64]Comment
+	// token 到包含它的评论
+	postings map[string]map[int64]struct{}
+}
+
+func NewMemoryCommentSearchDAO() *MemoryCommentSearchDAO {
+	return &MemoryCommentSearchDAO{
+		comments: make(map[int64]Comment),
+		postings: make(map[string]map[int64]struct{}),
+	}
+}
+
+// Add 已经存在的评论会被覆盖
+func (dao *MemoryCommentSearchDAO) Add(cs ...Comment) {
+	dao.mu.Lock()
+	defer dao.mu.Unlock()
+	for _, c := range cs {
+		dao.remove(c.Id)
+		dao.comments[c.Id] = c
+		for _, token := range ngramTokens(c.Content) {
+			ids, ok := dao.postings[token]
+			if !ok {
+				ids = make(map[int64]struct{})
+				dao.postings[token] = ids
+			}
+			ids[c.Id] = struct{}{}
+		}
+	}
+}
+
+func (dao *MemoryCommentSearchDAO) Remove(ids ...int64) {
+	dao.mu.Lock()
+	defer dao.mu.Unlock()
+	for _, id := range ids {
+		dao.remove(id)
+	}
+}
+
+func (dao *MemoryCommentSearchDAO) remove(id int64) {
+	c, ok := dao.comments[id]
+	if !ok {
+		return
+	}
+	delete(dao.comments, id)
+	for _, token := range ngramTokens(c.Content) {
+		ids := dao.postings[token]
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(dao.postings, token)
+		}
+	}
+}
+
+func (dao *MemoryCommentSearchDAO) Search(ctx context.Context, q CommentSearchQuery) ([]Comment, error) {
+	dao.mu.RLock()
+	defer dao.mu.RUnlock()
+	var res []Comment
+	for _, id := range dao.match(q) {
+		if id < q.CurId {
+			res = append(res, dao.comments[id])
+		}
+		if int64(len(res)) >= q.Limit {
+			break
+		}
+	}
+	return res, nil
+}
+
+func (dao *MemoryCommentSearchDAO) Count(ctx context.Context, q CommentSearchQuery) (int64, error) {
+	dao.mu.RLock()
+	defer dao.mu.RUnlock()
+	return int64(len(dao.match(q))), nil
+}
+
+// match 符合条件的评论 id，先新后旧
+func (dao *MemoryCommentSearchDAO) match(q CommentSearchQuery) []int64 {
+	var candidates map[int64]struct{}
+	for _, kw := range q.Keywords {
+		for _, token := range ngramTokens(kw) {
+			ids := dao.postings[token]
+			if candidates == nil {
+				candidates = make(map[int64]struct{}, len(ids))
+				for id := range ids {
+					candidates[id] = struct{}{}
+				}
+				continue
+			}
+			for id := range candidates {
+				if _, ok := ids[id]; !ok {
+					delete(candidates, id)
+				}
+			}
+		}
+	}
+	res := make([]int64, 0, len(candidates))
+	for id := range candidates {
+		c := dao.comments[id]
+		if q.Filter.match(c) && containsAll(c.Content, q.Keywords) {
+			res = append(res, id)
+		}
+	}
+	slices.Sort(res)
+	slices.Reverse(res)
+	return res
+}
+
+func (f CommentFilter) match(c Comment) bool {
+	return (f.Biz == 0 || c.Biz == f.Biz) &&
+		(f.BizId == 0 || c.BizId == f.BizId) &&
+		(f.Uid == 0 || c.Uid == f.Uid) &&
+		(f.StartTime == 0 || c.Ctime >= f.StartTime) &&
+		(f.EndTime == 0 || c.Ctime < f.EndTime)
+}
+
+func containsAll(content string, keywords []string) bool {
+	content = strings.ToLower(content)
+	for _, kw := range keywords {
+		if !strings.Contains(content, strings.ToLower(kw)) {
+			return false
+		}
+	}
+	return true
+}
+
+// ngramTokens 相邻的两个字一个 token，和 MySQL 一样丢掉带空白的 token，不区分大小写
+func ngramTokens(text string) []string {
+	runes := []rune(strings.ToLower(text))
+	res := make([]string, 0, len(runes))
+	for i := 0; i+1 < len(runes); i++ {
+		if unicode.IsSpace(runes[i]) || unicode.IsSpace(runes[i+1]) {
+			continue
+		}
+		res = append(res, string(runes[i:i+2]))
+	}
+	return res
+}
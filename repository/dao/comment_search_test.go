@@ -0,0 +1,109 @@
+package dao
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestBooleanQuery(t *testing.T) {
+	testCases := []struct {
+		name     string
+		keywords []string
+		want     string
+	}{
+		{
+			name:     "一个关键词",
+			keywords: []string{"好课"},
+			want:     `+"好课"`,
+		},
+		{
+			name:     "多个关键词都必须出现",
+			keywords: []string{"好课", "老师"},
+			want:     `+"好课" +"老师"`,
+		},
+		{
+			name:     "去掉双引号",
+			keywords: []string{`"好"课"`},
+			want:     `+"好课"`,
+		},
+		{
+			name:     "只有双引号的跳过",
+			keywords: []string{`""`, "老师"},
+			want:     `+"老师"`,
+		},
+		{
+			name:     "其他运算符在短语里面没有特殊含义",
+			keywords: []string{"-好课*"},
+			want:     `+"-好课*"`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, booleanQuery(tc.keywords))
+		})
+	}
+}
+
+func TestMemoryCommentSearchDAO_Search(t *testing.T) {
+	d := NewMemoryCommentSearchDAO()
+	d.Add(
+		Comment{Id: 1, Biz: 1, Content: "这门课是好课"},
+		Comment{Id: 2, Biz: 1, Content: "老师人很好，课也是好课"},
+		Comment{Id: 3, Biz: 2, Content: "Go 语言好课"},
+		Comment{Id: 4, Biz: 1, Content: "好 课"},
+	)
+	testCases := []struct {
+		name  string
+		query CommentSearchQuery
+
+		wantIds   []int64
+		wantCount int64
+	}{
+		{
+			name:      "先新后旧",
+			query:     CommentSearchQuery{Keywords: []string{"好课"}, CurId: 100, Limit: 10},
+			wantIds:   []int64{3, 2, 1},
+			wantCount: 3,
+		},
+		{
+			name:      "所有关键词都要出现",
+			query:     CommentSearchQuery{Keywords: []string{"好课", "老师"}, CurId: 100, Limit: 10},
+			wantIds:   []int64{2},
+			wantCount: 1,
+		},
+		{
+			name:      "不区分大小写",
+			query:     CommentSearchQuery{Keywords: []string{"go"}, CurId: 100, Limit: 10},
+			wantIds:   []int64{3},
+			wantCount: 1,
+		},
+		{
+			name:      "按游标翻页，总数不考虑游标",
+			query:     CommentSearchQuery{Keywords: []string{"好课"}, CurId: 3, Limit: 1},
+			wantIds:   []int64{2},
+			wantCount: 3,
+		},
+		{
+			name:      "按 biz 过滤",
+			query:     CommentSearchQuery{Keywords: []string{"好课"}, Filter: CommentFilter{Biz: 2}, CurId: 100, Limit: 10},
+			wantIds:   []int64{3},
+			wantCount: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cs, err := d.Search(context.Background(), tc.query)
+			require.NoError(t, err)
+			ids := make([]int64, 0, len(cs))
+			for _, c := range cs {
+				ids = append(ids, c.Id)
+			}
+			assert.Equal(t, tc.wantIds, ids)
+			count, err := d.Count(context.Background(), tc.query)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantCount, count)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package dao
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSamePayload(t *testing.T) {
+	base := Comment{
+		Id:         1,
+		Uid:        10,
+		Biz:        1,
+		BizId:      100,
+		RootID:     sql.NullInt64{Int64: 5, Valid: true},
+		PID:        sql.NullInt64{Int64: 6, Valid: true},
+		ReplyToUid: 11,
+		Content:    "hello",
+		Ctime:      1000,
+		Utime:      1000,
+	}
+	testCases := []struct {
+		name   string
+		modify func(c *Comment)
+		want   bool
+	}{
+		{
+			name:   "重复投递",
+			modify: func(c *Comment) {},
+			want:   true,
+		},
+		{
+			name: "附件不参与比较",
+			modify: func(c *Comment) {
+				c.Attachments = []CommentAttachment{{ObjectKey: "a"}}
+			},
+			want: true,
+		},
+		{
+			name:   "另一个人的评论",
+			modify: func(c *Comment) { c.Uid = 12 },
+		},
+		{
+			name:   "内容不同",
+			modify: func(c *Comment) { c.Content = "world" },
+		},
+		{
+			name:   "父评论不同",
+			modify: func(c *Comment) { c.PID = sql.NullInt64{} },
+		},
+		{
+			name:   "时间不同",
+			modify: func(c *Comment) { c.Ctime = 1001 },
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := base
+			tc.modify(&c)
+			assert.Equal(t, tc.want, samePayload(base, c))
+		})
+	}
+}
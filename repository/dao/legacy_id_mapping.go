@@ -0,0 +1,58 @@
+package dao
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LegacyIdMappingDAO 老系统导入的评论 id 和新 id 的对应关系，
+// 导入中断之后重新执行的时候靠它找回已经导入的父评论
+type LegacyIdMappingDAO interface {
+	// FindNewIds 返回 oldIds 里面已经有映射的那部分
+	FindNewIds(ctx context.Context, oldIds []int64) (map[int64]int64, error)
+	// Save 已经存在的映射保持不变
+	Save(ctx context.Context, mappings []LegacyIdMapping) error
+}
+
+type GORMLegacyIdMappingDAO struct {
+	db *gorm.DB
+}
+
+func NewLegacyIdMappingDAO(db *gorm.DB) LegacyIdMappingDAO {
+	return &GORMLegacyIdMappingDAO{db: db}
+}
+
+func (dao *GORMLegacyIdMappingDAO) FindNewIds(ctx context.Context, oldIds []int64) (map[int64]int64, error) {
+	res := make(map[int64]int64, len(oldIds))
+	if len(oldIds) == 0 {
+		return res, nil
+	}
+	var mappings []LegacyIdMapping
+	err := dao.db.WithContext(ctx).
+		Where("old_id IN ?", oldIds).
+		Find(&mappings).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range mappings {
+		res[m.OldId] = m.NewId
+	}
+	return res, nil
+}
+
+func (dao *GORMLegacyIdMappingDAO) Save(ctx context.Context, mappings []LegacyIdMapping) error {
+	if len(mappings) == 0 {
+		return nil
+	}
+	return dao.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&mappings).Error
+}
+
+type LegacyIdMapping struct {
+	// 老系统里面的评论 id
+	OldId int64 `gorm:"column:old_id;primaryKey;autoIncrement:false"`
+	NewId int64 `gorm:"column:new_id;uniqueIndex"`
+	Ctime int64 `gorm:"column:ctime"`
+}
@@ -0,0 +1,108 @@
+package dao
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// ReplyInboxDAO "回复我的"：reply_to_uid 是我、并且不是我自己发的评论
+type ReplyInboxDAO interface {
+	// FindReplies 先新后旧
+	FindReplies(ctx context.Context, uid int64, curCommentId int64, limit int64) ([]Comment, error)
+	// CountRepliesAfter 写入序号大于 seq 的回复数
+	CountRepliesAfter(ctx context.Context, uid int64, seq int64) (int64, error)
+	// GetInbox 没有收到过回复也没有读过的时候返回零值
+	GetInbox(ctx context.Context, uid int64) (ReplyInbox, error)
+	// FindReplySeq commentId 的写入序号，不是回复 uid 的评论的时候返回 0
+	FindReplySeq(ctx context.Context, uid int64, commentId int64) (int64, error)
+	// MarkRead 已读位置只会往前推进，返回推进之后的位置
+	MarkRead(ctx context.Context, uid int64, seq int64) (int64, error)
+}
+
+type GORMReplyInboxDAO struct {
+	db *gorm.DB
+}
+
+func NewReplyInboxDAO(db *gorm.DB) ReplyInboxDAO {
+	return &GORMReplyInboxDAO{db: db}
+}
+
+func (dao *GORMReplyInboxDAO) replies(ctx context.Context, uid int64) *gorm.DB {
+	return dao.db.WithContext(ctx).Model(&Comment{}).
+		Where("reply_to_uid = ? AND uid <> ?", uid, uid)
+}
+
+func (dao *GORMReplyInboxDAO) FindReplies(ctx context.Context, uid int64, curCommentId int64, limit int64) ([]Comment, error) {
+	var res []Comment
+	err := dao.replies(ctx, uid).Scopes(withAttachments).
+		Where("id < ?", curCommentId).
+		Order("id DESC").
+		Limit(int(limit)).
+		Find(&res).Error
+	return res, err
+}
+
+func (dao *GORMReplyInboxDAO) CountRepliesAfter(ctx context.Context, uid int64, seq int64) (int64, error) {
+	var res int64
+	err := dao.replies(ctx, uid).
+		Where("reply_seq > ?", seq).
+		Count(&res).Error
+	return res, err
+}
+
+func (dao *GORMReplyInboxDAO) GetInbox(ctx context.Context, uid int64) (ReplyInbox, error) {
+	var inbox ReplyInbox
+	err := dao.db.WithContext(ctx).
+		Where("uid = ?", uid).
+		First(&inbox).Error
+	if err == gorm.ErrRecordNotFound {
+		return ReplyInbox{Uid: uid}, nil
+	}
+	return inbox, err
+}
+
+func (dao *GORMReplyInboxDAO) FindReplySeq(ctx context.Context, uid int64, commentId int64) (int64, error) {
+	var res []int64
+	err := dao.replies(ctx, uid).
+		Where("id = ?", commentId).
+		Pluck("reply_seq", &res).Error
+	if err != nil || len(res) == 0 {
+		return 0, err
+	}
+	return res[0], nil
+}
+
+func (dao *GORMReplyInboxDAO) MarkRead(ctx context.Context, uid int64, seq int64) (int64, error) {
+	now := time.Now().UnixMilli()
+	err := dao.db.WithContext(ctx).Clauses(clause.OnConflict{
+		DoUpdates: clause.Assignments(map[string]any{
+			"last_read_seq": gorm.Expr("GREATEST(`last_read_seq`, ?)", seq),
+			"utime":         now,
+		}),
+	}).Create(&ReplyInbox{
+		Uid:         uid,
+		LastReadSeq: seq,
+		Ctime:       now,
+		Utime:       now,
+	}).Error
+	if err != nil {
+		return 0, err
+	}
+	inbox, err := dao.GetInbox(ctx, uid)
+	return inbox.LastReadSeq, err
+}
+
+// ReplyInbox 每个用户的"回复我的"。回复落库的时候在同一个事务里面分配写入序号，
+// 序号按提交的先后递增，比已读位置大的就是未读的。
+// 不能按评论 id 判断：异步落库的时候 id 小的回复可能在标记已读之后才写进来
+type ReplyInbox struct {
+	Uid int64 `gorm:"primaryKey;autoIncrement:false"`
+	// 分配出去的最大写入序号
+	LastSeq int64
+	// 读到了哪个写入序号
+	LastReadSeq int64
+	Ctime       int64
+	Utime       int64
+}
@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/MuxiKeStack/be-comment/repository/cache"
+	"github.com/MuxiKeStack/be-comment/repository/dao"
+)
+
+type ReplyInboxRepository interface {
+	// FindReplies 回复我的评论，先新后旧
+	FindReplies(ctx context.Context, uid int64, curCommentId int64, limit int64) ([]domain.Comment, error)
+	GetUnreadCount(ctx context.Context, uid int64) (int64, error)
+	// MarkRead commentId 以及比它先落库的回复都标记为已读，commentId 为 0 表示全部已读
+	MarkRead(ctx context.Context, uid int64, commentId int64) error
+	// AddUnread 新的回复落库之后调用，key 是被回复的人
+	AddUnread(ctx context.Context, deltas map[int64]int64)
+	// ResetUnread 回复被删除之后，让未读数在下次读取的时候重新统计
+	ResetUnread(ctx context.Context, uid int64)
+}
+
+type CachedReplyInboxRepo struct {
+	dao   dao.ReplyInboxDAO
+	cache cache.ReplyInboxCache
+	l     logger.Logger
+}
+
+func NewCachedReplyInboxRepo(dao dao.ReplyInboxDAO, cache cache.ReplyInboxCache, l logger.Logger) ReplyInboxRepository {
+	return &CachedReplyInboxRepo{
+		dao:   dao,
+		cache: cache,
+		l:     l,
+	}
+}
+
+func (repo *CachedReplyInboxRepo) FindReplies(ctx context.Context, uid int64, curCommentId int64, limit int64) ([]domain.Comment, error) {
+	cs, err := repo.dao.FindReplies(ctx, uid, curCommentId, limit)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]domain.Comment, 0, len(cs))
+	for _, cm := range cs {
+		res = append(res, toDomain(cm))
+	}
+	return res, nil
+}
+
+func (repo *CachedReplyInboxRepo) GetUnreadCount(ctx context.Context, uid int64) (int64, error) {
+	count, err := repo.cache.GetUnreadCount(ctx, uid)
+	if err == nil {
+		return count, nil
+	}
+	if err != cache.ErrKeyNotExists {
+		repo.l.Error("获取未读回复数缓存失败",
+			logger.Error(err),
+			logger.Int64("uid", uid))
+	}
+	inbox, err := repo.dao.GetInbox(ctx, uid)
+	if err != nil {
+		return 0, err
+	}
+	count, err = repo.dao.CountRepliesAfter(ctx, uid, inbox.LastReadSeq)
+	if err != nil {
+		return 0, err
+	}
+	err = repo.cache.SetUnreadCount(ctx, uid, count)
+	if err != nil {
+		repo.l.Error("回写未读回复数失败",
+			logger.Error(err),
+			logger.Int64("uid", uid))
+	}
+	return count, nil
+}
+
+func (repo *CachedReplyInboxRepo) MarkRead(ctx context.Context, uid int64, commentId int64) error {
+	var seq int64
+	if commentId <= 0 {
+		inbox, err := repo.dao.GetInbox(ctx, uid)
+		if err != nil {
+			return err
+		}
+		seq = inbox.LastSeq
+	} else {
+		var err error
+		seq, err = repo.dao.FindReplySeq(ctx, uid, commentId)
+		if err != nil {
+			return err
+		}
+	}
+	// 没有收到过回复，或者不是回复这个人的评论
+	if seq == 0 {
+		return nil
+	}
+	_, err := repo.dao.MarkRead(ctx, uid, seq)
+	if err != nil {
+		return err
+	}
+	// 直接删掉而不是算好了再写回去，避免覆盖掉并发的新回复的累加
+	return repo.cache.DelUnreadCount(ctx, uid)
+}
+
+func (repo *CachedReplyInboxRepo) AddUnread(ctx context.Context, deltas map[int64]int64) {
+	for uid, delta := range deltas {
+		err := repo.cache.AddUnreadCountIfPresent(ctx, uid, delta)
+		if err != nil {
+			// 缓存有过期时间，最多在这段时间内不准
+			repo.l.Error("增加未读回复数失败",
+				logger.Error(err),
+				logger.Int64("uid", uid))
+		}
+	}
+}
+
+func (repo *CachedReplyInboxRepo) ResetUnread(ctx context.Context, uid int64) {
+	err := repo.cache.DelUnreadCount(ctx, uid)
+	if err != nil {
+		repo.l.Error("删除未读回复数缓存失败",
+			logger.Error(err),
+			logger.Int64("uid", uid))
+	}
+}
@@ -0,0 +1,212 @@
+package repository
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-comment/pkg/logger"
+	"github.com/MuxiKeStack/be-comment/repository/cache"
+	"github.com/MuxiKeStack/be-comment/repository/dao"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"slices"
+	"testing"
+)
+
+// memoryReplyInboxDAO comments 按 id 升序
+type memoryReplyInboxDAO struct {
+	comments []dao.Comment
+	lastRead map[int64]int64
+	// 统计未读数的次数，用来判断有没有走缓存
+	counts int
+}
+
+func (d *memoryReplyInboxDAO) replies(uid int64) []dao.Comment {
+	var res []dao.Comment
+	for _, c := range d.comments {
+		if c.ReplyToUid == uid && c.Uid != uid {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+func (d *memoryReplyInboxDAO) FindReplies(ctx context.Context, uid int64, curCommentId int64, limit int64) ([]dao.Comment, error) {
+	var res []dao.Comment
+	replies := d.replies(uid)
+	slices.Reverse(replies)
+	for _, c := range replies {
+		if c.Id < curCommentId && int64(len(res)) < limit {
+			res = append(res, c)
+		}
+	}
+	return res, nil
+}
+
+func (d *memoryReplyInboxDAO) CountRepliesAfter(ctx context.Context, uid int64, seq int64) (int64, error) {
+	d.counts++
+	var res int64
+	for _, c := range d.replies(uid) {
+		if c.ReplySeq > seq {
+			res++
+		}
+	}
+	return res, nil
+}
+
+func (d *memoryReplyInboxDAO) GetInbox(ctx context.Context, uid int64) (dao.ReplyInbox, error) {
+	inbox := dao.ReplyInbox{Uid: uid, LastReadSeq: d.lastRead[uid]}
+	for _, c := range d.replies(uid) {
+		inbox.LastSeq = max(inbox.LastSeq, c.ReplySeq)
+	}
+	return inbox, nil
+}
+
+func (d *memoryReplyInboxDAO) FindReplySeq(ctx context.Context, uid int64, commentId int64) (int64, error) {
+	for _, c := range d.replies(uid) {
+		if c.Id == commentId {
+			return c.ReplySeq, nil
+		}
+	}
+	return 0, nil
+}
+
+func (d *memoryReplyInboxDAO) MarkRead(ctx context.Context, uid int64, seq int64) (int64, error) {
+	d.lastRead[uid] = max(d.lastRead[uid], seq)
+	return d.lastRead[uid], nil
+}
+
+// newTestReplyInboxRepo 用户 1 收到了 1、2、4、5 四条回复，3 是回复自己的，6 是回复别人的。
+// 4 比 5 晚落库，写入序号比 5 大
+func newTestReplyInboxRepo(t *testing.T) (*CachedReplyInboxRepo, *memoryReplyInboxDAO) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	d := &memoryReplyInboxDAO{
+		comments: []dao.Comment{
+			{Id: 1, Uid: 10, ReplyToUid: 1, ReplySeq: 1},
+			{Id: 2, Uid: 11, ReplyToUid: 1, ReplySeq: 2},
+			{Id: 3, Uid: 1, ReplyToUid: 1},
+			{Id: 4, Uid: 12, ReplyToUid: 1, ReplySeq: 4},
+			{Id: 5, Uid: 10, ReplyToUid: 1, ReplySeq: 3},
+			{Id: 6, Uid: 10, ReplyToUid: 2, ReplySeq: 1},
+		},
+		lastRead: make(map[int64]int64),
+	}
+	repo := NewCachedReplyInboxRepo(d, cache.NewRedisReplyInboxCache(client), logger.NewNopLogger())
+	return repo.(*CachedReplyInboxRepo), d
+}
+
+func TestCachedReplyInboxRepo_FindReplies(t *testing.T) {
+	repo, _ := newTestReplyInboxRepo(t)
+	ctx := context.Background()
+	first, err := repo.FindReplies(ctx, 1, 100, 3)
+	require.NoError(t, err)
+	ids := make([]int64, 0, len(first))
+	for _, c := range first {
+		ids = append(ids, c.Id)
+	}
+	// 先新后旧，自己回复自己的不算
+	assert.Equal(t, []int64{5, 4, 2}, ids)
+	second, err := repo.FindReplies(ctx, 1, first[len(first)-1].Id, 3)
+	require.NoError(t, err)
+	require.Len(t, second, 1)
+	assert.Equal(t, int64(1), second[0].Id)
+}
+
+func TestCachedReplyInboxRepo_MarkRead(t *testing.T) {
+	testCases := []struct {
+		name string
+		// 依次标记已读的评论
+		marks []int64
+
+		wantLastRead int64
+		wantUnread   int64
+	}{
+		{
+			name:       "没有读过",
+			wantUnread: 4,
+		},
+		{
+			name:         "读到中间",
+			marks:        []int64{2},
+			wantLastRead: 2,
+			wantUnread:   2,
+		},
+		{
+			name:  "id 小但是晚落库的回复还是未读",
+			marks: []int64{5},
+			// 4 的 id 比 5 小，但是在 5 之后才落库
+			wantLastRead: 3,
+			wantUnread:   1,
+		},
+		{
+			name:         "已读位置不会往回退",
+			marks:        []int64{5, 1},
+			wantLastRead: 3,
+			wantUnread:   1,
+		},
+		{
+			name:         "0 表示全部已读",
+			marks:        []int64{0},
+			wantLastRead: 4,
+		},
+		{
+			name:       "不是回复自己的评论",
+			marks:      []int64{6},
+			wantUnread: 4,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo, d := newTestReplyInboxRepo(t)
+			ctx := context.Background()
+			// 先把未读数缓存起来，标记已读之后缓存要失效
+			_, err := repo.GetUnreadCount(ctx, 1)
+			require.NoError(t, err)
+			for _, mark := range tc.marks {
+				require.NoError(t, repo.MarkRead(ctx, 1, mark))
+			}
+			assert.Equal(t, tc.wantLastRead, d.lastRead[1])
+			count, err := repo.GetUnreadCount(ctx, 1)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantUnread, count)
+		})
+	}
+}
+
+func TestCachedReplyInboxRepo_UnreadCount(t *testing.T) {
+	repo, d := newTestReplyInboxRepo(t)
+	ctx := context.Background()
+	count, err := repo.GetUnreadCount(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), count)
+
+	// 第二次走缓存
+	count, err = repo.GetUnreadCount(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), count)
+	assert.Equal(t, 1, d.counts)
+
+	// 新的回复直接累加到缓存上面，没有缓存的用户不处理
+	d.comments = append(d.comments, dao.Comment{Id: 7, Uid: 13, ReplyToUid: 1, ReplySeq: 5})
+	repo.AddUnread(ctx, map[int64]int64{1: 1, 2: 1})
+	count, err = repo.GetUnreadCount(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), count)
+	assert.Equal(t, 1, d.counts)
+	count, err = repo.GetUnreadCount(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	assert.Equal(t, 2, d.counts)
+
+	// 回复被删掉之后重新统计
+	d.comments = slices.DeleteFunc(d.comments, func(c dao.Comment) bool {
+		return c.Id == 4 || c.Id == 5
+	})
+	repo.ResetUnread(ctx, 1)
+	count, err = repo.GetUnreadCount(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+	assert.Equal(t, 3, d.counts)
+}
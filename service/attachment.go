@@ -0,0 +1,80 @@
+package service
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"strings"
+)
+
+const (
+	maxObjectKeyLength = 255
+	// 图片的边长上限，超过的基本上是伪造的元数据
+	maxImageSide = 16384
+	// sha256 的十六进制长度
+	checksumLength = 64
+)
+
+var ErrInvalidAttachment = errors.New("附件不符合要求")
+
+// AttachmentPolicy 附件的白名单，从配置里面读
+type AttachmentPolicy struct {
+	// 一条评论最多几个附件，0 表示不允许带附件
+	MaxCount int
+	// ObjectKey 必须以它开头，只能引用上传到评论目录下面的文件
+	KeyPrefix string
+	// 允许的 MIME 类型，值是这个类型的最大字节数
+	MimeTypes map[string]int64
+}
+
+// Check 校验附件，返回规范化之后的附件（MIME 类型和校验和统一成小写）
+func (p AttachmentPolicy) Check(attachments []domain.Attachment) ([]domain.Attachment, error) {
+	if len(attachments) == 0 {
+		return nil, nil
+	}
+	if len(attachments) > p.MaxCount {
+		return nil, fmt.Errorf("%w: 最多 %d 个附件", ErrInvalidAttachment, p.MaxCount)
+	}
+	res := make([]domain.Attachment, 0, len(attachments))
+	keys := make(map[string]struct{}, len(attachments))
+	for i, a := range attachments {
+		a.MimeType = strings.ToLower(strings.TrimSpace(a.MimeType))
+		a.Checksum = strings.ToLower(a.Checksum)
+		err := p.check(a)
+		if err != nil {
+			return nil, fmt.Errorf("%w: 第 %d 个附件%s", ErrInvalidAttachment, i+1, err.Error())
+		}
+		if _, ok := keys[a.ObjectKey]; ok {
+			return nil, fmt.Errorf("%w: 第 %d 个附件重复了", ErrInvalidAttachment, i+1)
+		}
+		keys[a.ObjectKey] = struct{}{}
+		res = append(res, a)
+	}
+	return res, nil
+}
+
+func (p AttachmentPolicy) check(a domain.Attachment) error {
+	if a.ObjectKey == "" || len(a.ObjectKey) > maxObjectKeyLength ||
+		!strings.HasPrefix(a.ObjectKey, p.KeyPrefix) || strings.Contains(a.ObjectKey, "..") {
+		return errors.New("的 ObjectKey 无效")
+	}
+	maxSize, ok := p.MimeTypes[a.MimeType]
+	if !ok {
+		return fmt.Errorf("的类型 %s 不允许上传", a.MimeType)
+	}
+	if a.Size <= 0 || a.Size > maxSize {
+		return fmt.Errorf("的大小必须在 1 到 %d 字节之间", maxSize)
+	}
+	if strings.HasPrefix(a.MimeType, "image/") {
+		if a.Width <= 0 || a.Height <= 0 || a.Width > maxImageSide || a.Height > maxImageSide {
+			return errors.New("的宽高无效")
+		}
+	} else if a.Width != 0 || a.Height != 0 {
+		return errors.New("不是图片，不能有宽高")
+	}
+	if _, err := hex.DecodeString(a.Checksum); err != nil || len(a.Checksum) != checksumLength {
+		return errors.New("的校验和不是 sha256")
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestAttachmentPolicy_Check(t *testing.T) {
+	policy := AttachmentPolicy{
+		MaxCount:  2,
+		KeyPrefix: "comment/",
+		MimeTypes: map[string]int64{
+			"image/png":       1024,
+			"application/pdf": 2048,
+		},
+	}
+	checksum := strings.Repeat("ab", 32)
+	png := func(key string) domain.Attachment {
+		return domain.Attachment{ObjectKey: key, MimeType: "image/png", Size: 100, Width: 10, Height: 20, Checksum: checksum}
+	}
+	testCases := []struct {
+		name        string
+		policy      AttachmentPolicy
+		attachments []domain.Attachment
+
+		want    []domain.Attachment
+		wantErr error
+	}{
+		{
+			name: "没有附件",
+		},
+		{
+			name: "图片和文件",
+			attachments: []domain.Attachment{
+				png("comment/a.png"),
+				{ObjectKey: "comment/b.pdf", MimeType: "application/pdf", Size: 2048, Checksum: checksum},
+			},
+			want: []domain.Attachment{
+				png("comment/a.png"),
+				{ObjectKey: "comment/b.pdf", MimeType: "application/pdf", Size: 2048, Checksum: checksum},
+			},
+		},
+		{
+			name: "类型和校验和统一成小写",
+			attachments: []domain.Attachment{
+				{ObjectKey: "comment/a.png", MimeType: " IMAGE/PNG ", Size: 100, Width: 10, Height: 20, Checksum: strings.ToUpper(checksum)},
+			},
+			want: []domain.Attachment{png("comment/a.png")},
+		},
+		{
+			name:        "超过个数",
+			attachments: []domain.Attachment{png("comment/a.png"), png("comment/b.png"), png("comment/c.png")},
+			wantErr:     ErrInvalidAttachment,
+		},
+		{
+			name:        "不允许带附件",
+			policy:      AttachmentPolicy{KeyPrefix: "comment/", MimeTypes: policy.MimeTypes},
+			attachments: []domain.Attachment{png("comment/a.png")},
+			wantErr:     ErrInvalidAttachment,
+		},
+		{
+			name:        "不在评论目录下面",
+			attachments: []domain.Attachment{png("avatar/a.png")},
+			wantErr:     ErrInvalidAttachment,
+		},
+		{
+			name:        "路径里面有 ..",
+			attachments: []domain.Attachment{png("comment/../avatar/a.png")},
+			wantErr:     ErrInvalidAttachment,
+		},
+		{
+			name:        "ObjectKey 为空",
+			attachments: []domain.Attachment{png("")},
+			wantErr:     ErrInvalidAttachment,
+		},
+		{
+			name:        "ObjectKey 太长",
+			attachments: []domain.Attachment{png("comment/" + strings.Repeat("a", maxObjectKeyLength))},
+			wantErr:     ErrInvalidAttachment,
+		},
+		{
+			name: "不允许的类型",
+			attachments: []domain.Attachment{
+				{ObjectKey: "comment/a.svg", MimeType: "image/svg+xml", Size: 100, Width: 10, Height: 10, Checksum: checksum},
+			},
+			wantErr: ErrInvalidAttachment,
+		},
+		{
+			name: "超过这个类型的大小",
+			attachments: []domain.Attachment{
+				{ObjectKey: "comment/a.png", MimeType: "image/png", Size: 1025, Width: 10, Height: 10, Checksum: checksum},
+			},
+			wantErr: ErrInvalidAttachment,
+		},
+		{
+			name: "大小为 0",
+			attachments: []domain.Attachment{
+				{ObjectKey: "comment/a.png", MimeType: "image/png", Width: 10, Height: 10, Checksum: checksum},
+			},
+			wantErr: ErrInvalidAttachment,
+		},
+		{
+			name: "图片没有宽高",
+			attachments: []domain.Attachment{
+				{ObjectKey: "comment/a.png", MimeType: "image/png", Size: 100, Checksum: checksum},
+			},
+			wantErr: ErrInvalidAttachment,
+		},
+		{
+			name: "图片边长超过上限",
+			attachments: []domain.Attachment{
+				{ObjectKey: "comment/a.png", MimeType: "image/png", Size: 100, Width: maxImageSide + 1, Height: 10, Checksum: checksum},
+			},
+			wantErr: ErrInvalidAttachment,
+		},
+		{
+			name: "不是图片却有宽高",
+			attachments: []domain.Attachment{
+				{ObjectKey: "comment/a.pdf", MimeType: "application/pdf", Size: 100, Width: 10, Height: 10, Checksum: checksum},
+			},
+			wantErr: ErrInvalidAttachment,
+		},
+		{
+			name: "校验和不是十六进制",
+			attachments: []domain.Attachment{
+				{ObjectKey: "comment/a.png", MimeType: "image/png", Size: 100, Width: 10, Height: 20, Checksum: strings.Repeat("zz", 32)},
+			},
+			wantErr: ErrInvalidAttachment,
+		},
+		{
+			name: "校验和长度不对",
+			attachments: []domain.Attachment{
+				{ObjectKey: "comment/a.png", MimeType: "image/png", Size: 100, Width: 10, Height: 20, Checksum: "abcd"},
+			},
+			wantErr: ErrInvalidAttachment,
+		},
+		{
+			name:        "同一个文件传了两次",
+			attachments: []domain.Attachment{png("comment/a.png"), png("comment/a.png")},
+			wantErr:     ErrInvalidAttachment,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := policy
+			if tc.policy.KeyPrefix != "" {
+				p = tc.policy
+			}
+			res, err := p.Check(tc.attachments)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, res)
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/MuxiKeStack/be-comment/repository"
+	"github.com/ecodeclub/ekit/slice"
+)
+
+const (
+	// 每个人最多拉黑多少人，列表评论的时候要整个拿出来过滤
+	maxBlockedUsers = 1000
+	// 一条评论最多 @ 多少人，每个人都要查一次拉黑
+	maxMentions = 20
+)
+
+var (
+	ErrBlockSelf          = errors.New("不能拉黑自己")
+	ErrTooManyBlocked     = errors.New("拉黑的人太多了")
+	ErrBlockedByAuthor    = errors.New("对方已经把你拉黑了，不能回复")
+	ErrBlockedByMentioned = errors.New("被 @ 的人已经把你拉黑了")
+	ErrTooManyMentions    = errors.New("@ 的人太多了")
+)
+
+type BlockService interface {
+	// Block 拉黑之后 blockedUid 的评论不会出现在 uid 看到的评论列表里面，也不能再回复 uid
+	Block(ctx context.Context, uid int64, blockedUid int64) error
+	Unblock(ctx context.Context, uid int64, blockedUid int64) error
+	ListBlocked(ctx context.Context, uid int64) ([]int64, error)
+}
+
+type blockService struct {
+	repo repository.BlockRepository
+}
+
+func NewBlockService(repo repository.BlockRepository) BlockService {
+	return &blockService{repo: repo}
+}
+
+func (s *blockService) Block(ctx context.Context, uid int64, blockedUid int64) error {
+	if uid == blockedUid {
+		return ErrBlockSelf
+	}
+	blocked, err := s.repo.FindBlocked(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if slice.Contains(blocked, blockedUid) {
+		return nil
+	}
+	if len(blocked) >= maxBlockedUsers {
+		return ErrTooManyBlocked
+	}
+	return s.repo.Block(ctx, uid, blockedUid)
+}
+
+func (s *blockService) Unblock(ctx context.Context, uid int64, blockedUid int64) error {
+	return s.repo.Unblock(ctx, uid, blockedUid)
+}
+
+func (s *blockService) ListBlocked(ctx context.Context, uid int64) ([]int64, error) {
+	return s.repo.FindBlocked(ctx, uid)
+}
+
+// blockedBy viewer 拉黑的人，viewerUid 为 0（没有登录）的时候返回 nil，不过滤
+func (s *commentService) blockedBy(ctx context.Context, viewerUid int64) (map[int64]struct{}, error) {
+	if viewerUid == 0 {
+		return nil, nil
+	}
+	blocked, err := s.blockRepo.FindBlocked(ctx, viewerUid)
+	if err != nil || len(blocked) == 0 {
+		return nil, err
+	}
+	res := make(map[int64]struct{}, len(blocked))
+	for _, uid := range blocked {
+		res[uid] = struct{}{}
+	}
+	return res, nil
+}
+
+// checkMentions 被 @ 的人拉黑了评论者的话不能发，匿名评论也按真实的 uid 判断
+func (s *commentService) checkMentions(ctx context.Context, uid int64, mentionUids []int64) error {
+	if len(mentionUids) > maxMentions {
+		return ErrTooManyMentions
+	}
+	for _, mentioned := range mentionUids {
+		blocked, err := s.blockRepo.IsBlocked(ctx, mentioned, uid)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			return ErrBlockedByMentioned
+		}
+	}
+	return nil
+}
+
+// visible 去掉被拉黑的人的评论，匿名评论也按真实的 uid 过滤
+func visible(cs []domain.Comment, blocked map[int64]struct{}) []domain.Comment {
+	if len(blocked) == 0 {
+		return cs
+	}
+	return slice.FilterMap(cs, func(idx int, src domain.Comment) (domain.Comment, bool) {
+		_, ok := blocked[src.Commentator.ID]
+		return src, !ok
+	})
+}
+
+// fillVisible 按 id 翻页的列表过滤之后不够 limit 条的时候继续往后取，
+// 这样客户端仍然可以用"返回的条数小于 limit"来判断是不是到底了。
+// 从上一次取到的最后一条接着取，所以 find 必须按 id 排序，limit 和 listPage 一样有默认值和上限
+func fillVisible(blocked map[int64]struct{}, curCommentId int64, limit int64,
+	find func(curCommentId int64, limit int64) ([]domain.Comment, error)) ([]domain.Comment, error) {
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	limit = min(limit, maxPageSize)
+	var res []domain.Comment
+	for {
+		cs, err := find(curCommentId, limit)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, visible(cs, blocked)...)
+		if int64(len(cs)) < limit || int64(len(res)) >= limit {
+			break
+		}
+		curCommentId = cs[len(cs)-1].Id
+	}
+	if int64(len(res)) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/MuxiKeStack/be-comment/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"slices"
+	"testing"
+)
+
+// memoryBlockRepo blocked[uid] 是 uid 拉黑的人
+type memoryBlockRepo struct {
+	repository.BlockRepository
+	blocked map[int64][]int64
+}
+
+func (r *memoryBlockRepo) Block(ctx context.Context, uid int64, blockedUid int64) error {
+	r.blocked[uid] = append(r.blocked[uid], blockedUid)
+	return nil
+}
+
+func (r *memoryBlockRepo) Unblock(ctx context.Context, uid int64, blockedUid int64) error {
+	r.blocked[uid] = slices.DeleteFunc(r.blocked[uid], func(u int64) bool {
+		return u == blockedUid
+	})
+	return nil
+}
+
+func (r *memoryBlockRepo) FindBlocked(ctx context.Context, uid int64) ([]int64, error) {
+	return r.blocked[uid], nil
+}
+
+func (r *memoryBlockRepo) IsBlocked(ctx context.Context, uid int64, blockedUid int64) (bool, error) {
+	return slices.Contains(r.blocked[uid], blockedUid), nil
+}
+
+func TestBlockService_Block(t *testing.T) {
+	tooMany := make([]int64, 0, maxBlockedUsers)
+	for i := int64(0); i < maxBlockedUsers; i++ {
+		tooMany = append(tooMany, 100+i)
+	}
+	testCases := []struct {
+		name       string
+		blocked    []int64
+		blockedUid int64
+
+		wantBlocked []int64
+		wantErr     error
+	}{
+		{
+			name:        "拉黑",
+			blocked:     []int64{2},
+			blockedUid:  3,
+			wantBlocked: []int64{2, 3},
+		},
+		{
+			name:        "重复拉黑",
+			blocked:     []int64{2, 3},
+			blockedUid:  3,
+			wantBlocked: []int64{2, 3},
+		},
+		{
+			name:        "不能拉黑自己",
+			blockedUid:  1,
+			wantBlocked: nil,
+			wantErr:     ErrBlockSelf,
+		},
+		{
+			name:        "拉黑的人太多了",
+			blocked:     tooMany,
+			blockedUid:  3,
+			wantBlocked: tooMany,
+			wantErr:     ErrTooManyBlocked,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &memoryBlockRepo{blocked: map[int64][]int64{}}
+			if tc.blocked != nil {
+				repo.blocked[1] = slices.Clone(tc.blocked)
+			}
+			svc := NewBlockService(repo)
+			err := svc.Block(context.Background(), 1, tc.blockedUid)
+			assert.ErrorIs(t, err, tc.wantErr)
+			blocked, err := svc.ListBlocked(context.Background(), 1)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantBlocked, blocked)
+		})
+	}
+}
+
+func TestFillVisible(t *testing.T) {
+	// id 从 10 到 1，先新后旧，评论 id 的发布者是 id * 10
+	var all []domain.Comment
+	for id := int64(10); id >= 1; id-- {
+		all = append(all, domain.Comment{Id: id, Commentator: domain.User{ID: id * 10}})
+	}
+	testCases := []struct {
+		name         string
+		blocked      []int64
+		curCommentId int64
+		limit        int64
+
+		wantIds []int64
+		// 每一次调用 find 传进去的 curCommentId 和 limit
+		wantCalls []int64
+		wantLimit int64
+	}{
+		{
+			name:         "没有拉黑的人",
+			curCommentId: 11,
+			limit:        3,
+			wantLimit:    3,
+			wantIds:      []int64{10, 9, 8},
+			wantCalls:    []int64{11},
+		},
+		{
+			name:         "过滤之后不够从最后一条接着取",
+			blocked:      []int64{90, 80},
+			curCommentId: 11,
+			limit:        3,
+			wantLimit:    3,
+			wantIds:      []int64{10, 7, 6},
+			wantCalls:    []int64{11, 8},
+		},
+		{
+			name:         "补齐之后多出来的截掉",
+			blocked:      []int64{90},
+			curCommentId: 11,
+			limit:        3,
+			wantLimit:    3,
+			wantIds:      []int64{10, 8, 7},
+			wantCalls:    []int64{11, 8},
+		},
+		{
+			name:         "取到底了",
+			blocked:      []int64{20},
+			curCommentId: 4,
+			limit:        3,
+			wantLimit:    3,
+			wantIds:      []int64{3, 1},
+			wantCalls:    []int64{4, 1},
+		},
+		{
+			name:         "全部被拉黑了",
+			blocked:      []int64{10, 20, 30, 40},
+			curCommentId: 5,
+			limit:        2,
+			wantLimit:    2,
+			wantIds:      nil,
+			wantCalls:    []int64{5, 3, 1},
+		},
+		{
+			name:         "limit 是负数用默认的",
+			curCommentId: 3,
+			limit:        -1,
+			wantLimit:    defaultPageSize,
+			wantIds:      []int64{2, 1},
+			wantCalls:    []int64{3},
+		},
+		{
+			name:         "limit 超过上限",
+			curCommentId: 3,
+			limit:        maxPageSize + 1,
+			wantLimit:    maxPageSize,
+			wantIds:      []int64{2, 1},
+			wantCalls:    []int64{3},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			blocked := make(map[int64]struct{}, len(tc.blocked))
+			for _, uid := range tc.blocked {
+				blocked[uid] = struct{}{}
+			}
+			var calls []int64
+			var limits []int64
+			cs, err := fillVisible(blocked, tc.curCommentId, tc.limit, func(curCommentId int64, limit int64) ([]domain.Comment, error) {
+				calls = append(calls, curCommentId)
+				limits = append(limits, limit)
+				var res []domain.Comment
+				for _, c := range all {
+					if c.Id < curCommentId && int64(len(res)) < limit {
+						res = append(res, c)
+					}
+				}
+				return res, nil
+			})
+			require.NoError(t, err)
+			var ids []int64
+			for _, c := range cs {
+				ids = append(ids, c.Id)
+			}
+			assert.Equal(t, tc.wantIds, ids)
+			assert.Equal(t, tc.wantCalls, calls)
+			for _, limit := range limits {
+				assert.Equal(t, tc.wantLimit, limit)
+			}
+		})
+	}
+}
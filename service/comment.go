@@ -6,133 +6,235 @@ import (
 	answerv1 "github.com/MuxiKeStack/be-api/gen/proto/answer/v1"
 	commentv1 "github.com/MuxiKeStack/be-api/gen/proto/comment/v1"
 	evaluationv1 "github.com/MuxiKeStack/be-api/gen/proto/evaluation/v1"
-	feedv1 "github.com/MuxiKeStack/be-api/gen/proto/feed/v1"
 	"github.com/MuxiKeStack/be-comment/domain"
 	"github.com/MuxiKeStack/be-comment/events"
+	"github.com/MuxiKeStack/be-comment/pkg/idgen"
 	"github.com/MuxiKeStack/be-comment/pkg/logger"
 	"github.com/MuxiKeStack/be-comment/repository"
-	"strconv"
+	"math"
 	"time"
 )
 
 var (
 	ErrCommentNotFound = repository.ErrCommentNotFound
 	ErrInvalidBiz      = errors.New("创建的评论所属biz无效")
+	ErrInvalidParent   = errors.New("父评论不属于同一个biz")
 )
 
 type CommentService interface {
-	CreateComment(ctx context.Context, comment domain.Comment) error
-	GetCommentList(ctx context.Context, biz commentv1.Biz, bizId int64, curCommentId int64, limit int64) ([]domain.Comment, error)
+	// CreateComment 校验通过之后分配好 id 就返回，评论是异步落库的：
+	// 返回之后的短时间内 GetComment 可能还查不到；落库失败的（比如父评论在这期间被删了）
+	// 会进入死信队列，不会通知调用方，调用方需要的话可以用返回的 id 查询确认
+	CreateComment(ctx context.Context, comment domain.Comment) (int64, error)
+	// GetCommentList viewerUid 是正在看的人，他拉黑的人的评论会被过滤掉，为 0 的时候不过滤
+	GetCommentList(ctx context.Context, biz commentv1.Biz, bizId int64, curCommentId int64, limit int64, viewerUid int64) ([]domain.Comment, error)
 	DeleteComment(ctx context.Context, commentId int64, uid int64) error
-	GetMoreReplies(ctx context.Context, rid int64, curCommentId int64, limit int64) ([]domain.Comment, error)
+	// GetMoreReplies viewerUid 的用法和 GetCommentList 一样
+	GetMoreReplies(ctx context.Context, rid int64, curCommentId int64, limit int64, viewerUid int64) ([]domain.Comment, error)
 	Count(ctx context.Context, biz commentv1.Biz, bizId int64) (int64, error)
 	GetComment(ctx context.Context, commentId int64) (domain.Comment, error)
+	// ListComments 按照 sort 排序的一页根评论，第一页 cursor 传空字符串。
+	// 往后翻传上一页的 NextCursor 和 PageNext，往前翻传 PrevCursor 和 PagePrev
+	// viewerUid 拉黑的人的评论会被过滤掉
+	ListComments(ctx context.Context, biz commentv1.Biz, bizId int64, sort domain.SortMode, cursor string, dir domain.PageDirection, limit int64, viewerUid int64) (domain.CommentPage, error)
+	// ListReplies 按照 sort 排序的一页回复，cursor、dir 和 viewerUid 的用法和 ListComments 一样
+	ListReplies(ctx context.Context, rid int64, sort domain.SortMode, cursor string, dir domain.PageDirection, limit int64, viewerUid int64) (domain.CommentPage, error)
+	// ListCommentsSince 客户端看到的根评论里面最大的写入序号是 sinceSeq，返回在它之后落库的评论和数量，
+	// viewerUid 拉黑的人的评论不算
+	ListCommentsSince(ctx context.Context, biz commentv1.Biz, bizId int64, sinceSeq int64, limit int64, viewerUid int64) (domain.NewComments, error)
+	// ListUserComments 用户自己发表的评论，先新后旧，biz 为 0 表示全部
+	ListUserComments(ctx context.Context, uid int64, biz commentv1.Biz, curCommentId int64, limit int64) ([]domain.Comment, error)
+	// GetThread 以 commentId 为根的回复树，maxDepth 为 0 表示不限制深度，
+	// maxNodes 是这一页最多返回的回复数，cursor 为 0 的时候从头开始
+	GetThread(ctx context.Context, commentId int64, maxDepth int, maxNodes int, cursor int64) (domain.Thread, error)
+	// GetCommentContext 评论本身、根评论、祖先以及前后各 k 条同级的评论
+	GetCommentContext(ctx context.Context, commentId int64, k int64) (domain.CommentContext, error)
+	// ListSiblings 以 anchorId 为游标继续往前（before 为 true）或者往后翻同级的评论，返回还有没有更多
+	ListSiblings(ctx context.Context, anchorId int64, before bool, limit int64) ([]domain.Comment, bool, error)
 }
 
 type commentService struct {
-	repo       repository.CommentRepository
-	uidGetters map[commentv1.Biz]UIDGetter
-	producer   events.Producer
-	l          logger.Logger
+	repo        repository.CommentRepository
+	inboxRepo   repository.ReplyInboxRepository
+	aliasRepo   repository.AnonymousAliasRepository
+	blockRepo   repository.BlockRepository
+	uidGetters  map[commentv1.Biz]UIDGetter
+	attachments AttachmentPolicy
+	producer    events.Producer
+	idGen       idgen.Generator
+	l           logger.Logger
 }
 
-func NewCommentService(repo repository.CommentRepository, producer events.Producer, evaluationClient evaluationv1.EvaluationServiceClient,
-	answerClient answerv1.AnswerServiceClient, l logger.Logger) CommentService {
+func NewCommentService(repo repository.CommentRepository, inboxRepo repository.ReplyInboxRepository,
+	aliasRepo repository.AnonymousAliasRepository, blockRepo repository.BlockRepository, producer events.Producer, idGen idgen.Generator, evaluationClient evaluationv1.EvaluationServiceClient,
+	answerClient answerv1.AnswerServiceClient, attachments AttachmentPolicy, l logger.Logger) CommentService {
 	return &commentService{
-		repo: repo,
+		repo:      repo,
+		inboxRepo: inboxRepo,
+		aliasRepo: aliasRepo,
+		blockRepo: blockRepo,
 		uidGetters: map[commentv1.Biz]UIDGetter{
 			commentv1.Biz_Evaluation: &EvaluationUIDGetter{evaluationClient: evaluationClient},
 			commentv1.Biz_Answer:     &AnswerUIDGetter{answerClient: answerClient},
 		},
-		producer: producer,
-		l:        l,
+		attachments: attachments,
+		producer:    producer,
+		idGen:       idGen,
+		l:           l,
 	}
 }
 
-func (s *commentService) GetCommentList(ctx context.Context, biz commentv1.Biz, bizId int64, curCommentId int64, limit int64) ([]domain.Comment, error) {
-	list, err := s.repo.FindByBiz(ctx, biz, bizId, curCommentId, limit)
+func (s *commentService) GetCommentList(ctx context.Context, biz commentv1.Biz, bizId int64,
+	curCommentId int64, limit int64, viewerUid int64) ([]domain.Comment, error) {
+	blocked, err := s.blockedBy(ctx, viewerUid)
 	if err != nil {
 		return nil, err
 	}
-	return list, err
+	list, err := fillVisible(blocked, curCommentId, limit, func(curCommentId int64, limit int64) ([]domain.Comment, error) {
+		return s.repo.FindByBiz(ctx, biz, bizId, curCommentId, limit)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return domain.MaskComments(list), err
 }
 
 func (s *commentService) DeleteComment(ctx context.Context, commentId int64, uid int64) error {
-	return s.repo.DeleteComment(ctx, commentId, uid)
+	replyToUids, err := s.repo.DeleteComment(ctx, commentId, uid)
+	if err != nil {
+		return err
+	}
+	// 这条评论和级联删除的回复，被回复的人都可能少了未读，让未读数重新统计
+	for _, replyToUid := range replyToUids {
+		s.inboxRepo.ResetUnread(ctx, replyToUid)
+	}
+	return nil
 }
 
 func (s *commentService) Count(ctx context.Context, biz commentv1.Biz, bizId int64) (int64, error) {
 	return s.repo.GetCountByBiz(ctx, biz, bizId)
 }
 
-func (s *commentService) GetMoreReplies(ctx context.Context, rid int64, curCommentId int64, limit int64) ([]domain.Comment, error) {
-	return s.repo.GetMoreReplies(ctx, rid, curCommentId, limit)
+func (s *commentService) GetMoreReplies(ctx context.Context, rid int64, curCommentId int64,
+	limit int64, viewerUid int64) ([]domain.Comment, error) {
+	blocked, err := s.blockedBy(ctx, viewerUid)
+	if err != nil {
+		return nil, err
+	}
+	cs, err := fillVisible(blocked, curCommentId, limit, func(curCommentId int64, limit int64) ([]domain.Comment, error) {
+		return s.repo.GetMoreReplies(ctx, rid, curCommentId, limit)
+	})
+	return domain.MaskComments(cs), err
+}
+
+func (s *commentService) ListUserComments(ctx context.Context, uid int64, biz commentv1.Biz,
+	curCommentId int64, limit int64) ([]domain.Comment, error) {
+	// 第一次查询
+	if curCommentId <= 0 {
+		curCommentId = math.MaxInt64
+	}
+	cs, err := s.repo.FindByUid(ctx, uid, biz, curCommentId, limit)
+	return domain.MaskComments(cs), err
 }
 
-func (s *commentService) CreateComment(ctx context.Context, comment domain.Comment) error {
+func (s *commentService) CreateComment(ctx context.Context, comment domain.Comment) (int64, error) {
 	// 自己是根评论，reply to biz的owner
 	getter, ok := s.uidGetters[comment.Biz]
 	if !ok {
-		return ErrInvalidBiz
+		return 0, ErrInvalidBiz
+	}
+	contentHTML, err := renderContent(comment.Format, comment.Content)
+	if err != nil {
+		return 0, err
+	}
+	attachments, err := s.attachments.Check(comment.Attachments)
+	if err != nil {
+		return 0, err
 	}
 	publisherId, err := getter.GetUID(ctx, comment.BizId)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	var (
+		rootId, pid         int64
+		replyToAnonymousSeq int32
+	)
 	// 要去聚合一下 replyToUid
-	if comment.ParentComment.Id != 0 {
+	if comment.ParentComment != nil && comment.ParentComment.Id != 0 {
 		// 有父评论，找到父评论的发布者
 		pc, er := s.repo.FindById(ctx, comment.ParentComment.Id)
 		if er != nil {
-			return er
+			return 0, er
+		}
+		if pc.Biz != comment.Biz || pc.BizId != comment.BizId {
+			return 0, ErrInvalidParent
+		}
+		// 被父评论的作者拉黑了就不能回复他，匿名评论按真实的 uid 判断
+		blocked, er := s.blockRepo.IsBlocked(ctx, pc.Commentator.ID, comment.Commentator.ID)
+		if er != nil {
+			return 0, er
+		}
+		if blocked {
+			return 0, ErrBlockedByAuthor
 		}
 		comment.ReplyToUid = pc.Commentator.ID
+		replyToAnonymousSeq = pc.AnonymousSeq
+		pid = pc.Id
+		// 父评论自己就是根评论的话，根评论就是父评论
+		rootId = pc.Id
+		if pc.RootComment != nil {
+			rootId = pc.RootComment.Id
+		}
 	} else {
 		comment.ReplyToUid = publisherId
 	}
-	commentId, err := s.repo.CreateCommentSync(ctx, comment)
+	err = s.checkMentions(ctx, comment.Commentator.ID, comment.MentionUids)
 	if err != nil {
-		return err
+		return 0, err
 	}
-
-	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
-		defer cancel()
-		er := s.producer.ProduceFeedEvent(ctx, events.FeedEvent{
-			Type: feedv1.EventType_Comment,
-			Metadata: map[string]string{
-				// 评论者
-				"commentator": strconv.FormatInt(comment.Commentator.ID, 10),
-				// 被评论者
-				"recipient": strconv.FormatInt(comment.ReplyToUid, 10),
-				// 资源发布者，可能与被评论者相同
-				"bizPublisher": strconv.FormatInt(publisherId, 10),
-				"biz":          comment.Biz.String(),
-				"bizId":        strconv.FormatInt(comment.BizId, 10),
-				"commentId":    strconv.FormatInt(commentId, 10),
-			},
-		})
-		if er != nil {
-			s.l.Error("发送评论事件失败",
-				logger.Error(er),
-				logger.Int64("commentator", comment.Commentator.ID),
-				logger.Int64("recipient", comment.ReplyToUid),
-				logger.Int64("bizPublisher", publisherId),
-				logger.String("biz", comment.Biz.String()),
-				logger.Int64("bizId", comment.BizId),
-				logger.Int64("commentId", comment.Id))
+	// 在这里分配好 id，真正的落库交给 comment_write 的消费者批量去做，
+	// 落库之后再由消费者发送 feed 事件
+	id, err := s.idGen.Next()
+	if err != nil {
+		return 0, err
+	}
+	var anonymousSeq int32
+	if comment.Anonymous {
+		// 化名按楼编号，根评论的楼就是它自己
+		threadId := rootId
+		if threadId == 0 {
+			threadId = id
 		}
-	}()
-
-	return nil
+		anonymousSeq, err = s.aliasRepo.GetOrCreate(ctx, threadId, comment.Commentator.ID)
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = s.producer.ProduceCommentWriteEvent(ctx, events.CommentWriteEvent{
+		Id:           id,
+		Uid:          comment.Commentator.ID,
+		Biz:          int32(comment.Biz),
+		BizId:        comment.BizId,
+		RootId:       rootId,
+		Pid:          pid,
+		ReplyToUid:   comment.ReplyToUid,
+		Content:      comment.Content,
+		Format:       int32(comment.Format),
+		ContentHTML:  contentHTML,
+		AnonymousSeq: anonymousSeq,
+		Attachments:  attachments,
+		BizPublisher: publisherId,
+		Ctime:        time.Now().UnixMilli(),
+		// 被回复的是匿名评论的时候，对外不能暴露 ReplyToUid
+		ReplyToAnonymousSeq: replyToAnonymousSeq,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
 }
 
 func (s *commentService) GetComment(ctx context.Context, commentId int64) (domain.Comment, error) {
-	return s.repo.FindById(ctx, commentId)
-}
-
-func NewCommentSvc(repo repository.CommentRepository) CommentService {
-	return &commentService{
-		repo: repo,
-	}
+	c, err := s.repo.FindById(ctx, commentId)
+	return c.Masked(), err
 }
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/MuxiKeStack/be-comment/domain"
+)
+
+const (
+	defaultContextSiblings = 5
+	maxContextSiblings     = 50
+	// 沿着父评论往上找的最大层数，防止脏数据成环
+	maxAncestorDepth = 100
+)
+
+var ErrAncestorTooDeep = errors.New("评论的层级太深")
+
+func (s *commentService) GetCommentContext(ctx context.Context, commentId int64, k int64) (domain.CommentContext, error) {
+	comment, err := s.repo.FindById(ctx, commentId)
+	if err != nil {
+		return domain.CommentContext{}, err
+	}
+	res := domain.CommentContext{
+		Comment: comment,
+		Root:    comment,
+	}
+	// 从父评论开始往上找，找到的顺序是从下往上的
+	var ancestors []domain.Comment
+	cur := comment
+	for cur.ParentComment != nil && cur.ParentComment.Id != 0 {
+		if len(ancestors) >= maxAncestorDepth {
+			return domain.CommentContext{}, ErrAncestorTooDeep
+		}
+		cur, err = s.repo.FindById(ctx, cur.ParentComment.Id)
+		if err != nil {
+			return domain.CommentContext{}, err
+		}
+		ancestors = append(ancestors, cur)
+	}
+	if len(ancestors) > 0 {
+		res.Root = ancestors[len(ancestors)-1]
+		ancestors = ancestors[:len(ancestors)-1]
+	}
+	res.Ancestors = make([]domain.Comment, 0, len(ancestors))
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		res.Ancestors = append(res.Ancestors, ancestors[i])
+	}
+
+	res.Before, res.HasBefore, err = s.listSiblings(ctx, comment, true, k)
+	if err != nil {
+		return domain.CommentContext{}, err
+	}
+	res.After, res.HasAfter, err = s.listSiblings(ctx, comment, false, k)
+	if err != nil {
+		return domain.CommentContext{}, err
+	}
+	return res.Masked(), nil
+}
+
+func (s *commentService) ListSiblings(ctx context.Context, anchorId int64, before bool, limit int64) ([]domain.Comment, bool, error) {
+	anchor, err := s.repo.FindById(ctx, anchorId)
+	if err != nil {
+		return nil, false, err
+	}
+	cs, hasMore, err := s.listSiblings(ctx, anchor, before, limit)
+	return domain.MaskComments(cs), hasMore, err
+}
+
+// listSiblings 多取一条来判断还有没有更多
+func (s *commentService) listSiblings(ctx context.Context, anchor domain.Comment,
+	before bool, limit int64) ([]domain.Comment, bool, error) {
+	if limit <= 0 {
+		limit = defaultContextSiblings
+	}
+	limit = min(limit, maxContextSiblings)
+	cs, err := s.repo.FindSiblings(ctx, anchor, before, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(cs)) <= limit {
+		return cs, false, nil
+	}
+	if before {
+		// 升序排列的，离 anchor 最远的在最前面
+		return cs[1:], true, nil
+	}
+	return cs[:limit], true, nil
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	commentv1 "github.com/MuxiKeStack/be-api/gen/proto/comment/v1"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/MuxiKeStack/be-comment/repository"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	ExportFormatNDJSON = "ndjson"
+	ExportFormatCSV    = "csv"
+)
+
+// 每次从数据库里面取多少条
+const exportBatchSize = 500
+
+var (
+	ErrInvalidExportFilter = errors.New("导出至少要指定 biz、uid 或者时间范围中的一个")
+	ErrUnknownExportFormat = errors.New("不支持的导出格式")
+)
+
+// 导出的列，前面这些和老数据导入用的是同一套字段名，导出的文件可以直接重新导入，
+// 导入的时候不认识的列会被忽略。CSV 里面的 attachments 是 JSON 数组
+var exportColumns = []string{"id", "uid", "biz", "biz_id", "root_id", "pid", "reply_to_uid", "content", "ctime", "utime",
+	"format", "anonymous_seq", "reply_to_anonymous_seq", "attachments"}
+
+type CommentExportService interface {
+	// Export 把符合条件的评论按 id 升序写到 w 里面，父评论一定在回复前面，返回写了多少条
+	Export(ctx context.Context, filter domain.CommentFilter, format string, w io.Writer) (int64, error)
+}
+
+type commentExportService struct {
+	repo repository.CommentRepository
+}
+
+func NewCommentExportService(repo repository.CommentRepository) CommentExportService {
+	return &commentExportService{repo: repo}
+}
+
+func (s *commentExportService) Export(ctx context.Context, filter domain.CommentFilter,
+	format string, w io.Writer) (int64, error) {
+	if filter.Biz == 0 && filter.Uid == 0 && filter.StartTime.IsZero() && filter.EndTime.IsZero() {
+		return 0, ErrInvalidExportFilter
+	}
+	enc, err := newExportEncoder(format, w)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		curId int64
+		total int64
+	)
+	for {
+		cs, er := s.repo.FindByFilter(ctx, filter, curId, exportBatchSize)
+		if er != nil {
+			return total, er
+		}
+		for _, c := range cs {
+			er = enc.Encode(c)
+			if er != nil {
+				return total, er
+			}
+			total++
+		}
+		if len(cs) < exportBatchSize {
+			break
+		}
+		curId = cs[len(cs)-1].Id
+	}
+	return total, enc.Flush()
+}
+
+// ParseBiz biz 可以是数字，也可以是枚举的名字，比如 Evaluation
+func ParseBiz(val string) (commentv1.Biz, error) {
+	val = strings.TrimSpace(val)
+	if biz, err := strconv.ParseInt(val, 10, 32); err == nil {
+		if _, ok := commentv1.Biz_name[int32(biz)]; ok {
+			return commentv1.Biz(biz), nil
+		}
+	}
+	if biz, ok := commentv1.Biz_value[val]; ok {
+		return commentv1.Biz(biz), nil
+	}
+	return 0, fmt.Errorf("%w: %s", ErrInvalidBiz, val)
+}
+
+type exportEncoder interface {
+	Encode(c domain.Comment) error
+	Flush() error
+}
+
+func newExportEncoder(format string, w io.Writer) (exportEncoder, error) {
+	switch format {
+	case "", ExportFormatNDJSON:
+		return &ndjsonExportEncoder{enc: json.NewEncoder(w)}, nil
+	case ExportFormatCSV:
+		cw := csv.NewWriter(w)
+		err := cw.Write(exportColumns)
+		if err != nil {
+			return nil, err
+		}
+		return &csvExportEncoder{w: cw}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownExportFormat, format)
+	}
+}
+
+type exportRecord struct {
+	Id         int64  `json:"id"`
+	Uid        int64  `json:"uid"`
+	Biz        string `json:"biz"`
+	BizId      int64  `json:"biz_id"`
+	RootId     int64  `json:"root_id"`
+	Pid        int64  `json:"pid"`
+	ReplyToUid int64  `json:"reply_to_uid"`
+	Content    string `json:"content"`
+	Ctime      int64  `json:"ctime"`
+	Utime      int64  `json:"utime"`
+	// 0 是纯文本，1 是 Markdown
+	Format int32 `json:"format"`
+	// 匿名评论的化名编号，真实的评论者依旧在 uid 里面
+	AnonymousSeq        int32               `json:"anonymous_seq"`
+	ReplyToAnonymousSeq int32               `json:"reply_to_anonymous_seq"`
+	Attachments         []domain.Attachment `json:"attachments"`
+}
+
+func toExportRecord(c domain.Comment) exportRecord {
+	rec := exportRecord{
+		Id:         c.Id,
+		Uid:        c.Commentator.ID,
+		Biz:        c.Biz.String(),
+		BizId:      c.BizId,
+		ReplyToUid: c.ReplyToUid,
+		Content:    c.Content,
+		Ctime:      c.CTime.UnixMilli(),
+		Utime:      c.UTime.UnixMilli(),
+		Format:     int32(c.Format),
+		// 导出给管理后台的，不抹掉匿名评论者
+		AnonymousSeq:        c.AnonymousSeq,
+		ReplyToAnonymousSeq: c.ReplyToAnonymousSeq,
+		Attachments:         c.Attachments,
+	}
+	if rec.Attachments == nil {
+		rec.Attachments = []domain.Attachment{}
+	}
+	if c.RootComment != nil {
+		rec.RootId = c.RootComment.Id
+	}
+	if c.ParentComment != nil {
+		rec.Pid = c.ParentComment.Id
+	}
+	return rec
+}
+
+type ndjsonExportEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonExportEncoder) Encode(c domain.Comment) error {
+	return e.enc.Encode(toExportRecord(c))
+}
+
+func (e *ndjsonExportEncoder) Flush() error {
+	return nil
+}
+
+type csvExportEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvExportEncoder) Encode(c domain.Comment) error {
+	rec := toExportRecord(c)
+	attachments, err := json.Marshal(rec.Attachments)
+	if err != nil {
+		return err
+	}
+	return e.w.Write([]string{
+		strconv.FormatInt(rec.Id, 10),
+		strconv.FormatInt(rec.Uid, 10),
+		rec.Biz,
+		strconv.FormatInt(rec.BizId, 10),
+		strconv.FormatInt(rec.RootId, 10),
+		strconv.FormatInt(rec.Pid, 10),
+		strconv.FormatInt(rec.ReplyToUid, 10),
+		rec.Content,
+		strconv.FormatInt(rec.Ctime, 10),
+		strconv.FormatInt(rec.Utime, 10),
+		strconv.Itoa(int(rec.Format)),
+		strconv.Itoa(int(rec.AnonymousSeq)),
+		strconv.Itoa(int(rec.ReplyToAnonymousSeq)),
+		string(attachments),
+	})
+}
+
+func (e *csvExportEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	commentv1 "github.com/MuxiKeStack/be-api/gen/proto/comment/v1"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/MuxiKeStack/be-comment/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memoryFilterRepo 导出只用到 FindByFilter
+type memoryFilterRepo struct {
+	repository.CommentRepository
+	comments []domain.Comment
+}
+
+func (r *memoryFilterRepo) FindByFilter(ctx context.Context, filter domain.CommentFilter,
+	curCommentId int64, limit int64) ([]domain.Comment, error) {
+	var res []domain.Comment
+	for _, c := range r.comments {
+		if c.Id > curCommentId && int64(len(res)) < limit {
+			res = append(res, c)
+		}
+	}
+	return res, nil
+}
+
+func TestCommentExportService_Export(t *testing.T) {
+	ctime := time.UnixMilli(1709251200000)
+	comments := []domain.Comment{
+		{
+			Id:           1,
+			Commentator:  domain.User{ID: 10},
+			Biz:          commentv1.Biz_Evaluation,
+			BizId:        100,
+			Content:      "**好课**",
+			Format:       domain.ContentFormatMarkdown,
+			CTime:        ctime,
+			UTime:        ctime,
+			Anonymous:    true,
+			AnonymousSeq: 2,
+			Attachments: []domain.Attachment{
+				{ObjectKey: "a.png", MimeType: "image/png", Size: 3, Width: 1, Height: 1, Checksum: "abc"},
+			},
+		},
+		{
+			Id:                  2,
+			Commentator:         domain.User{ID: 11},
+			Biz:                 commentv1.Biz_Evaluation,
+			BizId:               100,
+			Content:             "同意",
+			RootComment:         &domain.Comment{Id: 1},
+			ParentComment:       &domain.Comment{Id: 1},
+			ReplyToUid:          10,
+			ReplyToAnonymousSeq: 2,
+			CTime:               ctime,
+			UTime:               ctime,
+		},
+	}
+	testCases := []struct {
+		name    string
+		format  string
+		filter  domain.CommentFilter
+		wantErr error
+		want    string
+	}{
+		{
+			name:   "ndjson",
+			format: ExportFormatNDJSON,
+			filter: domain.CommentFilter{Biz: commentv1.Biz_Evaluation},
+			want: `{"id":1,"uid":10,"biz":"{biz}","biz_id":100,"root_id":0,"pid":0,"reply_to_uid":0,"content":"**好课**","ctime":1709251200000,"utime":1709251200000,"format":1,"anonymous_seq":2,"reply_to_anonymous_seq":0,"attachments":[{"objectKey":"a.png","mimeType":"image/png","size":3,"width":1,"height":1,"checksum":"abc"}]}
+{"id":2,"uid":11,"biz":"{biz}","biz_id":100,"root_id":1,"pid":1,"reply_to_uid":10,"content":"同意","ctime":1709251200000,"utime":1709251200000,"format":0,"anonymous_seq":0,"reply_to_anonymous_seq":2,"attachments":[]}
+`,
+		},
+		{
+			name:   "csv",
+			format: ExportFormatCSV,
+			filter: domain.CommentFilter{Biz: commentv1.Biz_Evaluation},
+			want: `id,uid,biz,biz_id,root_id,pid,reply_to_uid,content,ctime,utime,format,anonymous_seq,reply_to_anonymous_seq,attachments
+1,10,{biz},100,0,0,0,**好课**,1709251200000,1709251200000,1,2,0,"[{""objectKey"":""a.png"",""mimeType"":""image/png"",""size"":3,""width"":1,""height"":1,""checksum"":""abc""}]"
+2,11,{biz},100,1,1,10,同意,1709251200000,1709251200000,0,0,2,[]
+`,
+		},
+		{
+			name:    "没有筛选条件",
+			format:  ExportFormatNDJSON,
+			wantErr: ErrInvalidExportFilter,
+		},
+		{
+			name:    "不支持的格式",
+			format:  "xlsx",
+			filter:  domain.CommentFilter{Uid: 10},
+			wantErr: ErrUnknownExportFormat,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := NewCommentExportService(&memoryFilterRepo{comments: comments})
+			var buf bytes.Buffer
+			n, err := svc.Export(context.Background(), tc.filter, tc.format, &buf)
+			assert.ErrorIs(t, err, tc.wantErr)
+			if err != nil {
+				return
+			}
+			require.Equal(t, int64(len(comments)), n)
+			// biz 按照枚举的名字导出
+			want := strings.ReplaceAll(tc.want, "{biz}", commentv1.Biz_Evaluation.String())
+			assert.Equal(t, want, buf.String())
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"errors"
+	commentv1 "github.com/MuxiKeStack/be-api/gen/proto/comment/v1"
+	"github.com/MuxiKeStack/be-comment/domain"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var ErrInvalidSort = errors.New("不支持的排序方式")
+
+func (s *commentService) ListComments(ctx context.Context, biz commentv1.Biz, bizId int64,
+	sort domain.SortMode, cursor string, dir domain.PageDirection, limit int64, viewerUid int64) (domain.CommentPage, error) {
+	blocked, err := s.blockedBy(ctx, viewerUid)
+	if err != nil {
+		return domain.CommentPage{}, err
+	}
+	return s.listPage(sort, cursor, dir, limit, blocked,
+		func(c *domain.Cursor, dir domain.PageDirection, limit int64) ([]domain.Comment, error) {
+			return s.repo.FindByBizPage(ctx, biz, bizId, sort, c, dir, limit)
+		})
+}
+
+func (s *commentService) ListReplies(ctx context.Context, rid int64,
+	sort domain.SortMode, cursor string, dir domain.PageDirection, limit int64, viewerUid int64) (domain.CommentPage, error) {
+	blocked, err := s.blockedBy(ctx, viewerUid)
+	if err != nil {
+		return domain.CommentPage{}, err
+	}
+	return s.listPage(sort, cursor, dir, limit, blocked,
+		func(c *domain.Cursor, dir domain.PageDirection, limit int64) ([]domain.Comment, error) {
+			return s.repo.FindRepliesPage(ctx, rid, sort, c, dir, limit)
+		})
+}
+
+// ListCommentsSince 按写入序号而不是 id 判断新评论：异步落库的时候 id 小的评论可能更晚才写进来，
+// 按 id 判断的话它永远不会被当作新评论
+func (s *commentService) ListCommentsSince(ctx context.Context, biz commentv1.Biz, bizId int64,
+	sinceSeq int64, limit int64, viewerUid int64) (domain.NewComments, error) {
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	limit = min(limit, maxPageSize)
+	var blocked []int64
+	if viewerUid != 0 {
+		var err error
+		blocked, err = s.blockRepo.FindBlocked(ctx, viewerUid)
+		if err != nil {
+			return domain.NewComments{}, err
+		}
+	}
+	count, err := s.repo.CountByBizSince(ctx, biz, bizId, sinceSeq, blocked)
+	if err != nil || count == 0 {
+		return domain.NewComments{Count: count}, err
+	}
+	cs, err := s.repo.FindByBizSince(ctx, biz, bizId, sinceSeq, blocked, limit)
+	if err != nil {
+		return domain.NewComments{}, err
+	}
+	// 两次查询之间可能又有新评论，数量至少要和返回的一样多
+	return domain.NewComments{Comments: domain.MaskComments(cs), Count: max(count, int64(len(cs)))}, nil
+}
+
+// listPage 多取一条来判断这个方向上还有没有下一页，另一个方向只要游标不为空就认为还有。
+// 被拉黑的人的评论过滤掉之后不够的话，从取到的最远的那一条继续取。
+// NextCursor 是这一页最后一条的位置，PrevCursor 是第一条的位置
+func (s *commentService) listPage(sort domain.SortMode, cursor string, dir domain.PageDirection, limit int64,
+	blocked map[int64]struct{},
+	find func(c *domain.Cursor, dir domain.PageDirection, limit int64) ([]domain.Comment, error)) (domain.CommentPage, error) {
+	if !sort.Valid() {
+		return domain.CommentPage{}, ErrInvalidSort
+	}
+	c, err := DecodeCursor(cursor, sort)
+	if err != nil {
+		return domain.CommentPage{}, err
+	}
+	// 没有游标的时候往前翻没有意义，当作第一页
+	if c == nil || dir != domain.PagePrev {
+		dir = domain.PageNext
+	}
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	limit = min(limit, maxPageSize)
+	var cs []domain.Comment
+	for cur := c; ; {
+		chunk, er := find(cur, dir, limit+1)
+		if er != nil {
+			return domain.CommentPage{}, er
+		}
+		if len(chunk) == 0 {
+			break
+		}
+		// 往前翻的时候离游标最远的排在最前面
+		far := chunk[len(chunk)-1]
+		if dir == domain.PagePrev {
+			far = chunk[0]
+			cs = append(visible(chunk, blocked), cs...)
+		} else {
+			cs = append(cs, visible(chunk, blocked)...)
+		}
+		if int64(len(chunk)) <= limit || int64(len(cs)) > limit {
+			break
+		}
+		cur = &domain.Cursor{Sort: sort, Key: sort.Key(far), Id: far.Id}
+	}
+	res := domain.CommentPage{Comments: cs}
+	more := int64(len(cs)) > limit
+	if dir == domain.PagePrev {
+		// 多取的那一条离游标最远，排在最前面
+		if more {
+			res.Comments = cs[len(cs)-int(limit):]
+		}
+		res.HasPrev = more
+		res.HasMore = true
+	} else {
+		if more {
+			res.Comments = cs[:limit]
+		}
+		res.HasMore = more
+		res.HasPrev = c != nil
+	}
+	if len(res.Comments) > 0 {
+		first, last := res.Comments[0], res.Comments[len(res.Comments)-1]
+		res.PrevCursor = EncodeCursor(domain.Cursor{Sort: sort, Key: sort.Key(first), Id: first.Id})
+		res.NextCursor = EncodeCursor(domain.Cursor{Sort: sort, Key: sort.Key(last), Id: last.Id})
+	}
+	return res.Masked(), nil
+}
@@ -0,0 +1,361 @@
+package service
+
+import (
+	"context"
+	commentv1 "github.com/MuxiKeStack/be-api/gen/proto/comment/v1"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/MuxiKeStack/be-comment/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"slices"
+	"testing"
+	"time"
+)
+
+// memoryPageRepo 翻页只用到 FindByBizPage
+type memoryPageRepo struct {
+	repository.CommentRepository
+	comments []domain.Comment
+}
+
+func (r *memoryPageRepo) FindByBizPage(ctx context.Context, biz commentv1.Biz, bizId int64,
+	sort domain.SortMode, cursor *domain.Cursor, dir domain.PageDirection, limit int64) ([]domain.Comment, error) {
+	desc := sort != domain.SortOldest
+	// (k1, i1) 是不是排在 (k2, i2) 前面
+	precedes := func(k1, i1, k2, i2 int64) bool {
+		if k1 != k2 {
+			return (k1 > k2) == desc
+		}
+		return i1 != i2 && (i1 > i2) == desc
+	}
+	cs := slices.Clone(r.comments)
+	slices.SortFunc(cs, func(a, b domain.Comment) int {
+		if precedes(sort.Key(a), a.Id, sort.Key(b), b.Id) {
+			return -1
+		}
+		return 1
+	})
+	var res []domain.Comment
+	for _, c := range cs {
+		switch {
+		case cursor == nil,
+			dir == domain.PageNext && precedes(cursor.Key, cursor.Id, sort.Key(c), c.Id),
+			dir == domain.PagePrev && precedes(sort.Key(c), c.Id, cursor.Key, cursor.Id):
+			res = append(res, c)
+		}
+	}
+	// 往前翻取离游标最近的那些，也就是最后的几条
+	if int64(len(res)) > limit {
+		if dir == domain.PagePrev {
+			res = res[int64(len(res))-limit:]
+		} else {
+			res = res[:limit]
+		}
+	}
+	return res, nil
+}
+
+// FindByBizSince 后提交的在前
+func (r *memoryPageRepo) FindByBizSince(ctx context.Context, biz commentv1.Biz, bizId int64,
+	sinceSeq int64, excludeUids []int64, limit int64) ([]domain.Comment, error) {
+	var res []domain.Comment
+	for _, c := range r.comments {
+		if c.WriteSeq > sinceSeq && !slices.Contains(excludeUids, c.Commentator.ID) {
+			res = append(res, c)
+		}
+	}
+	slices.SortFunc(res, func(a, b domain.Comment) int {
+		return int(b.WriteSeq - a.WriteSeq)
+	})
+	if int64(len(res)) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+func (r *memoryPageRepo) CountByBizSince(ctx context.Context, biz commentv1.Biz, bizId int64,
+	sinceSeq int64, excludeUids []int64) (int64, error) {
+	cs, err := r.FindByBizSince(ctx, biz, bizId, sinceSeq, excludeUids, int64(len(r.comments)))
+	return int64(len(cs)), err
+}
+
+// testPageComments 3 和 4 发表的时间一样，1 是最近修改的
+func testPageComments() []domain.Comment {
+	ctimes := map[int64]int64{1: 1000, 2: 2000, 3: 3000, 4: 3000, 5: 5000}
+	utimes := map[int64]int64{1: 9000, 2: 2000, 3: 3000, 4: 4000, 5: 5000}
+	cs := make([]domain.Comment, 0, len(ctimes))
+	for id := int64(1); id <= 5; id++ {
+		cs = append(cs, domain.Comment{
+			Id:          id,
+			Commentator: domain.User{ID: id * 10},
+			CTime:       time.UnixMilli(ctimes[id]),
+			UTime:       time.UnixMilli(utimes[id]),
+		})
+	}
+	return cs
+}
+
+func commentIds(cs []domain.Comment) []int64 {
+	ids := make([]int64, 0, len(cs))
+	for _, c := range cs {
+		ids = append(ids, c.Id)
+	}
+	return ids
+}
+
+func TestCommentService_ListComments(t *testing.T) {
+	testCases := []struct {
+		name   string
+		sort   domain.SortMode
+		cursor string
+		limit  int64
+
+		// 一直往后翻，每一页的评论
+		wantPages [][]int64
+		wantErr   error
+	}{
+		{
+			name:      "先新后旧，时间一样的按 id",
+			sort:      domain.SortNewest,
+			limit:     2,
+			wantPages: [][]int64{{5, 4}, {3, 2}, {1}},
+		},
+		{
+			name:      "先旧后新",
+			sort:      domain.SortOldest,
+			limit:     2,
+			wantPages: [][]int64{{1, 2}, {3, 4}, {5}},
+		},
+		{
+			name:      "最近修改的在前",
+			sort:      domain.SortRecentlyUpdated,
+			limit:     3,
+			wantPages: [][]int64{{1, 5, 4}, {3, 2}},
+		},
+		{
+			name:      "刚好取完",
+			sort:      domain.SortNewest,
+			limit:     5,
+			wantPages: [][]int64{{5, 4, 3, 2, 1}},
+		},
+		{
+			name:      "不传 limit 用默认的",
+			sort:      domain.SortNewest,
+			wantPages: [][]int64{{5, 4, 3, 2, 1}},
+		},
+		{
+			name:    "不支持的排序方式",
+			sort:    domain.SortMode(100),
+			wantErr: ErrInvalidSort,
+		},
+		{
+			name:    "游标和排序方式对不上",
+			sort:    domain.SortOldest,
+			cursor:  EncodeCursor(domain.Cursor{Sort: domain.SortNewest, Key: 3000, Id: 3}),
+			wantErr: ErrInvalidCursor,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := &commentService{repo: &memoryPageRepo{comments: testPageComments()}}
+			var pages [][]int64
+			cursor := tc.cursor
+			for {
+				page, err := svc.ListComments(context.Background(), commentv1.Biz_Evaluation, 1,
+					tc.sort, cursor, domain.PageNext, tc.limit, 0)
+				if tc.wantErr != nil {
+					assert.ErrorIs(t, err, tc.wantErr)
+					return
+				}
+				require.NoError(t, err)
+				pages = append(pages, commentIds(page.Comments))
+				if !page.HasMore {
+					break
+				}
+				require.Less(t, len(pages), 10, "翻页停不下来")
+				cursor = page.NextCursor
+			}
+			assert.Equal(t, tc.wantPages, pages)
+		})
+	}
+}
+
+func TestCommentService_ListComments_Direction(t *testing.T) {
+	// 先新后旧是 5 4 3 2 1，评论 id 的发布者是 id * 10
+	cursorAt := func(id int64) string {
+		for _, c := range testPageComments() {
+			if c.Id == id {
+				return EncodeCursor(domain.Cursor{Sort: domain.SortNewest, Key: domain.SortNewest.Key(c), Id: c.Id})
+			}
+		}
+		return ""
+	}
+	testCases := []struct {
+		name    string
+		cursor  string
+		dir     domain.PageDirection
+		limit   int64
+		blocked []int64
+
+		wantIds     []int64
+		wantHasPrev bool
+		wantHasMore bool
+	}{
+		{
+			name:        "往后翻",
+			cursor:      cursorAt(4),
+			dir:         domain.PageNext,
+			limit:       2,
+			wantIds:     []int64{3, 2},
+			wantHasPrev: true,
+			wantHasMore: true,
+		},
+		{
+			name:        "往前翻，前面还有",
+			cursor:      cursorAt(2),
+			dir:         domain.PagePrev,
+			limit:       2,
+			wantIds:     []int64{4, 3},
+			wantHasPrev: true,
+			wantHasMore: true,
+		},
+		{
+			name:        "往前翻到第一页",
+			cursor:      cursorAt(3),
+			dir:         domain.PagePrev,
+			limit:       2,
+			wantIds:     []int64{5, 4},
+			wantHasMore: true,
+		},
+		{
+			name:        "没有游标往前翻当作第一页",
+			dir:         domain.PagePrev,
+			limit:       2,
+			wantIds:     []int64{5, 4},
+			wantHasMore: true,
+		},
+		{
+			name:        "往后翻过滤掉拉黑的人之后接着取",
+			dir:         domain.PageNext,
+			limit:       2,
+			blocked:     []int64{30, 20},
+			wantIds:     []int64{5, 4},
+			wantHasMore: true,
+		},
+		{
+			name:    "往后翻过滤之后剩下的不够一页",
+			cursor:  cursorAt(4),
+			dir:     domain.PageNext,
+			limit:   2,
+			blocked: []int64{30, 20},
+			wantIds: []int64{1},
+			// 游标不为空
+			wantHasPrev: true,
+		},
+		{
+			name:    "往前翻过滤掉拉黑的人之后接着取",
+			cursor:  cursorAt(2),
+			dir:     domain.PagePrev,
+			limit:   2,
+			blocked: []int64{40},
+			wantIds: []int64{5, 3},
+			// 游标不为空
+			wantHasMore: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := &commentService{
+				repo:      &memoryPageRepo{comments: testPageComments()},
+				blockRepo: &memoryBlockRepo{blocked: map[int64][]int64{1: tc.blocked}},
+			}
+			page, err := svc.ListComments(context.Background(), commentv1.Biz_Evaluation, 1,
+				domain.SortNewest, tc.cursor, tc.dir, tc.limit, 1)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantIds, commentIds(page.Comments))
+			assert.Equal(t, tc.wantHasPrev, page.HasPrev)
+			assert.Equal(t, tc.wantHasMore, page.HasMore)
+		})
+	}
+}
+
+func TestCommentService_ListComments_RoundTrip(t *testing.T) {
+	svc := &commentService{repo: &memoryPageRepo{comments: testPageComments()}}
+	ctx := context.Background()
+	list := func(cursor string, dir domain.PageDirection) domain.CommentPage {
+		page, err := svc.ListComments(ctx, commentv1.Biz_Evaluation, 1, domain.SortNewest, cursor, dir, 2, 0)
+		require.NoError(t, err)
+		return page
+	}
+	first := list("", domain.PageNext)
+	assert.Equal(t, []int64{5, 4}, commentIds(first.Comments))
+	second := list(first.NextCursor, domain.PageNext)
+	assert.Equal(t, []int64{3, 2}, commentIds(second.Comments))
+	// 从第二页往前翻回到第一页
+	back := list(second.PrevCursor, domain.PagePrev)
+	assert.Equal(t, commentIds(first.Comments), commentIds(back.Comments))
+	assert.False(t, back.HasPrev)
+	assert.Equal(t, first.NextCursor, back.NextCursor)
+}
+
+func TestCommentService_ListCommentsSince(t *testing.T) {
+	// 评论 id 的发布者是 id * 10，3 是异步落库的时候最晚写进来的
+	writeSeqs := map[int64]int64{1: 1, 2: 2, 3: 5, 4: 3, 5: 4}
+	testCases := []struct {
+		name     string
+		sinceSeq int64
+		limit    int64
+		blocked  []int64
+
+		wantIds   []int64
+		wantCount int64
+	}{
+		{
+			name:     "id 小但是晚落库的也是新评论",
+			sinceSeq: 4,
+			wantIds:  []int64{3},
+			// 按 id 的话 5 之后就没有新评论了
+			wantCount: 1,
+		},
+		{
+			name:      "数量比返回的多",
+			sinceSeq:  2,
+			limit:     2,
+			wantIds:   []int64{3, 5},
+			wantCount: 3,
+		},
+		{
+			name:      "没有新评论",
+			sinceSeq:  5,
+			wantCount: 0,
+		},
+		{
+			name:      "拉黑的人的评论不算",
+			sinceSeq:  2,
+			blocked:   []int64{30, 40},
+			wantIds:   []int64{5},
+			wantCount: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cs := testPageComments()
+			for i := range cs {
+				cs[i].WriteSeq = writeSeqs[cs[i].Id]
+			}
+			svc := &commentService{
+				repo:      &memoryPageRepo{comments: cs},
+				blockRepo: &memoryBlockRepo{blocked: map[int64][]int64{1: tc.blocked}},
+			}
+			res, err := svc.ListCommentsSince(context.Background(), commentv1.Biz_Evaluation, 1,
+				tc.sinceSeq, tc.limit, 1)
+			require.NoError(t, err)
+			if tc.wantIds == nil {
+				assert.Empty(t, res.Comments)
+			} else {
+				assert.Equal(t, tc.wantIds, commentIds(res.Comments))
+			}
+			assert.Equal(t, tc.wantCount, res.Count)
+		})
+	}
+}
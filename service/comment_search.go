@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/MuxiKeStack/be-comment/repository"
+	"github.com/ecodeclub/ekit/slice"
+	"html"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// 关键词太短的时候 ngram 索引用不上
+	minKeywordLength = 2
+	maxKeywordLength = 32
+	maxKeywords      = 5
+)
+
+var ErrInvalidKeyword = errors.New("关键词为空或者太短")
+
+type CommentSearchService interface {
+	// Search keyword 按空白切分成多个关键词，评论内容要包含所有的关键词。
+	// 结果先新后旧，curCommentId 是上一页最后一条的 id，第一页传 0
+	Search(ctx context.Context, keyword string, filter domain.CommentFilter, curCommentId int64, limit int64) (domain.CommentSearchResult, error)
+}
+
+type commentSearchService struct {
+	repo repository.CommentSearchRepository
+}
+
+func NewCommentSearchService(repo repository.CommentSearchRepository) CommentSearchService {
+	return &commentSearchService{repo: repo}
+}
+
+func (s *commentSearchService) Search(ctx context.Context, keyword string, filter domain.CommentFilter,
+	curCommentId int64, limit int64) (domain.CommentSearchResult, error) {
+	keywords, err := parseKeywords(keyword)
+	if err != nil {
+		return domain.CommentSearchResult{}, err
+	}
+	if curCommentId <= 0 {
+		curCommentId = math.MaxInt64
+	}
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	limit = min(limit, maxPageSize)
+	total, err := s.repo.Count(ctx, keywords, filter)
+	if err != nil {
+		return domain.CommentSearchResult{}, err
+	}
+	cs, err := s.repo.Search(ctx, keywords, filter, curCommentId, limit+1)
+	if err != nil {
+		return domain.CommentSearchResult{}, err
+	}
+	res := domain.CommentSearchResult{Total: total}
+	if int64(len(cs)) > limit {
+		cs = cs[:limit]
+		res.HasMore = true
+	}
+	res.Hits = slice.Map(cs, func(idx int, src domain.Comment) domain.CommentSearchHit {
+		return domain.CommentSearchHit{Comment: src.Masked(), Highlight: highlight(src.Content, keywords)}
+	})
+	return res, nil
+}
+
+// parseKeywords 去掉重复的，太长的截断，最多保留 maxKeywords 个。
+// 每个关键词本来就是按短语匹配的，双引号没有意义，先去掉，不然高亮的时候找不到
+func parseKeywords(keyword string) ([]string, error) {
+	var res []string
+	for _, kw := range strings.Fields(strings.ReplaceAll(keyword, `"`, "")) {
+		if utf8.RuneCountInString(kw) < minKeywordLength {
+			return nil, ErrInvalidKeyword
+		}
+		if runes := []rune(kw); len(runes) > maxKeywordLength {
+			kw = string(runes[:maxKeywordLength])
+		}
+		if !slice.Contains(res, kw) {
+			res = append(res, kw)
+		}
+		if len(res) == maxKeywords {
+			break
+		}
+	}
+	if len(res) == 0 {
+		return nil, ErrInvalidKeyword
+	}
+	return res, nil
+}
+
+// highlight 不区分大小写地找出所有关键词，重叠的合并成一段，其余部分做 HTML 转义
+func highlight(content string, keywords []string) string {
+	runes := []rune(content)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	hit := make([]bool, len(runes))
+	for _, kw := range keywords {
+		kr := []rune(strings.ToLower(kw))
+		for i := 0; i+len(kr) <= len(lower); i++ {
+			if string(lower[i:i+len(kr)]) == string(kr) {
+				for j := i; j < i+len(kr); j++ {
+					hit[j] = true
+				}
+			}
+		}
+	}
+	var sb strings.Builder
+	for i := 0; i < len(runes); {
+		j := i
+		for j < len(runes) && hit[j] == hit[i] {
+			j++
+		}
+		seg := html.EscapeString(string(runes[i:j]))
+		if hit[i] {
+			sb.WriteString("<em>")
+			sb.WriteString(seg)
+			sb.WriteString("</em>")
+		} else {
+			sb.WriteString(seg)
+		}
+		i = j
+	}
+	return sb.String()
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	commentv1 "github.com/MuxiKeStack/be-api/gen/proto/comment/v1"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/MuxiKeStack/be-comment/repository"
+	"github.com/MuxiKeStack/be-comment/repository/dao"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCommentSearchService_Search(t *testing.T) {
+	searchDAO := dao.NewMemoryCommentSearchDAO()
+	searchDAO.Add(
+		dao.Comment{Id: 1, Uid: 10, Biz: int32(commentv1.Biz_Evaluation), Content: "这门课是好课"},
+		dao.Comment{Id: 2, Uid: 11, Biz: int32(commentv1.Biz_Evaluation), Content: "<b>好课</b> & 好老师"},
+		dao.Comment{Id: 3, Uid: 12, Biz: int32(commentv1.Biz_Answer), Content: "Go 语言，GO 好课"},
+		dao.Comment{Id: 4, Uid: 13, Biz: int32(commentv1.Biz_Evaluation), Content: "好课好课", AnonymousSeq: 1},
+	)
+	svc := NewCommentSearchService(repository.NewCommentSearchRepository(searchDAO))
+	testCases := []struct {
+		name         string
+		keyword      string
+		filter       domain.CommentFilter
+		curCommentId int64
+		limit        int64
+
+		wantIds        []int64
+		wantHighlights []string
+		wantTotal      int64
+		wantHasMore    bool
+		wantErr        error
+	}{
+		{
+			name:           "先新后旧，命中的地方高亮",
+			keyword:        "好课",
+			filter:         domain.CommentFilter{Biz: commentv1.Biz_Evaluation},
+			wantIds:        []int64{4, 2, 1},
+			wantHighlights: []string{"<em>好课好课</em>", "&lt;b&gt;<em>好课</em>&lt;/b&gt; &amp; 好老师", "这门课是<em>好课</em>"},
+			wantTotal:      3,
+		},
+		{
+			name:           "多个关键词都要出现",
+			keyword:        "好课 老师",
+			wantIds:        []int64{2},
+			wantHighlights: []string{"&lt;b&gt;<em>好课</em>&lt;/b&gt; &amp; 好<em>老师</em>"},
+			wantTotal:      1,
+		},
+		{
+			name:           "重叠的命中合并成一段",
+			keyword:        "好课 课好",
+			wantIds:        []int64{4},
+			wantHighlights: []string{"<em>好课好课</em>"},
+			wantTotal:      1,
+		},
+		{
+			name:           "不区分大小写",
+			keyword:        "go 好课",
+			wantIds:        []int64{3},
+			wantHighlights: []string{"<em>Go</em> 语言，<em>GO</em> <em>好课</em>"},
+			wantTotal:      1,
+		},
+		{
+			name:           "双引号去掉之后按短语匹配",
+			keyword:        `"好老师"`,
+			wantIds:        []int64{2},
+			wantHighlights: []string{"&lt;b&gt;好课&lt;/b&gt; &amp; <em>好老师</em>"},
+			wantTotal:      1,
+		},
+		{
+			name:           "翻页",
+			keyword:        "好课",
+			curCommentId:   4,
+			limit:          1,
+			wantIds:        []int64{3},
+			wantHighlights: []string{"Go 语言，GO <em>好课</em>"},
+			wantTotal:      4,
+			wantHasMore:    true,
+		},
+		{
+			name:    "只有双引号",
+			keyword: `"" ""`,
+			wantErr: ErrInvalidKeyword,
+		},
+		{
+			name:    "关键词太短",
+			keyword: "好",
+			wantErr: ErrInvalidKeyword,
+		},
+		{
+			name:    "空白",
+			keyword: "  ",
+			wantErr: ErrInvalidKeyword,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := svc.Search(context.Background(), tc.keyword, tc.filter, tc.curCommentId, tc.limit)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			ids := make([]int64, 0, len(res.Hits))
+			highlights := make([]string, 0, len(res.Hits))
+			for _, hit := range res.Hits {
+				ids = append(ids, hit.Comment.Id)
+				highlights = append(highlights, hit.Highlight)
+			}
+			assert.Equal(t, tc.wantIds, ids)
+			assert.Equal(t, tc.wantHighlights, highlights)
+			assert.Equal(t, tc.wantTotal, res.Total)
+			assert.Equal(t, tc.wantHasMore, res.HasMore)
+		})
+	}
+}
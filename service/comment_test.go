@@ -0,0 +1,252 @@
+package service
+
+import (
+	"context"
+	commentv1 "github.com/MuxiKeStack/be-api/gen/proto/comment/v1"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/MuxiKeStack/be-comment/events"
+	"github.com/MuxiKeStack/be-comment/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type fakeUIDGetter struct {
+	uid int64
+}
+
+func (g fakeUIDGetter) GetUID(ctx context.Context, bizId int64) (int64, error) {
+	return g.uid, nil
+}
+
+// memoryCommentRepo 发评论只用到 FindById
+type memoryCommentRepo struct {
+	repository.CommentRepository
+	comments map[int64]domain.Comment
+}
+
+func (r *memoryCommentRepo) FindById(ctx context.Context, commentId int64) (domain.Comment, error) {
+	c, ok := r.comments[commentId]
+	if !ok {
+		return domain.Comment{}, repository.ErrCommentNotFound
+	}
+	return c, nil
+}
+
+// memoryAliasRepo seqs[rootId][uid] 是化名编号
+type memoryAliasRepo struct {
+	seqs map[int64]map[int64]int32
+}
+
+func (r *memoryAliasRepo) GetOrCreate(ctx context.Context, rootId int64, uid int64) (int32, error) {
+	thread, ok := r.seqs[rootId]
+	if !ok {
+		thread = make(map[int64]int32)
+		r.seqs[rootId] = thread
+	}
+	seq, ok := thread[uid]
+	if !ok {
+		seq = int32(len(thread) + 1)
+		thread[uid] = seq
+	}
+	return seq, nil
+}
+
+type fakeProducer struct {
+	events.Producer
+	writes []events.CommentWriteEvent
+}
+
+func (p *fakeProducer) ProduceCommentWriteEvent(ctx context.Context, evt events.CommentWriteEvent) error {
+	p.writes = append(p.writes, evt)
+	return nil
+}
+
+type fakeIdGenerator struct {
+	next int64
+}
+
+func (g *fakeIdGenerator) Next() (int64, error) {
+	g.next++
+	return g.next, nil
+}
+
+func TestCommentService_CreateComment(t *testing.T) {
+	const bizPublisher = 1
+	// 100 是匿名的根评论，101 是它下面的回复，200 是另一个楼
+	existing := map[int64]domain.Comment{
+		100: {Id: 100, Commentator: domain.User{ID: 20}, Biz: commentv1.Biz_Evaluation, BizId: 1, AnonymousSeq: 1},
+		101: {
+			Id: 101, Commentator: domain.User{ID: 21}, Biz: commentv1.Biz_Evaluation, BizId: 1,
+			RootComment: &domain.Comment{Id: 100}, ParentComment: &domain.Comment{Id: 100},
+		},
+		200: {Id: 200, Commentator: domain.User{ID: 22}, Biz: commentv1.Biz_Evaluation, BizId: 1},
+	}
+	testCases := []struct {
+		name    string
+		comment domain.Comment
+		blocked map[int64][]int64
+
+		wantEvent events.CommentWriteEvent
+		// 发表之后每个楼的化名编号
+		wantSeqs map[int64]map[int64]int32
+		wantErr  error
+	}{
+		{
+			name: "匿名的根评论自己就是楼",
+			comment: domain.Comment{
+				Commentator: domain.User{ID: 10},
+				Anonymous:   true,
+			},
+			wantEvent: events.CommentWriteEvent{
+				Id: 1000, Uid: 10, ReplyToUid: bizPublisher, AnonymousSeq: 1,
+			},
+			wantSeqs: map[int64]map[int64]int32{
+				100:  {20: 1},
+				1000: {10: 1},
+			},
+		},
+		{
+			name: "同一个楼里面编号不变",
+			comment: domain.Comment{
+				Commentator:   domain.User{ID: 20},
+				ParentComment: &domain.Comment{Id: 101},
+				Anonymous:     true,
+			},
+			wantEvent: events.CommentWriteEvent{
+				Id: 1000, Uid: 20, RootId: 100, Pid: 101, ReplyToUid: 21, AnonymousSeq: 1,
+			},
+			wantSeqs: map[int64]map[int64]int32{
+				100: {20: 1},
+			},
+		},
+		{
+			name: "回复楼里面的回复按根评论编号",
+			comment: domain.Comment{
+				Commentator:   domain.User{ID: 10},
+				ParentComment: &domain.Comment{Id: 101},
+				Anonymous:     true,
+			},
+			wantEvent: events.CommentWriteEvent{
+				Id: 1000, Uid: 10, RootId: 100, Pid: 101, ReplyToUid: 21, AnonymousSeq: 2,
+			},
+			wantSeqs: map[int64]map[int64]int32{
+				100: {20: 1, 10: 2},
+			},
+		},
+		{
+			name: "换一个楼重新编号",
+			comment: domain.Comment{
+				Commentator:   domain.User{ID: 20},
+				ParentComment: &domain.Comment{Id: 200},
+				Anonymous:     true,
+			},
+			wantEvent: events.CommentWriteEvent{
+				Id: 1000, Uid: 20, RootId: 200, Pid: 200, ReplyToUid: 22, AnonymousSeq: 1,
+			},
+			wantSeqs: map[int64]map[int64]int32{
+				100: {20: 1},
+				200: {20: 1},
+			},
+		},
+		{
+			name: "回复匿名评论带上对方的编号",
+			comment: domain.Comment{
+				Commentator:   domain.User{ID: 10},
+				ParentComment: &domain.Comment{Id: 100},
+			},
+			wantEvent: events.CommentWriteEvent{
+				Id: 1000, Uid: 10, RootId: 100, Pid: 100, ReplyToUid: 20, ReplyToAnonymousSeq: 1,
+			},
+			wantSeqs: map[int64]map[int64]int32{
+				100: {20: 1},
+			},
+		},
+		{
+			name: "被父评论的作者拉黑了",
+			comment: domain.Comment{
+				Commentator:   domain.User{ID: 10},
+				ParentComment: &domain.Comment{Id: 200},
+			},
+			blocked: map[int64][]int64{22: {10}},
+			wantErr: ErrBlockedByAuthor,
+		},
+		{
+			name: "@ 的人没有拉黑",
+			comment: domain.Comment{
+				Commentator: domain.User{ID: 10},
+				MentionUids: []int64{21, 22},
+			},
+			blocked: map[int64][]int64{21: {11}},
+			wantEvent: events.CommentWriteEvent{
+				Id: 1000, Uid: 10, ReplyToUid: bizPublisher,
+			},
+			wantSeqs: map[int64]map[int64]int32{
+				100: {20: 1},
+			},
+		},
+		{
+			name: "被 @ 的人拉黑了",
+			comment: domain.Comment{
+				Commentator: domain.User{ID: 10},
+				MentionUids: []int64{21, 22},
+			},
+			blocked: map[int64][]int64{22: {10}},
+			wantErr: ErrBlockedByMentioned,
+		},
+		{
+			name: "匿名评论也按真实的 uid 检查 @",
+			comment: domain.Comment{
+				Commentator: domain.User{ID: 10},
+				Anonymous:   true,
+				MentionUids: []int64{21},
+			},
+			blocked: map[int64][]int64{21: {10}},
+			wantErr: ErrBlockedByMentioned,
+		},
+		{
+			name: "@ 的人太多了",
+			comment: domain.Comment{
+				Commentator: domain.User{ID: 10},
+				MentionUids: make([]int64, maxMentions+1),
+			},
+			wantErr: ErrTooManyMentions,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			aliasRepo := &memoryAliasRepo{seqs: map[int64]map[int64]int32{100: {20: 1}}}
+			producer := &fakeProducer{}
+			svc := &commentService{
+				repo:       &memoryCommentRepo{comments: existing},
+				aliasRepo:  aliasRepo,
+				blockRepo:  &memoryBlockRepo{blocked: tc.blocked},
+				uidGetters: map[commentv1.Biz]UIDGetter{commentv1.Biz_Evaluation: fakeUIDGetter{uid: bizPublisher}},
+				producer:   producer,
+				idGen:      &fakeIdGenerator{next: 999},
+			}
+			comment := tc.comment
+			comment.Biz = commentv1.Biz_Evaluation
+			comment.BizId = 1
+			comment.Content = "同意"
+			id, err := svc.CreateComment(context.Background(), comment)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				assert.Empty(t, producer.writes)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantEvent.Id, id)
+			require.Len(t, producer.writes, 1)
+			evt := producer.writes[0]
+			// 时间和渲染的内容不关心
+			evt.Ctime = 0
+			evt.Content, evt.ContentHTML = "", ""
+			tc.wantEvent.Biz = int32(commentv1.Biz_Evaluation)
+			tc.wantEvent.BizId = 1
+			tc.wantEvent.BizPublisher = bizPublisher
+			assert.Equal(t, tc.wantEvent, evt)
+			assert.Equal(t, tc.wantSeqs, aliasRepo.seqs)
+		})
+	}
+}
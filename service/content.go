@@ -0,0 +1,22 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/MuxiKeStack/be-comment/pkg/richtext"
+)
+
+var ErrInvalidContentFormat = errors.New("不支持的内容格式")
+
+// renderContent 在服务端渲染成 HTML，客户端不再自己解析 Content
+func renderContent(format domain.ContentFormat, content string) (string, error) {
+	switch format {
+	case domain.ContentFormatPlain:
+		return richtext.RenderPlain(content), nil
+	case domain.ContentFormatMarkdown:
+		return richtext.RenderMarkdown(content)
+	default:
+		return "", fmt.Errorf("%w: %d", ErrInvalidContentFormat, format)
+	}
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/MuxiKeStack/be-comment/domain"
+)
+
+var ErrInvalidCursor = errors.New("无效的翻页游标")
+
+// 游标的版本，游标的内容变了之后旧的游标可以识别出来
+const cursorVersion = 1
+
+type cursorPayload struct {
+	V    int             `json:"v"`
+	Sort domain.SortMode `json:"s"`
+	Key  int64           `json:"k"`
+	Id   int64           `json:"i"`
+}
+
+// EncodeCursor 对客户端来说游标是不透明的，不应该依赖它的内容
+func EncodeCursor(c domain.Cursor) string {
+	data, _ := json.Marshal(cursorPayload{V: cursorVersion, Sort: c.Sort, Key: c.Key, Id: c.Id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor 空字符串表示第一页，返回 nil。游标的排序方式必须和 sort 一致
+func DecodeCursor(val string, sort domain.SortMode) (*domain.Cursor, error) {
+	if val == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(val)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var p cursorPayload
+	err = json.Unmarshal(data, &p)
+	if err != nil || p.V != cursorVersion || p.Sort != sort {
+		return nil, ErrInvalidCursor
+	}
+	return &domain.Cursor{Sort: p.Sort, Key: p.Key, Id: p.Id}, nil
+}
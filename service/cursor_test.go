@@ -0,0 +1,62 @@
+package service
+
+import (
+	"encoding/base64"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	testCases := []struct {
+		name string
+		val  string
+		sort domain.SortMode
+
+		want    *domain.Cursor
+		wantErr error
+	}{
+		{
+			name: "编码之后能解回来",
+			val:  EncodeCursor(domain.Cursor{Sort: domain.SortRecentlyUpdated, Key: 1709251200000, Id: 123}),
+			sort: domain.SortRecentlyUpdated,
+			want: &domain.Cursor{Sort: domain.SortRecentlyUpdated, Key: 1709251200000, Id: 123},
+		},
+		{
+			name: "空字符串是第一页",
+			val:  "",
+			sort: domain.SortNewest,
+		},
+		{
+			name:    "不是 base64",
+			val:     "!!!",
+			sort:    domain.SortNewest,
+			wantErr: ErrInvalidCursor,
+		},
+		{
+			name:    "不是 json",
+			val:     base64.RawURLEncoding.EncodeToString([]byte("abc")),
+			sort:    domain.SortNewest,
+			wantErr: ErrInvalidCursor,
+		},
+		{
+			name:    "版本不对",
+			val:     base64.RawURLEncoding.EncodeToString([]byte(`{"v":0,"s":0,"k":1,"i":1}`)),
+			sort:    domain.SortNewest,
+			wantErr: ErrInvalidCursor,
+		},
+		{
+			name:    "换了排序方式",
+			val:     EncodeCursor(domain.Cursor{Sort: domain.SortNewest, Key: 1, Id: 1}),
+			sort:    domain.SortOldest,
+			wantErr: ErrInvalidCursor,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := DecodeCursor(tc.val, tc.sort)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.want, c)
+		})
+	}
+}
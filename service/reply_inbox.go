@@ -0,0 +1,41 @@
+package service
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/MuxiKeStack/be-comment/repository"
+	"math"
+)
+
+// ReplyInboxService "回复我的"，不依赖 feed 服务，feed 事件丢了也能看到回复
+type ReplyInboxService interface {
+	ListReplies(ctx context.Context, uid int64, curCommentId int64, limit int64) ([]domain.Comment, error)
+	UnreadCount(ctx context.Context, uid int64) (int64, error)
+	// MarkRead commentId 以及比它先落库的回复都标记为已读，commentId 为 0 表示全部已读
+	MarkRead(ctx context.Context, uid int64, commentId int64) error
+}
+
+type replyInboxService struct {
+	repo repository.ReplyInboxRepository
+}
+
+func NewReplyInboxService(repo repository.ReplyInboxRepository) ReplyInboxService {
+	return &replyInboxService{repo: repo}
+}
+
+func (s *replyInboxService) ListReplies(ctx context.Context, uid int64, curCommentId int64, limit int64) ([]domain.Comment, error) {
+	// 第一次查询
+	if curCommentId <= 0 {
+		curCommentId = math.MaxInt64
+	}
+	cs, err := s.repo.FindReplies(ctx, uid, curCommentId, limit)
+	return domain.MaskComments(cs), err
+}
+
+func (s *replyInboxService) UnreadCount(ctx context.Context, uid int64) (int64, error) {
+	return s.repo.GetUnreadCount(ctx, uid)
+}
+
+func (s *replyInboxService) MarkRead(ctx context.Context, uid int64, commentId int64) error {
+	return s.repo.MarkRead(ctx, uid, commentId)
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/MuxiKeStack/be-comment/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+// memoryInboxRepo 记录查询用的游标和被重新统计未读数的人
+type memoryInboxRepo struct {
+	repository.ReplyInboxRepository
+	replies []domain.Comment
+	cursors []int64
+	reset   []int64
+}
+
+func (r *memoryInboxRepo) FindReplies(ctx context.Context, uid int64, curCommentId int64, limit int64) ([]domain.Comment, error) {
+	r.cursors = append(r.cursors, curCommentId)
+	return r.replies, nil
+}
+
+func (r *memoryInboxRepo) ResetUnread(ctx context.Context, uid int64) {
+	r.reset = append(r.reset, uid)
+}
+
+func TestReplyInboxService_ListReplies(t *testing.T) {
+	repo := &memoryInboxRepo{
+		replies: []domain.Comment{
+			{Id: 2, Commentator: domain.User{ID: 10}, ReplyToUid: 1},
+			{Id: 1, Commentator: domain.User{ID: 11}, ReplyToUid: 1, AnonymousSeq: 3},
+		},
+	}
+	svc := NewReplyInboxService(repo)
+	ctx := context.Background()
+	cs, err := svc.ListReplies(ctx, 1, 0, 10)
+	require.NoError(t, err)
+	// 匿名回复不能暴露回复的人
+	assert.Equal(t, domain.User{ID: 10}, cs[0].Commentator)
+	assert.Equal(t, domain.User{Name: domain.Pseudonym(3)}, cs[1].Commentator)
+
+	_, err = svc.ListReplies(ctx, 1, 2, 10)
+	require.NoError(t, err)
+	// 第一页从最新的开始
+	assert.Equal(t, []int64{math.MaxInt64, 2}, repo.cursors)
+}
+
+// deletingCommentRepo DeleteComment 返回预设的被回复的人
+type deletingCommentRepo struct {
+	repository.CommentRepository
+	replyToUids []int64
+	err         error
+}
+
+func (r *deletingCommentRepo) DeleteComment(ctx context.Context, commentId int64, uid int64) ([]int64, error) {
+	return r.replyToUids, r.err
+}
+
+func TestCommentService_DeleteComment(t *testing.T) {
+	testCases := []struct {
+		name        string
+		replyToUids []int64
+		err         error
+
+		wantReset []int64
+		wantErr   error
+	}{
+		{
+			name: "级联删除的回复，被回复的人都要重新统计未读数",
+			// 删除的评论回复了 1，它下面的回复分别回复了 2 和 3
+			replyToUids: []int64{1, 2, 3},
+			wantReset:   []int64{1, 2, 3},
+		},
+		{
+			name: "根评论没有回复任何人",
+		},
+		{
+			name:    "不是自己的评论",
+			err:     repository.ErrPermissionDenied,
+			wantErr: repository.ErrPermissionDenied,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			inbox := &memoryInboxRepo{}
+			svc := &commentService{
+				repo:      &deletingCommentRepo{replyToUids: tc.replyToUids, err: tc.err},
+				inboxRepo: inbox,
+			}
+			err := svc.DeleteComment(context.Background(), 100, 10)
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.wantReset, inbox.reset)
+		})
+	}
+}
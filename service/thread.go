@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/MuxiKeStack/be-comment/repository"
+)
+
+const (
+	defaultThreadNodes = 100
+	maxThreadNodes     = 500
+	// 超过深度限制的节点不计入预算，但是每次扫描的行数最多是预算的这么多倍，避免一次扫太多
+	threadScanFactor = 10
+)
+
+// GetThread 回复都按照整棵树的根评论存了 root_id，按 id 升序扫描，父评论一定先于回复出现，
+// 所以可以边扫边挂到父评论下面。commentId 不是根评论的时候，只保留它的子孙
+func (s *commentService) GetThread(ctx context.Context, commentId int64,
+	maxDepth int, maxNodes int, cursor int64) (domain.Thread, error) {
+	root, err := s.repo.FindById(ctx, commentId)
+	if err != nil {
+		return domain.Thread{}, err
+	}
+	if maxNodes <= 0 {
+		maxNodes = defaultThreadNodes
+	}
+	maxNodes = min(maxNodes, maxThreadNodes)
+	threadRootId := root.Id
+	if root.RootComment != nil && root.RootComment.Id != 0 {
+		threadRootId = root.RootComment.Id
+	}
+	b := newThreadBuilder(s.repo, root, maxDepth)
+	// 子孙的 id 一定比自己大
+	cur := max(cursor, root.Id)
+	pageSize := int64(maxNodes + 1)
+	scanLimit := maxNodes * threadScanFactor
+	scanned := 0
+	hasMore, exhausted := false, false
+	for !hasMore && !exhausted {
+		replies, er := s.repo.GetMoreReplies(ctx, threadRootId, cur, pageSize)
+		if er != nil {
+			return domain.Thread{}, er
+		}
+		exhausted = int64(len(replies)) < pageSize
+		for _, r := range replies {
+			if b.count >= maxNodes || scanned >= scanLimit {
+				// 这一页还有没处理的
+				hasMore = true
+				break
+			}
+			er = b.add(ctx, r)
+			if er != nil {
+				return domain.Thread{}, er
+			}
+			scanned++
+			cur = r.Id
+		}
+	}
+	return b.build(cur, hasMore).Masked(), nil
+}
+
+type threadNode struct {
+	comment  domain.Comment
+	children []*threadNode
+}
+
+type threadBuilder struct {
+	repo     repository.CommentRepository
+	root     *threadNode
+	maxDepth int
+	// 这一页里面的节点，包括根
+	nodes map[int64]*threadNode
+	// 节点到根的深度，-1 表示不在这棵子树里面
+	depths       map[int64]int
+	count        int
+	fragments    []*threadNode
+	truncated    []int64
+	truncatedSet map[int64]struct{}
+}
+
+func newThreadBuilder(repo repository.CommentRepository, root domain.Comment, maxDepth int) *threadBuilder {
+	node := &threadNode{comment: root}
+	return &threadBuilder{
+		repo:     repo,
+		root:     node,
+		maxDepth: maxDepth,
+		nodes:    map[int64]*threadNode{root.Id: node},
+		depths:   map[int64]int{root.Id: 0},
+		// 同一个节点下面可能有多个回复超出了深度
+		truncatedSet: make(map[int64]struct{}),
+	}
+}
+
+func (b *threadBuilder) add(ctx context.Context, c domain.Comment) error {
+	var pid int64
+	if c.ParentComment != nil {
+		pid = c.ParentComment.Id
+	}
+	parentDepth, err := b.depthOf(ctx, pid)
+	if err != nil {
+		return err
+	}
+	if parentDepth < 0 {
+		b.depths[c.Id] = -1
+		return nil
+	}
+	depth := parentDepth + 1
+	b.depths[c.Id] = depth
+	if b.maxDepth > 0 && depth > b.maxDepth {
+		// 更深的节点一定挂在刚超出的那一层下面，只记录那一层的父节点就够了
+		if _, ok := b.truncatedSet[pid]; depth == b.maxDepth+1 && !ok {
+			b.truncatedSet[pid] = struct{}{}
+			b.truncated = append(b.truncated, pid)
+		}
+		return nil
+	}
+	node := &threadNode{comment: c}
+	b.nodes[c.Id] = node
+	b.count++
+	if parent, ok := b.nodes[pid]; ok {
+		parent.children = append(parent.children, node)
+	} else {
+		b.fragments = append(b.fragments, node)
+	}
+	return nil
+}
+
+// depthOf 这一次扫描过的直接取，之前页里面的沿着父评论往上找
+func (b *threadBuilder) depthOf(ctx context.Context, id int64) (int, error) {
+	if id == 0 {
+		return -1, nil
+	}
+	if depth, ok := b.depths[id]; ok {
+		return depth, nil
+	}
+	c, err := b.repo.FindById(ctx, id)
+	if errors.Is(err, repository.ErrCommentNotFound) {
+		b.depths[id] = -1
+		return -1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var pid int64
+	if c.ParentComment != nil {
+		pid = c.ParentComment.Id
+	}
+	depth, err := b.depthOf(ctx, pid)
+	if err != nil {
+		return 0, err
+	}
+	if depth >= 0 {
+		depth++
+	}
+	b.depths[id] = depth
+	return depth, nil
+}
+
+func (b *threadBuilder) build(cursor int64, hasMore bool) domain.Thread {
+	fragments := make([]domain.Comment, 0, len(b.fragments))
+	for _, f := range b.fragments {
+		fragments = append(fragments, f.toDomain())
+	}
+	return domain.Thread{
+		Root:       b.root.toDomain(),
+		Fragments:  fragments,
+		Truncated:  b.truncated,
+		NextCursor: cursor,
+		HasMore:    hasMore,
+	}
+}
+
+func (n *threadNode) toDomain() domain.Comment {
+	c := n.comment
+	if len(n.children) > 0 {
+		c.Children = make([]domain.Comment, 0, len(n.children))
+		for _, child := range n.children {
+			c.Children = append(c.Children, child.toDomain())
+		}
+	}
+	return c
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"github.com/MuxiKeStack/be-comment/domain"
+	"github.com/MuxiKeStack/be-comment/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// memoryThreadRepo 回复树只用到 FindById 和 GetMoreReplies
+type memoryThreadRepo struct {
+	repository.CommentRepository
+	comments map[int64]domain.Comment
+}
+
+// newMemoryThreadRepo parents[id] 是 id 的父评论，0 表示根评论，所有回复都属于 rootId
+func newMemoryThreadRepo(rootId int64, parents map[int64]int64) *memoryThreadRepo {
+	comments := make(map[int64]domain.Comment, len(parents))
+	for id, pid := range parents {
+		c := domain.Comment{Id: id}
+		if pid != 0 {
+			c.RootComment = &domain.Comment{Id: rootId}
+			c.ParentComment = &domain.Comment{Id: pid}
+		}
+		comments[id] = c
+	}
+	return &memoryThreadRepo{comments: comments}
+}
+
+func (r *memoryThreadRepo) FindById(ctx context.Context, commentId int64) (domain.Comment, error) {
+	c, ok := r.comments[commentId]
+	if !ok {
+		return domain.Comment{}, repository.ErrCommentNotFound
+	}
+	return c, nil
+}
+
+func (r *memoryThreadRepo) GetMoreReplies(ctx context.Context, rid int64, curCommentId int64, limit int64) ([]domain.Comment, error) {
+	var res []domain.Comment
+	for _, c := range r.comments {
+		if c.RootComment != nil && c.RootComment.Id == rid && c.Id > curCommentId {
+			res = append(res, c)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Id < res[j].Id
+	})
+	if int64(len(res)) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+// threadShape 把树写成 1(2(3),4) 这样，方便比较
+func threadShape(c domain.Comment) string {
+	var sb strings.Builder
+	sb.WriteString(strconv.FormatInt(c.Id, 10))
+	if len(c.Children) > 0 {
+		children := make([]string, 0, len(c.Children))
+		for _, child := range c.Children {
+			children = append(children, threadShape(child))
+		}
+		sb.WriteString("(" + strings.Join(children, ",") + ")")
+	}
+	return sb.String()
+}
+
+func TestCommentService_GetThread(t *testing.T) {
+	// 1
+	// ├── 2
+	// │   ├── 3
+	// │   │   └── 4
+	// │   └── 6
+	// └── 5
+	parents := map[int64]int64{1: 0, 2: 1, 3: 2, 4: 3, 5: 1, 6: 2}
+	testCases := []struct {
+		name      string
+		commentId int64
+		maxDepth  int
+		maxNodes  int
+		cursor    int64
+
+		wantRoot       string
+		wantFragments  []string
+		wantTruncated  []int64
+		wantNextCursor int64
+		wantHasMore    bool
+		wantErr        error
+	}{
+		{
+			name:           "不限制深度，一页取完",
+			commentId:      1,
+			wantRoot:       "1(2(3(4),6),5)",
+			wantFragments:  []string{},
+			wantNextCursor: 6,
+		},
+		{
+			name:           "超出深度的只记录截断的节点",
+			commentId:      1,
+			maxDepth:       2,
+			wantRoot:       "1(2(3,6),5)",
+			wantFragments:  []string{},
+			wantTruncated:  []int64{3},
+			wantNextCursor: 6,
+		},
+		{
+			name:           "节点用完了还有更多",
+			commentId:      1,
+			maxNodes:       2,
+			wantRoot:       "1(2(3))",
+			wantFragments:  []string{},
+			wantNextCursor: 3,
+			wantHasMore:    true,
+		},
+		{
+			name:      "带着游标继续取，父评论在之前页的成为碎片",
+			commentId: 1,
+			maxNodes:  2,
+			cursor:    3,
+			wantRoot:  "1(5)",
+			// 4 的父评论 3 在上一页
+			wantFragments:  []string{"4"},
+			wantNextCursor: 5,
+			wantHasMore:    true,
+		},
+		{
+			name:          "截断的节点不计入预算，跨页扫描",
+			commentId:     1,
+			maxDepth:      1,
+			maxNodes:      2,
+			wantRoot:      "1(2,5)",
+			wantFragments: []string{},
+			wantTruncated: []int64{2},
+			// 第一页 2、3、4 里面只有 2 计入预算，要接着扫第二页
+			wantNextCursor: 5,
+			wantHasMore:    true,
+		},
+		{
+			name:      "从中间的评论开始只保留子孙",
+			commentId: 2,
+			wantRoot:  "2(3(4),6)",
+			// 5 不是 2 的子孙
+			wantFragments:  []string{},
+			wantNextCursor: 6,
+		},
+		{
+			name:      "评论不存在",
+			commentId: 100,
+			wantErr:   ErrCommentNotFound,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := &commentService{repo: newMemoryThreadRepo(1, parents)}
+			thread, err := svc.GetThread(context.Background(), tc.commentId, tc.maxDepth, tc.maxNodes, tc.cursor)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantRoot, threadShape(thread.Root))
+			fragments := make([]string, 0, len(thread.Fragments))
+			for _, f := range thread.Fragments {
+				fragments = append(fragments, threadShape(f))
+			}
+			assert.Equal(t, tc.wantFragments, fragments)
+			assert.Equal(t, tc.wantTruncated, thread.Truncated)
+			assert.Equal(t, tc.wantNextCursor, thread.NextCursor)
+			assert.Equal(t, tc.wantHasMore, thread.HasMore)
+		})
+	}
+}
@@ -3,34 +3,115 @@
 package main
 
 import (
+	"github.com/MuxiKeStack/be-comment/events"
 	"github.com/MuxiKeStack/be-comment/grpc"
 	"github.com/MuxiKeStack/be-comment/ioc"
-	"github.com/MuxiKeStack/be-comment/pkg/grpcx"
+	"github.com/MuxiKeStack/be-comment/job"
+	"github.com/MuxiKeStack/be-comment/migration"
+	"github.com/MuxiKeStack/be-comment/pkg/saramax"
 	"github.com/MuxiKeStack/be-comment/repository"
-	"github.com/MuxiKeStack/be-comment/repository/cache"
 	"github.com/MuxiKeStack/be-comment/repository/dao"
 	"github.com/MuxiKeStack/be-comment/service"
 	"github.com/google/wire"
 )
 
-func InitGRPCServer() grpcx.Server {
+func InitApp() *App {
 	wire.Build(
 		ioc.InitGRPCxKratosServer,
+		ioc.InitInternalGRPCServer,
 		grpc.NewCommentServiceServer,
+		grpc.NewReplyInboxServiceServer,
+		grpc.NewCommentSearchServiceServer,
+		grpc.NewBlockServiceServer,
+		grpc.NewCommentExportServer,
 		service.NewCommentService,
+		service.NewReplyInboxService,
+		service.NewCommentSearchService,
+		service.NewBlockService,
+		ioc.InitAttachmentPolicy,
+		service.NewCommentExportService,
 		// rpc client
 		ioc.InitEvaluationClient, ioc.InitAnswerClient,
 		// producer
+		ioc.InitSyncProducer,
+		ioc.InitAsyncProducer,
 		ioc.InitProducer,
+		// consumer
+		events.NewCommentWriteConsumer,
+		ioc.InitConsumers,
+		ioc.InitIDGenerator,
+		ioc.InitNodeLease,
 		repository.NewCachedCommentRepo,
-		cache.NewRedisCommentCache,
+		repository.NewCachedReplyInboxRepo,
+		repository.NewAnonymousAliasRepository,
+		repository.NewCachedBlockRepo,
+		repository.NewCommentSearchRepository,
+		ioc.InitCommentCache,
+		ioc.InitReplyInboxCache,
+		ioc.InitBlockCache,
 		dao.NewCommentDAO,
+		dao.NewReplyInboxDAO,
+		dao.NewAnonymousAliasDAO,
+		dao.NewBlockDAO,
+		dao.NewCommentSearchDAO,
+		// job
+		ioc.InitCommentCountReconcileJob,
+		ioc.InitJobRunners,
 		// 第三方
 		ioc.InitKafka,
 		ioc.InitEtcdClient,
 		ioc.InitDB,
 		ioc.InitLogger,
 		ioc.InitRedis,
+		wire.Struct(new(App), "*"),
 	)
-	return grpcx.Server(nil)
+	return new(App)
+}
+
+func InitCommentCountReconcileJob() *job.CommentCountReconcileJob {
+	wire.Build(
+		ioc.InitDB,
+		ioc.InitLogger,
+		ioc.InitRedis,
+		ioc.InitCommentCache,
+		dao.NewCommentDAO,
+		ioc.InitCommentCountReconcileJob,
+	)
+	return new(job.CommentCountReconcileJob)
+}
+
+func InitDLQReplayer() *saramax.Replayer {
+	wire.Build(
+		ioc.InitKafka,
+		ioc.InitLogger,
+		ioc.InitSyncProducer,
+		ioc.InitDLQReplayer,
+	)
+	return new(saramax.Replayer)
+}
+
+func InitLegacyImporter() *migration.LegacyImporter {
+	wire.Build(
+		ioc.InitDB,
+		ioc.InitLogger,
+		ioc.InitRedis,
+		ioc.InitCommentCache,
+		dao.NewCommentDAO,
+		dao.NewLegacyIdMappingDAO,
+		migration.NewLegacyImporter,
+	)
+	return new(migration.LegacyImporter)
+}
+
+func InitCommentExportService() service.CommentExportService {
+	wire.Build(
+		ioc.InitDB,
+		ioc.InitLogger,
+		ioc.InitRedis,
+		ioc.InitCommentCache,
+		dao.NewCommentDAO,
+		repository.NewCachedCommentRepo,
+		service.NewCommentExportService,
+	)
+	return nil
 }
@@ -7,31 +7,114 @@
 package main
 
 import (
+	"github.com/MuxiKeStack/be-comment/events"
 	"github.com/MuxiKeStack/be-comment/grpc"
 	"github.com/MuxiKeStack/be-comment/ioc"
-	"github.com/MuxiKeStack/be-comment/pkg/grpcx"
+	"github.com/MuxiKeStack/be-comment/job"
+	"github.com/MuxiKeStack/be-comment/migration"
+	"github.com/MuxiKeStack/be-comment/pkg/saramax"
 	"github.com/MuxiKeStack/be-comment/repository"
-	"github.com/MuxiKeStack/be-comment/repository/cache"
 	"github.com/MuxiKeStack/be-comment/repository/dao"
 	"github.com/MuxiKeStack/be-comment/service"
 )
 
 // Injectors from wire.go:
 
-func InitGRPCServer() grpcx.Server {
+func InitApp() *App {
 	logger := ioc.InitLogger()
 	db := ioc.InitDB(logger)
 	commentDAO := dao.NewCommentDAO(db)
-	cmdable := ioc.InitRedis()
-	commentCache := cache.NewRedisCommentCache(cmdable)
+	universalClient := ioc.InitRedis()
+	commentCache := ioc.InitCommentCache(universalClient, logger)
 	commentRepository := repository.NewCachedCommentRepo(commentDAO, commentCache, logger)
+	replyInboxDAO := dao.NewReplyInboxDAO(db)
+	replyInboxCache := ioc.InitReplyInboxCache(universalClient)
+	replyInboxRepository := repository.NewCachedReplyInboxRepo(replyInboxDAO, replyInboxCache, logger)
 	client := ioc.InitKafka()
-	producer := ioc.InitProducer(client)
+	syncProducer := ioc.InitSyncProducer(client)
+	asyncProducer := ioc.InitAsyncProducer()
+	producer := ioc.InitProducer(syncProducer, asyncProducer, logger)
 	clientv3Client := ioc.InitEtcdClient()
 	evaluationServiceClient := ioc.InitEvaluationClient(clientv3Client)
 	answerServiceClient := ioc.InitAnswerClient(clientv3Client)
-	commentService := service.NewCommentService(commentRepository, producer, evaluationServiceClient, answerServiceClient, logger)
+	anonymousAliasDAO := dao.NewAnonymousAliasDAO(db)
+	anonymousAliasRepository := repository.NewAnonymousAliasRepository(anonymousAliasDAO)
+	blockDAO := dao.NewBlockDAO(db)
+	blockCache := ioc.InitBlockCache(universalClient)
+	blockRepository := repository.NewCachedBlockRepo(blockDAO, blockCache, logger)
+	nodeLease := ioc.InitNodeLease(universalClient, logger)
+	generator := ioc.InitIDGenerator(nodeLease)
+	attachmentPolicy := ioc.InitAttachmentPolicy()
+	commentService := service.NewCommentService(commentRepository, replyInboxRepository, anonymousAliasRepository, blockRepository, producer, generator, evaluationServiceClient, answerServiceClient, attachmentPolicy, logger)
 	commentServiceServer := grpc.NewCommentServiceServer(commentService)
-	server := ioc.InitGRPCxKratosServer(commentServiceServer, clientv3Client, logger)
-	return server
+	replyInboxService := service.NewReplyInboxService(replyInboxRepository)
+	replyInboxServiceServer := grpc.NewReplyInboxServiceServer(replyInboxService)
+	commentSearchDAO := dao.NewCommentSearchDAO(db)
+	commentSearchRepository := repository.NewCommentSearchRepository(commentSearchDAO)
+	commentSearchService := service.NewCommentSearchService(commentSearchRepository)
+	commentSearchServiceServer := grpc.NewCommentSearchServiceServer(commentSearchService)
+	blockService := service.NewBlockService(blockRepository)
+	blockServiceServer := grpc.NewBlockServiceServer(blockService)
+	server := ioc.InitGRPCxKratosServer(commentServiceServer, replyInboxServiceServer, blockServiceServer, clientv3Client, logger)
+	commentExportService := service.NewCommentExportService(commentRepository)
+	commentExportServer := grpc.NewCommentExportServer(commentExportService)
+	internalServer := ioc.InitInternalGRPCServer(commentExportServer, commentSearchServiceServer)
+	commentWriteConsumer := events.NewCommentWriteConsumer(client, commentRepository, replyInboxRepository, producer, syncProducer, logger)
+	v := ioc.InitConsumers(commentWriteConsumer)
+	commentCountReconcileJob := ioc.InitCommentCountReconcileJob(commentDAO, commentCache, logger)
+	v2 := ioc.InitJobRunners(commentCountReconcileJob, universalClient, logger)
+	app := &App{
+		server:    server,
+		internal:  internalServer,
+		consumers: v,
+		runners:   v2,
+		producer:  producer,
+		idLease:   nodeLease,
+		kafka:     client,
+		db:        db,
+		redis:     universalClient,
+		etcd:      clientv3Client,
+		l:         logger,
+	}
+	return app
+}
+
+func InitCommentCountReconcileJob() *job.CommentCountReconcileJob {
+	logger := ioc.InitLogger()
+	db := ioc.InitDB(logger)
+	commentDAO := dao.NewCommentDAO(db)
+	universalClient := ioc.InitRedis()
+	commentCache := ioc.InitCommentCache(universalClient, logger)
+	commentCountReconcileJob := ioc.InitCommentCountReconcileJob(commentDAO, commentCache, logger)
+	return commentCountReconcileJob
+}
+
+func InitDLQReplayer() *saramax.Replayer {
+	client := ioc.InitKafka()
+	syncProducer := ioc.InitSyncProducer(client)
+	logger := ioc.InitLogger()
+	replayer := ioc.InitDLQReplayer(client, syncProducer, logger)
+	return replayer
+}
+
+func InitLegacyImporter() *migration.LegacyImporter {
+	logger := ioc.InitLogger()
+	db := ioc.InitDB(logger)
+	commentDAO := dao.NewCommentDAO(db)
+	legacyIdMappingDAO := dao.NewLegacyIdMappingDAO(db)
+	universalClient := ioc.InitRedis()
+	commentCache := ioc.InitCommentCache(universalClient, logger)
+	legacyImporter := migration.NewLegacyImporter(commentDAO, legacyIdMappingDAO, commentCache, logger)
+	return legacyImporter
+}
+
+func InitCommentExportService() service.CommentExportService {
+	logger := ioc.InitLogger()
+	db := ioc.InitDB(logger)
+	commentDAO := dao.NewCommentDAO(db)
+	universalClient := ioc.InitRedis()
+	commentCache := ioc.InitCommentCache(universalClient, logger)
+	commentRepository := repository.NewCachedCommentRepo(commentDAO, commentCache, logger)
+	commentExportService := service.NewCommentExportService(commentRepository)
+	return commentExportService
 }